@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/chdb/chessdb/internal/charset"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/polyglot"
+)
+
+func runBook(args []string) {
+	opts := polyglot.DefaultOptions()
+	flags := flag.NewFlagSet("book", flag.ExitOnError)
+	dbPath := flags.String("db", "./chess.db", "Database path")
+	output := flags.String("o", "book.bin", "Output file")
+	flags.IntVar(&opts.MinGames, "min-games", opts.MinGames, "Minimum number of games of a move")
+	flags.IntVar(&opts.MaxPly, "max-ply", opts.MaxPly, "Last ply of a game to add")
+	flags.StringVar(&opts.Weight, "weight", opts.Weight, "Weighting of moves: count or score")
+	params := &models.SearchParams{}
+	flags.StringVar(&params.White, "white", "", "White player")
+	flags.StringVar(&params.Black, "black", "", "Black player")
+	flags.StringVar(&params.Either, "either", "", "Player with either color")
+	flags.StringVar(&params.ECO, "eco", "", "ECO code")
+	flags.StringVar(&params.TimeClass, "time-class", "", "Time class")
+	flags.StringVar(&params.DateFrom, "date-from", "", "Earliest date")
+	flags.StringVar(&params.DateTo, "date-to", "", "Latest date")
+	flags.IntVar(&params.MinElo, "min-elo", 0, "Minimum rating of the player of a move")
+	flags.Parse(args)
+
+	opts.MinElo = params.MinElo
+	if err := opts.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	params.White = charset.Normalize(params.White)
+	params.Black = charset.Normalize(params.Black)
+	params.Either = charset.Normalize(params.Either)
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	builder := polyglot.NewBuilder(opts)
+	err = db.EachGameMoves(params, opts.MaxPly, func(game *models.Game, moves []string) error {
+		builder.AddGame(game.Result, game.WhiteElo, game.BlackElo, moves)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to read games: %v", err)
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *output, err)
+	}
+	defer out.Close()
+
+	entries := builder.Entries()
+	if err := polyglot.WriteBook(out, entries); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d entries from %d games in %v\n", len(entries), builder.Games(), time.Since(start).Round(time.Millisecond))
+}
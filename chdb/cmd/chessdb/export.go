@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chdb/chessdb/internal/charset"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tabular"
+)
+
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := flags.String("db", "./chess.db", "Database path")
+	output := flags.String("o", "", "Output file (default standard output)")
+	format := flags.String("format", "pgn", "Export format: pgn, csv or parquet")
+	rows := flags.String("rows", tabular.RowsGames, "Rows of a table export: games or plies")
+	columns := flags.String("columns", "", "Columns of a table export separated by commas (default all)")
+	ids := flags.String("ids", "", "Game IDs separated by commas")
+	params := &models.SearchParams{}
+	flags.StringVar(&params.White, "white", "", "White player")
+	flags.StringVar(&params.Black, "black", "", "Black player")
+	flags.StringVar(&params.Either, "either", "", "Player with either color")
+	flags.StringVar(&params.ECO, "eco", "", "ECO code")
+	flags.StringVar(&params.Opening, "opening", "", "Opening name")
+	flags.StringVar(&params.Result, "result", "", "Result")
+	flags.StringVar(&params.TimeClass, "time-class", "", "Time class")
+	flags.StringVar(&params.DateFrom, "date-from", "", "Earliest date")
+	flags.StringVar(&params.DateTo, "date-to", "", "Latest date")
+	flags.StringVar(&params.Position, "position", "", "FEN of a position reached in the game")
+	flags.IntVar(&params.MinElo, "min-elo", 0, "Minimum rating")
+	flags.IntVar(&params.MaxElo, "max-elo", 0, "Maximum rating")
+	flags.IntVar(&params.Limit, "limit", 0, "Maximum number of games")
+	flags.Parse(args)
+
+	if *format != "pgn" && !tabular.IsFormat(*format) {
+		log.Fatalf("Unsupported export format: %s", *format)
+	}
+
+	var names []string
+	if *columns != "" {
+		names = strings.Split(*columns, ",")
+	}
+	tableColumns, err := tabular.SelectColumns(*rows, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *ids != "" {
+		for _, field := range strings.Split(*ids, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid game ID: %s", field)
+			}
+			params.IDs = append(params.IDs, id)
+		}
+	}
+
+	params.White = charset.Normalize(params.White)
+	params.Black = charset.Normalize(params.Black)
+	params.Either = charset.Normalize(params.Either)
+	params.Opening = charset.Normalize(params.Opening)
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *output, err)
+		}
+		defer out.Close()
+	}
+
+	start := time.Now()
+	if *format == "pgn" {
+		count, err := db.ExportPGN(out, params)
+		if err != nil {
+			log.Fatalf("Export failed after %d games: %v", count, err)
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d games in %v\n", count, time.Since(start).Round(time.Millisecond))
+		return
+	}
+
+	w, err := tabular.NewWriter(*format, out, tableColumns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	count, err := db.ExportTable(w, params, *rows == tabular.RowsPlies)
+	if err != nil {
+		log.Fatalf("Export failed after %d rows: %v", count, err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rows of %s in %v\n", count, *rows, time.Since(start).Round(time.Millisecond))
+}
@@ -5,15 +5,39 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
 	"github.com/chdb/chessdb/internal/server"
+	"github.com/chdb/chessdb/internal/tablebase"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reclassify":
+			runReclassify(os.Args[2:])
+			return
+		case "reindex":
+			runReindex(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "book":
+			runBook(os.Args[2:])
+			return
+		}
+	}
+
 	var (
-		port   = flag.String("port", "8080", "Server port")
-		dbPath = flag.String("db", "./chess.db", "Database path")
+		port          = flag.String("port", "8080", "Server port")
+		dbPath        = flag.String("db", "./chess.db", "Database path")
+		enginePath    = flag.String("engine", "", "Path to a UCI engine binary")
+		enginePool    = flag.Int("engine-pool", 2, "Maximum number of engine processes")
+		engineOptions = flag.String("engine-options", "", "UCI options as Name=Value pairs separated by commas")
+		syzygyPath    = flag.String("syzygy", "", "Directories of Syzygy tablebase files, separated like PATH")
 	)
 	flag.Parse()
 
@@ -23,22 +47,76 @@ func main() {
 	}
 	defer db.Close()
 
-	router := server.SetupRouter(db)
+	var pool *engine.Pool
+	if *enginePath != "" {
+		pool = engine.NewPool(engine.Config{
+			Path:    *enginePath,
+			Options: parseEngineOptions(*engineOptions),
+		}, *enginePool)
+		defer pool.Close()
+	}
+
+	var tb tablebase.Prober
+	if *syzygyPath != "" {
+		syzygy, err := tablebase.Open(*syzygyPath)
+		if err != nil {
+			log.Fatalf("Failed to open tablebases: %v", err)
+		}
+		defer syzygy.Close()
+		fmt.Printf("Tablebases: %d tables, up to %d pieces\n", len(syzygy.Tables()), syzygy.MaxPieces())
+		tb = syzygy
+	}
+
+	router := server.SetupRouter(db, pool, tb)
 	
 	fmt.Printf("Chess Database Server starting on port %s\n", *port)
 	fmt.Printf("Database: %s\n", *dbPath)
 	fmt.Println("\nAPI Endpoints:")
 	fmt.Println("  POST   /api/v1/games/import         - Import PGN text")
 	fmt.Println("  POST   /api/v1/games/import/file    - Import PGN file")
+	fmt.Println("  POST   /api/v1/games/import/large   - Import files in the background")
+	fmt.Println("  GET    /api/v1/games/import/jobs    - Background import jobs")
 	fmt.Println("  GET    /api/v1/games/search         - Search games")
+	fmt.Println("  GET    /api/v1/games/export         - Export games as PGN, CSV or Parquet")
 	fmt.Println("  POST   /api/v1/games/search/pattern - Search by pattern")
+	fmt.Println("  POST   /api/v1/games/search/moves   - Search by move sequence")
+	fmt.Println("  POST   /api/v1/games/search/similar - Search similar positions")
 	fmt.Println("  GET    /api/v1/games/:id            - Get game by ID")
+	fmt.Println("  GET    /api/v1/games/:id/plies      - Every ply of a game for replay")
+	fmt.Println("  GET    /api/v1/games/:id/motifs     - Tactical motifs of a game")
+	fmt.Println("  POST   /api/v1/games/:id/analysis   - Analyse a game with the engine")
+	fmt.Println("  POST   /api/v1/games/:id/annotate   - Annotate mistakes in a game")
+	fmt.Println("  GET    /api/v1/games/:id/annotations - Stored game annotations")
+	fmt.Println("  POST   /api/v1/analysis/position    - Analyse a position with the engine")
+	fmt.Println("  GET    /api/v1/tablebase            - Probe the endgame tablebases")
+	fmt.Println("  POST   /api/v1/puzzles/extract      - Extract puzzles from stored games")
+	fmt.Println("  GET    /api/v1/puzzles              - Search puzzles")
+	fmt.Println("  POST   /api/v1/repertoires          - Import a repertoire from PGN")
+	fmt.Println("  GET    /api/v1/repertoires/:id/check - Check a player's games against a repertoire")
+	fmt.Println("  GET    /api/v1/training/:user/due   - Training positions due for review")
+	fmt.Println("  POST   /api/v1/collections          - Import a position collection from EPD")
+	fmt.Println("  GET    /api/v1/collections/positions - Search collection positions")
+	fmt.Println("  GET    /api/v1/books/export         - Build a Polyglot opening book")
+	fmt.Println("  POST   /api/v1/books                - Import a Polyglot opening book")
+	fmt.Println("  GET    /api/v1/books/:id/position   - Compare a book with the games in a position")
 	fmt.Println("  DELETE /api/v1/games/:id            - Delete game")
 	fmt.Println("  GET    /api/v1/stats                - Database statistics")
+	fmt.Println("  GET    /api/v1/stats/time-trouble   - Time-trouble statistics of a player")
 	fmt.Println("  GET    /api/v1/health               - Health check")
 	
 	if err := router.Run(":" + *port); err != nil {
 		fmt.Fprintf(os.Stderr, "Server failed to start: %v\n", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
+
+func parseEngineOptions(value string) map[string]string {
+	options := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		name, val, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(name) != "" {
+			options[strings.TrimSpace(name)] = strings.TrimSpace(val)
+		}
+	}
+	return options
+}
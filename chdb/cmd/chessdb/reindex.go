@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chdb/chessdb/internal/database"
+)
+
+func runReindex(args []string) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	dbPath := flags.String("db", "./chess.db", "Database path")
+	flags.Parse(args)
+
+	db, err := database.New(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	total, err := db.ReindexGames(func(done int) {
+		fmt.Printf("\rReindexed %d games", done)
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("Reindexing failed: %v", err)
+	}
+
+	fmt.Printf("Reindexed %d games in %v\n", total, time.Since(start).Round(time.Millisecond))
+}
@@ -7,11 +7,12 @@ import (
 	"time"
 
 	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
 	"github.com/chdb/chessdb/internal/parser"
 )
 
 func main() {
-	fmt.Println("=== Chess Database Channel Demo ===\n")
+	fmt.Print("=== Chess Database Channel Demo ===\n\n")
 
 	// Initialize database
 	db, err := database.New("demo.db")
@@ -68,7 +69,7 @@ func main() {
 	defer cancel()
 	
 	// Create game channel
-	gameChannel := make(chan *database.Game, 10)
+	gameChannel := make(chan *models.Game, 10)
 	progressChannel := make(chan database.ImportProgress, 10)
 	
 	// Start import in background
@@ -76,23 +77,15 @@ func main() {
 		defer close(gameChannel)
 		for _, game := range games {
 			if game != nil {
-				// Convert to database.Game (simplified)
-				dbGame := &database.Game{
-					White:  game.White,
-					Black:  game.Black,
-					Result: game.Result,
-					Event:  game.Event,
-					PGN:    game.PGN,
-					Moves:  game.Moves,
-				}
-				gameChannel <- dbGame
+				gameChannel <- game
 			}
 		}
 	}()
 	
-	// Monitor progress
+	// Monitor progress until the importer closes the channel
+	monitorDone := make(chan struct{})
 	go func() {
-		defer close(progressChannel)
+		defer close(monitorDone)
 		for progress := range progressChannel {
 			fmt.Printf("   Progress: %d imported, %d failed, current: %s\n", 
 				progress.Imported, progress.Failed, progress.CurrentGame)
@@ -100,16 +93,16 @@ func main() {
 	}()
 	
 	start = time.Now()
-	err = importer.ImportWithChannels(ctx, gameChannel, progressChannel)
+	result, err := importer.ImportWithChannels(ctx, gameChannel, progressChannel)
 	importTime := time.Since(start)
+	<-monitorDone
 	
 	if err != nil {
 		fmt.Printf("   Import error: %v\n", err)
 	}
 	
-	imported, failed := importer.GetStats()
 	fmt.Printf("   Import completed in %v: %d imported, %d failed\n", 
-		importTime, imported, failed)
+		importTime, result.ImportedGames, result.FailedGames)
 	fmt.Println()
 
 	// Demo 3: Channel patterns showcase
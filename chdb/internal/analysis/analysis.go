@@ -0,0 +1,189 @@
+// Package analysis runs engine analysis through the engine pool, reusing the
+// evaluations stored in the database for positions that were already searched
+// deep enough and storing new results for every game that reaches them later.
+package analysis
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/tablebase"
+)
+
+var ErrNoEngine = errors.New("no engine configured")
+
+type Analyzer struct {
+	db   *database.DB
+	pool *engine.Pool
+	tb   tablebase.Prober
+}
+
+// New creates an analyzer. The tablebase prober is optional and only used to
+// check endgame moves during annotation.
+func New(db *database.DB, pool *engine.Pool, tb tablebase.Prober) *Analyzer {
+	return &Analyzer{db: db, pool: pool, tb: tb}
+}
+
+// AnalyzePosition returns a stored evaluation when one satisfies the limits,
+// otherwise it runs the engine and stores the result.
+func (a *Analyzer) AnalyzePosition(ctx context.Context, fen string, limits engine.Limits, refresh bool) (*engine.Analysis, error) {
+	results, err := a.AnalyzePositions(ctx, []string{fen}, limits, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// AnalyzePositions works like AnalyzePosition for a list of FENs, sending only
+// the positions without a usable stored evaluation to the engine pool.
+func (a *Analyzer) AnalyzePositions(ctx context.Context, fens []string, limits engine.Limits, refresh bool) ([]*engine.Analysis, error) {
+	results := make([]*engine.Analysis, len(fens))
+	var missing []int
+
+	for i, fen := range fens {
+		if !refresh {
+			cached, err := a.lookup(fen, limits)
+			if err != nil {
+				return nil, err
+			}
+			if cached != nil {
+				results[i] = cached
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+	if a.pool == nil {
+		return nil, ErrNoEngine
+	}
+
+	pending := make([]string, len(missing))
+	for j, i := range missing {
+		pending[j] = fens[i]
+	}
+
+	analysed, err := a.pool.AnalyzePositions(ctx, pending, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	var evals []database.Evaluation
+	for j, i := range missing {
+		results[i] = analysed[j]
+		evals = append(evals, toEvaluations(analysed[j])...)
+	}
+
+	if err := a.db.SaveEvaluations(evals); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// lookup returns the stored lines for a position if every requested line was
+// searched at least to the requested depth. Searches bounded only by time or
+// nodes cannot be compared with stored depths and always run the engine.
+// Position hashes leave out castling and en passant rights, so lines stored
+// for a FEN that differs in them are not used: their moves may be illegal.
+func (a *Analyzer) lookup(fen string, limits engine.Limits) (*engine.Analysis, error) {
+	depth := limits.Depth
+	if depth <= 0 {
+		if limits.MoveTime > 0 || limits.Nodes > 0 {
+			return nil, nil
+		}
+		depth = engine.DefaultDepth
+	}
+	multiPV := limits.MultiPV
+	if multiPV <= 0 {
+		multiPV = 1
+	}
+
+	evals, err := a.db.GetEvaluations(database.HashPosition(fen))
+	if err != nil {
+		return nil, err
+	}
+
+	best := make(map[int]database.Evaluation)
+	for _, e := range evals {
+		if evalKey(e.FEN) != evalKey(fen) {
+			continue
+		}
+		if _, ok := best[e.MultiPV]; !ok && e.Depth >= depth {
+			best[e.MultiPV] = e
+		}
+	}
+
+	lines := make([]engine.Line, 0, multiPV)
+	for rank := 1; rank <= multiPV; rank++ {
+		e, ok := best[rank]
+		if !ok {
+			return nil, nil
+		}
+		lines = append(lines, engine.Line{
+			MultiPV: e.MultiPV,
+			Depth:   e.Depth,
+			Score:   engine.Score{CP: e.ScoreCP, Mate: e.ScoreMate},
+			Nodes:   e.Nodes,
+			PV:      strings.Fields(e.PV),
+		})
+	}
+
+	first := best[1]
+	analysis := &engine.Analysis{
+		FEN:      fen,
+		Engine:   strings.TrimSpace(first.EngineName + " " + first.EngineVersion),
+		BestMove: first.BestMove,
+		Lines:    lines,
+		Cached:   true,
+	}
+	for _, line := range lines {
+		if line.Depth > analysis.Depth {
+			analysis.Depth = line.Depth
+		}
+	}
+
+	return analysis, nil
+}
+
+// evalKey returns the placement, side to move, castling and en passant fields
+// of a FEN, which decide the legal moves.
+func evalKey(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+func toEvaluations(analysis *engine.Analysis) []database.Evaluation {
+	name, version := engine.SplitName(analysis.Engine)
+	hash := database.HashPosition(analysis.FEN)
+
+	evals := make([]database.Evaluation, 0, len(analysis.Lines))
+	for _, line := range analysis.Lines {
+		e := database.Evaluation{
+			PositionHash:  hash,
+			FEN:           analysis.FEN,
+			EngineName:    name,
+			EngineVersion: version,
+			MultiPV:       line.MultiPV,
+			Depth:         line.Depth,
+			ScoreCP:       line.Score.CP,
+			ScoreMate:     line.Score.Mate,
+			PV:            strings.Join(line.PV, " "),
+			Nodes:         line.Nodes,
+		}
+		if len(line.PV) > 0 {
+			e.BestMove = line.PV[0]
+		}
+		evals = append(evals, e)
+	}
+	return evals
+}
@@ -0,0 +1,370 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tablebase"
+)
+
+const (
+	Inaccuracy = "inaccuracy"
+	Mistake    = "mistake"
+	Blunder    = "blunder"
+
+	PhaseOpening    = "opening"
+	PhaseMiddlegame = "middlegame"
+	PhaseEndgame    = "endgame"
+)
+
+const (
+	// maxScore caps evaluations so that a missed mate or a collapse in an
+	// already lost position does not dominate the centipawn loss averages.
+	maxScore = 1000
+
+	openingPlies    = 20
+	endgameMaterial = 26
+)
+
+var DefaultThresholds = models.AnnotationThresholds{Inaccuracy: 50, Mistake: 100, Blunder: 300}
+
+var nags = map[string]string{
+	Inaccuracy: "$6",
+	Mistake:    "$2",
+	Blunder:    "$4",
+}
+
+func IsClassification(s string) bool {
+	return s == Inaccuracy || s == Mistake || s == Blunder
+}
+
+func IsPhase(s string) bool {
+	return s == PhaseOpening || s == PhaseMiddlegame || s == PhaseEndgame
+}
+
+// AnnotateGame analyses every position of a stored game and classifies each
+// move by the centipawn loss against the engine's best move. The annotated PGN
+// is only generated when writePGN is set.
+func (a *Analyzer) AnnotateGame(ctx context.Context, game *models.Game, limits engine.Limits, thresholds models.AnnotationThresholds, refresh, writePGN bool) (*models.GameAnalysis, error) {
+	positions, err := a.db.GetGamePositions(game.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fens := []string{chess.StartingPosition().String()}
+	for _, pos := range positions {
+		fens = append(fens, pos.FEN)
+	}
+
+	limits.MultiPV = 1
+	results, err := a.AnalyzePositions(ctx, fens, limits, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	boards := make([]*chess.Position, len(fens))
+	scores := make([]int, len(fens))
+	for i, fen := range fens {
+		boards[i] = &chess.Position{}
+		if err := boards[i].UnmarshalText([]byte(fen)); err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i, err)
+		}
+		scores[i] = scoreOf(boards[i], results[i])
+	}
+
+	analysis := &models.GameAnalysis{GameID: game.ID, Thresholds: thresholds}
+	for _, result := range results {
+		if len(result.Lines) == 0 {
+			continue
+		}
+		if analysis.Engine == "" {
+			analysis.Engine = result.Engine
+		}
+		if analysis.Depth == 0 || result.Depth < analysis.Depth {
+			analysis.Depth = result.Depth
+		}
+	}
+
+	var white, black []models.MoveAnnotation
+	for ply := 1; ply < len(fens); ply++ {
+		before := boards[ply-1]
+		move := moveBetween(before, boards[ply])
+		if move == nil {
+			return nil, fmt.Errorf("ply %d: no legal move leads to %s", ply, fens[ply])
+		}
+
+		annotation := models.MoveAnnotation{
+			Ply:      ply,
+			Color:    before.Turn().String(),
+			SAN:      chess.AlgebraicNotation{}.Encode(before, move),
+			Phase:    phaseOf(before, ply),
+			CPBefore: scores[ply-1],
+			CPAfter:  scores[ply],
+		}
+
+		loss := annotation.CPBefore - annotation.CPAfter
+		if before.Turn() == chess.Black {
+			loss = -loss
+		}
+		if loss > 0 {
+			annotation.CPLoss = loss
+		}
+		annotation.Accuracy = moveAccuracy(annotation.CPBefore, annotation.CPAfter, before.Turn())
+		annotation.Classification = classify(annotation.CPLoss, thresholds)
+		annotation.TablebaseBefore, annotation.TablebaseAfter = a.probeMove(fens[ply-1], fens[ply])
+
+		if best := legalMove(before, results[ply-1].BestMove); best != nil {
+			annotation.BestMove = chess.AlgebraicNotation{}.Encode(before, best)
+		}
+
+		analysis.Moves = append(analysis.Moves, annotation)
+		if annotation.Color == "w" {
+			white = append(white, annotation)
+		} else {
+			black = append(black, annotation)
+		}
+	}
+
+	analysis.White = summarize(white)
+	analysis.Black = summarize(black)
+
+	if writePGN {
+		tags, err := a.db.GetGameTags(game.ID)
+		if err != nil {
+			return nil, err
+		}
+		analysis.AnnotatedPGN = annotatedPGN(game, tags, analysis)
+	}
+
+	if err := a.db.SaveGameAnalysis(analysis); err != nil {
+		return nil, err
+	}
+
+	return a.db.GetGameAnalysis(game.ID)
+}
+
+// probeMove looks up the tablebase result before and after a move, both from
+// the point of view of the player making it. Either is nil when the position
+// cannot be probed.
+func (a *Analyzer) probeMove(before, after string) (*int, *int) {
+	if a.tb == nil {
+		return nil, nil
+	}
+
+	var wdlBefore, wdlAfter *int
+	if result, err := a.tb.Probe(before); err == nil {
+		wdl := result.WDL
+		wdlBefore = &wdl
+	}
+	if result, err := a.tb.Probe(after); err == nil {
+		wdl := -result.WDL
+		wdlAfter = &wdl
+	}
+	return wdlBefore, wdlAfter
+}
+
+// tablebaseComment describes a move that changed the tablebase result.
+func tablebaseComment(m models.MoveAnnotation) string {
+	if m.TablebaseBefore == nil || m.TablebaseAfter == nil || *m.TablebaseAfter >= *m.TablebaseBefore {
+		return ""
+	}
+	if *m.TablebaseBefore > tablebase.Draw {
+		return "Throws away the win."
+	}
+	return "Throws away the draw."
+}
+
+// scoreOf returns the evaluation of a position in centipawns from White's
+// point of view, scoring mates and finished games at the cap.
+func scoreOf(pos *chess.Position, result *engine.Analysis) int {
+	switch pos.Status() {
+	case chess.Checkmate:
+		if pos.Turn() == chess.White {
+			return -maxScore
+		}
+		return maxScore
+	case chess.Stalemate:
+		return 0
+	}
+	if len(result.Lines) == 0 {
+		return 0
+	}
+
+	score := result.Lines[0].Score
+	switch {
+	case score.Mate != nil && *score.Mate > 0:
+		return maxScore
+	case score.Mate != nil:
+		return -maxScore
+	case score.CP != nil && *score.CP > maxScore:
+		return maxScore
+	case score.CP != nil && *score.CP < -maxScore:
+		return -maxScore
+	case score.CP != nil:
+		return *score.CP
+	}
+	return 0
+}
+
+func moveBetween(before, after *chess.Position) *chess.Move {
+	target := positionKey(after.String())
+	for _, m := range before.ValidMoves() {
+		if positionKey(before.Update(m).String()) == target {
+			return m
+		}
+	}
+	return nil
+}
+
+// legalMove decodes a UCI move reported by the engine, returning nil if it is
+// not legal in the position.
+func legalMove(pos *chess.Position, uci string) *chess.Move {
+	for _, m := range pos.ValidMoves() {
+		if (chess.UCINotation{}).Encode(pos, m) == uci {
+			return m
+		}
+	}
+	return nil
+}
+
+func positionKey(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 3 {
+		fields = fields[:3]
+	}
+	return strings.Join(fields, " ")
+}
+
+// phaseOf treats the first openingPlies plies as the opening unless the
+// queens and pieces left on the board already make it an endgame.
+func phaseOf(pos *chess.Position, ply int) string {
+	material := 0
+	for _, piece := range pos.Board().SquareMap() {
+		switch piece.Type() {
+		case chess.Queen:
+			material += 9
+		case chess.Rook:
+			material += 5
+		case chess.Bishop, chess.Knight:
+			material += 3
+		}
+	}
+
+	switch {
+	case material <= endgameMaterial:
+		return PhaseEndgame
+	case ply <= openingPlies:
+		return PhaseOpening
+	}
+	return PhaseMiddlegame
+}
+
+func classify(loss int, thresholds models.AnnotationThresholds) string {
+	switch {
+	case thresholds.Blunder > 0 && loss >= thresholds.Blunder:
+		return Blunder
+	case thresholds.Mistake > 0 && loss >= thresholds.Mistake:
+		return Mistake
+	case thresholds.Inaccuracy > 0 && loss >= thresholds.Inaccuracy:
+		return Inaccuracy
+	}
+	return ""
+}
+
+// winPercent converts a centipawn score into White's expected winning
+// chances, using the curve lichess fitted to rated games.
+func winPercent(cp int) float64 {
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*float64(cp)))-1)
+}
+
+// moveAccuracy rates a move from 0 to 100 by the winning chances it gave up.
+func moveAccuracy(before, after int, color chess.Color) float64 {
+	winBefore, winAfter := winPercent(before), winPercent(after)
+	if color == chess.Black {
+		winBefore, winAfter = 100-winBefore, 100-winAfter
+	}
+	if winAfter >= winBefore {
+		return 100
+	}
+	accuracy := 103.1668100711649*math.Exp(-0.04354415386753951*(winBefore-winAfter)) - 3.166924740191411
+	return round1(math.Max(0, math.Min(100, accuracy)))
+}
+
+func summarize(moves []models.MoveAnnotation) models.PlayerAnalysis {
+	var player models.PlayerAnalysis
+	if len(moves) == 0 {
+		return player
+	}
+
+	var loss, accuracy float64
+	for _, m := range moves {
+		loss += float64(m.CPLoss)
+		accuracy += m.Accuracy
+		switch m.Classification {
+		case Inaccuracy:
+			player.Inaccuracies++
+		case Mistake:
+			player.Mistakes++
+		case Blunder:
+			player.Blunders++
+		}
+	}
+
+	player.ACPL = round1(loss / float64(len(moves)))
+	player.Accuracy = round1(accuracy / float64(len(moves)))
+	return player
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// annotatedPGN writes the game with its stored tags and the engine as
+// Annotator, an [%eval] comment after every move and a NAG plus the engine's
+// preferred move after every classified one.
+func annotatedPGN(game *models.Game, tags []models.Tag, analysis *models.GameAnalysis) string {
+	var header strings.Builder
+	for _, tag := range tags {
+		if tag.Name != "Annotator" {
+			header.WriteString(database.FormatTag(tag.Name, tag.Value) + "\n")
+		}
+	}
+	header.WriteString(database.FormatTag("Annotator", analysis.Engine) + "\n\n")
+
+	var tokens []string
+	for _, m := range analysis.Moves {
+		moveNumber := (m.Ply + 1) / 2
+		if m.Color == "w" {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber))
+		} else {
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber))
+		}
+		tokens = append(tokens, m.SAN)
+
+		comment := fmt.Sprintf("[%%eval %.2f]", float64(m.CPAfter)/100)
+		if nag, ok := nags[m.Classification]; ok {
+			tokens = append(tokens, nag)
+			comment += fmt.Sprintf(" %s%s.", strings.ToUpper(m.Classification[:1]), m.Classification[1:])
+			if m.BestMove != "" && m.BestMove != m.SAN {
+				comment += " " + m.BestMove + " was best."
+			}
+		}
+		if tb := tablebaseComment(m); tb != "" {
+			comment += " " + tb
+		}
+		tokens = append(tokens, "{ "+comment+" }")
+	}
+
+	annotated := *game
+	annotated.PGN = header.String() + strings.Join(tokens, " ")
+
+	var sb strings.Builder
+	database.WritePGN(&sb, &annotated)
+	return sb.String()
+}
@@ -0,0 +1,310 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/motif"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+const (
+	// A puzzle needs a best move that leaves the solver clearly winning while
+	// the second best move does not, measured in winning chances.
+	puzzleMinWin = 70
+	puzzleMinGap = 30
+
+	maxSolutionMoves = 3
+	maxMateMoves     = 5
+)
+
+// ExtractPuzzles finds the positions of a game where the side to move had a
+// single clearly winning continuation and stores them as puzzles, replacing
+// those previously extracted from the game. Without an engine only stored
+// evaluations with at least two lines are used.
+func (a *Analyzer) ExtractPuzzles(ctx context.Context, gameID int64, limits engine.Limits) ([]*models.Puzzle, error) {
+	positions, err := a.db.GetGamePositions(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	fens := []string{chess.StartingPosition().String()}
+	for _, pos := range positions {
+		fens = append(fens, pos.FEN)
+	}
+
+	boards := make([]*chess.Position, len(fens))
+	for i, fen := range fens {
+		boards[i] = &chess.Position{}
+		if err := boards[i].UnmarshalText([]byte(fen)); err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i, err)
+		}
+	}
+
+	plies := make([]motif.Ply, 0, len(positions))
+	for i := 1; i < len(fens); i++ {
+		m := moveBetween(boards[i-1], boards[i])
+		if m == nil {
+			return nil, fmt.Errorf("ply %d: no legal move leads to %s", i, fens[i])
+		}
+		plies = append(plies, motif.Ply{Number: i, FEN: fens[i], From: m.S1().String(), To: m.S2().String()})
+	}
+
+	limits.MultiPV = 2
+	results, err := a.evaluate(ctx, fens[1:], limits)
+	if err != nil {
+		return nil, err
+	}
+
+	var puzzles []*models.Puzzle
+	for i := 0; i < len(results); i++ {
+		ply := i + 1
+		if results[i] == nil || len(results[i].Lines) < 2 {
+			continue
+		}
+
+		puzzle, err := a.buildPuzzle(ctx, boards[ply], results[i], ply, plies[:ply], limits)
+		if err != nil {
+			return nil, err
+		}
+		if puzzle == nil {
+			continue
+		}
+		puzzles = append(puzzles, puzzle)
+		i += len(puzzle.Solution)
+	}
+
+	if err := a.db.SavePuzzles(gameID, puzzles); err != nil {
+		return nil, err
+	}
+
+	return puzzles, nil
+}
+
+// evaluate analyses the positions with the engine when one is configured and
+// otherwise returns the usable stored evaluations, leaving the rest nil.
+func (a *Analyzer) evaluate(ctx context.Context, fens []string, limits engine.Limits) ([]*engine.Analysis, error) {
+	if a.pool != nil {
+		return a.AnalyzePositions(ctx, fens, limits, false)
+	}
+
+	if limits.Depth <= 0 && limits.MoveTime <= 0 && limits.Nodes <= 0 {
+		limits.Depth = 1
+	}
+
+	results := make([]*engine.Analysis, len(fens))
+	for i, fen := range fens {
+		cached, err := a.lookup(fen, limits)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = cached
+	}
+	return results, nil
+}
+
+// buildPuzzle returns the puzzle of a position whose best move is the only
+// clearly winning one, following the engine's line for the solution, or nil.
+func (a *Analyzer) buildPuzzle(ctx context.Context, pos *chess.Position, result *engine.Analysis, ply int, history []motif.Ply, limits engine.Limits) (*models.Puzzle, error) {
+	color := pos.Turn()
+	if !onlyMove(result, color) {
+		return nil, nil
+	}
+	best := result.Lines[0]
+
+	maxPlies := 2*maxSolutionMoves - 1
+	if best.Score.Mate != nil {
+		maxPlies = 2*maxMateMoves - 1
+	}
+
+	puzzle := &models.Puzzle{
+		Ply:      ply,
+		FEN:      pos.String(),
+		Color:    color.String(),
+		EvalCP:   best.Score.CP,
+		EvalMate: best.Score.Mate,
+	}
+
+	plies := append([]motif.Ply(nil), history...)
+	current := pos
+	for i, uci := range best.PV {
+		if i >= maxPlies {
+			break
+		}
+		m := legalMove(current, uci)
+		if m == nil {
+			break
+		}
+		puzzle.Solution = append(puzzle.Solution, uci)
+		puzzle.SolutionSAN = append(puzzle.SolutionSAN, chess.AlgebraicNotation{}.Encode(current, m))
+		current = current.Update(m)
+		plies = append(plies, motif.Ply{Number: ply + i + 1, FEN: current.String(), From: m.S1().String(), To: m.S2().String()})
+	}
+
+	// The solution always ends with a move of the solver.
+	if len(puzzle.Solution)%2 == 0 {
+		puzzle.Solution = puzzle.Solution[:len(puzzle.Solution)-1]
+		puzzle.SolutionSAN = puzzle.SolutionSAN[:len(puzzle.SolutionSAN)-1]
+	}
+	if len(puzzle.Solution) == 0 {
+		return nil, nil
+	}
+
+	forced, err := a.forcedMoves(ctx, pos, puzzle.Solution, limits)
+	if err != nil {
+		return nil, err
+	}
+	puzzle.Solution = puzzle.Solution[:forced]
+	puzzle.SolutionSAN = puzzle.SolutionSAN[:forced]
+	plies = plies[:len(history)+forced]
+
+	puzzle.Themes = puzzleThemes(pos, best.Score, color, ply, len(history), plies, len(puzzle.Solution))
+	puzzle.Rating = puzzleRating(pos, puzzle)
+	return puzzle, nil
+}
+
+// onlyMove reports whether the best line of a search leaves the given color
+// clearly winning while the second best line does not.
+func onlyMove(result *engine.Analysis, color chess.Color) bool {
+	if result == nil || len(result.Lines) < 2 {
+		return false
+	}
+	best, second := result.Lines[0], result.Lines[1]
+
+	winBest := solverWin(best.Score, color)
+	if winBest < puzzleMinWin || winBest-solverWin(second.Score, color) < puzzleMinGap {
+		return false
+	}
+	if best.Score.Mate != nil && second.Score.Mate != nil && (*second.Score.Mate > 0) == (color == chess.White) {
+		return false
+	}
+	return true
+}
+
+// forcedMoves returns how much of a solution is forced. Each later move of
+// the solver must again be the best and only clearly winning move of its
+// position, otherwise the solution ends with the solver's previous move.
+func (a *Analyzer) forcedMoves(ctx context.Context, pos *chess.Position, solution []string, limits engine.Limits) (int, error) {
+	var fens []string
+	current := pos
+	for i, uci := range solution {
+		if i > 0 && i%2 == 0 {
+			fens = append(fens, current.String())
+		}
+		current = current.Update(legalMove(current, uci))
+	}
+	if len(fens) == 0 {
+		return len(solution), nil
+	}
+
+	results, err := a.evaluate(ctx, fens, limits)
+	if err != nil {
+		return 0, err
+	}
+
+	for j, result := range results {
+		move := 2 * (j + 1)
+		if !onlyMove(result, pos.Turn()) || len(result.Lines[0].PV) == 0 || result.Lines[0].PV[0] != solution[move] {
+			return move - 1, nil
+		}
+	}
+	return len(solution), nil
+}
+
+// solverWin returns the winning chances of the given color for a score.
+func solverWin(score engine.Score, color chess.Color) float64 {
+	var cp int
+	switch {
+	case score.Mate != nil && *score.Mate > 0:
+		cp = maxScore
+	case score.Mate != nil:
+		cp = -maxScore
+	case score.CP != nil:
+		cp = *score.CP
+	}
+
+	win := winPercent(cp)
+	if color == chess.Black {
+		win = 100 - win
+	}
+	return win
+}
+
+func puzzleThemes(pos *chess.Position, score engine.Score, color chess.Color, ply, historyLen int, plies []motif.Ply, solutionLen int) []string {
+	seen := make(map[string]bool)
+	var themes []string
+	add := func(theme string) {
+		if !seen[theme] {
+			seen[theme] = true
+			themes = append(themes, theme)
+		}
+	}
+
+	add(phaseOf(pos, ply))
+
+	// A mate is only a theme when the solution delivers it.
+	if score.Mate != nil && abs(*score.Mate) == (solutionLen+1)/2 {
+		add("mate")
+		add(fmt.Sprintf("mateIn%d", (solutionLen+1)/2))
+	} else if solverWin(score, color) >= 90 {
+		add("crushing")
+	} else {
+		add("advantage")
+	}
+
+	switch moves := (solutionLen + 1) / 2; {
+	case moves == 1:
+		add("oneMove")
+	case moves == 2:
+		add("short")
+	default:
+		add("long")
+	}
+
+	for _, tag := range motif.Detect(plies) {
+		if tag.Ply > historyLen && tag.Color == color.String() {
+			add(tag.Motif)
+		}
+	}
+
+	return themes
+}
+
+// puzzleRating estimates difficulty from the length of the solution and from
+// how forcing its first move looks: checks and captures are found first, quiet
+// moves and sacrifices are harder to see.
+func puzzleRating(pos *chess.Position, puzzle *models.Puzzle) int {
+	rating := 1000 + 200*((len(puzzle.Solution)+1)/2-1)
+
+	first := legalMove(pos, puzzle.Solution[0])
+	if first != nil && !first.HasTag(chess.Check) && !first.HasTag(chess.Capture) {
+		rating += 300
+	}
+
+	for _, theme := range puzzle.Themes {
+		switch theme {
+		case motif.Sacrifice:
+			rating += 250
+		case motif.DiscoveredAttack, motif.Skewer, motif.Pin:
+			rating += 100
+		}
+	}
+
+	if puzzle.EvalMate == nil && solverWin(engine.Score{CP: puzzle.EvalCP}, pos.Turn()) < 90 {
+		rating += 150
+	}
+
+	if rating > 2800 {
+		rating = 2800
+	}
+	return rating
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
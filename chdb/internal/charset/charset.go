@@ -0,0 +1,73 @@
+// Package charset decodes imported files to UTF-8 and normalizes their text,
+// so names with diacritics compare equal whatever encoding they came in.
+package charset
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Auto detects the encoding of the content.
+const Auto = "auto"
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// Decode converts content in the named encoding, such as "windows-1252",
+// "latin1" or "utf-16le", to NFC-normalized UTF-8 and returns it with the
+// name of the encoding used. With no name or Auto the encoding is detected.
+func Decode(content []byte, name string) (string, string, error) {
+	var enc encoding.Encoding
+	if name == "" || strings.EqualFold(name, Auto) {
+		enc, name = Detect(content)
+	} else {
+		var err error
+		enc, err = htmlindex.Get(name)
+		if err != nil {
+			return "", "", fmt.Errorf("unknown encoding %q", name)
+		}
+		if canonical, err := htmlindex.Name(enc); err == nil {
+			name = canonical
+		}
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(content)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding %s: %w", name, err)
+	}
+	decoded = bytes.TrimPrefix(decoded, utf8BOM)
+
+	return norm.NFC.String(string(decoded)), name, nil
+}
+
+// Detect guesses the encoding of content. UTF-16 is recognized by its byte
+// order mark and UTF-8 by being valid; anything else is read as
+// Windows-1252, the superset of Latin-1 that older PGN collections use.
+func Detect(content []byte) (encoding.Encoding, string) {
+	switch {
+	case bytes.HasPrefix(content, utf16LEBOM):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	case bytes.HasPrefix(content, utf16BEBOM):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	case utf8.Valid(content):
+		return unicode.UTF8, "utf-8"
+	}
+	return charmap.Windows1252, "windows-1252"
+}
+
+// Normalize returns s in Unicode normalization form C, so a precomposed "é"
+// and an "e" followed by a combining accent are stored and searched alike.
+func Normalize(s string) string {
+	return norm.NFC.String(s)
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// SaveGameAnalysis replaces the stored analysis and move annotations of a game.
+func (db *DB) SaveGameAnalysis(analysis *models.GameAnalysis) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM move_annotations WHERE game_id = ?", analysis.GameID); err != nil {
+		return err
+	}
+
+	w, b, t := analysis.White, analysis.Black, analysis.Thresholds
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO game_analysis (
+			game_id, engine, depth, inaccuracy_threshold, mistake_threshold, blunder_threshold,
+			white_acpl, white_accuracy, white_inaccuracies, white_mistakes, white_blunders,
+			black_acpl, black_accuracy, black_inaccuracies, black_mistakes, black_blunders,
+			annotated_pgn
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		analysis.GameID, analysis.Engine, analysis.Depth, t.Inaccuracy, t.Mistake, t.Blunder,
+		w.ACPL, w.Accuracy, w.Inaccuracies, w.Mistakes, w.Blunders,
+		b.ACPL, b.Accuracy, b.Inaccuracies, b.Mistakes, b.Blunders,
+		analysis.AnnotatedPGN,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range analysis.Moves {
+		_, err := tx.Exec(`
+			INSERT INTO move_annotations (
+				game_id, ply, color, san, phase, cp_before, cp_after, cp_loss,
+				accuracy, classification, best_move, tablebase_before, tablebase_after
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			analysis.GameID, m.Ply, m.Color, m.SAN, m.Phase, m.CPBefore, m.CPAfter, m.CPLoss,
+			m.Accuracy, m.Classification, m.BestMove, m.TablebaseBefore, m.TablebaseAfter,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetGameAnalysis returns the stored analysis of a game, or nil if the game
+// has not been annotated.
+func (db *DB) GetGameAnalysis(gameID int64) (*models.GameAnalysis, error) {
+	analysis := &models.GameAnalysis{GameID: gameID}
+	w, b, t := &analysis.White, &analysis.Black, &analysis.Thresholds
+
+	err := db.conn.QueryRow(`
+		SELECT engine, depth, inaccuracy_threshold, mistake_threshold, blunder_threshold,
+		       white_acpl, white_accuracy, white_inaccuracies, white_mistakes, white_blunders,
+		       black_acpl, black_accuracy, black_inaccuracies, black_mistakes, black_blunders,
+		       annotated_pgn, created_at
+		FROM game_analysis WHERE game_id = ?`, gameID).Scan(
+		&analysis.Engine, &analysis.Depth, &t.Inaccuracy, &t.Mistake, &t.Blunder,
+		&w.ACPL, &w.Accuracy, &w.Inaccuracies, &w.Mistakes, &w.Blunders,
+		&b.ACPL, &b.Accuracy, &b.Inaccuracies, &b.Mistakes, &b.Blunders,
+		&analysis.AnnotatedPGN, &analysis.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT ply, color, san, phase, cp_before, cp_after, cp_loss, accuracy, classification, best_move,
+		       tablebase_before, tablebase_after
+		FROM move_annotations WHERE game_id = ? ORDER BY ply`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.MoveAnnotation
+		var tbBefore, tbAfter sql.NullInt64
+		err := rows.Scan(
+			&m.Ply, &m.Color, &m.SAN, &m.Phase, &m.CPBefore, &m.CPAfter, &m.CPLoss,
+			&m.Accuracy, &m.Classification, &m.BestMove, &tbBefore, &tbAfter,
+		)
+		if err != nil {
+			return nil, err
+		}
+		m.TablebaseBefore = nullIntPtr(tbBefore)
+		m.TablebaseAfter = nullIntPtr(tbAfter)
+		analysis.Moves = append(analysis.Moves, m)
+	}
+
+	return analysis, rows.Err()
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,8 +15,6 @@ type BatchImporter struct {
 	db           *DB
 	batchSize    int
 	numWorkers   int
-	importStats  atomic.Uint64
-	failedStats  atomic.Uint64
 }
 
 func NewBatchImporter(db *DB, batchSize, numWorkers int) *BatchImporter {
@@ -36,17 +35,119 @@ func NewBatchImporter(db *DB, batchSize, numWorkers int) *BatchImporter {
 type ImportJob struct {
 	Game      *models.Game
 	Positions []Position
+	JobID     string
+	Index     int
+	Size      int
 }
 
+// IndexedGame is a game of an import job with its index in the job's input
+// and the size of its PGN in bytes. Err is set instead of Game for a game
+// that could not be parsed, so that it is counted with the job's failures.
+type IndexedGame struct {
+	Index int
+	Size  int
+	Game  *models.Game
+	Err   error
+}
+
+// ImportProgress reports the counts of one import. BytesProcessed is the
+// size of the PGN of the games processed so far.
 type ImportProgress struct {
 	TotalProcessed uint64
 	Imported       uint64
 	Failed         uint64
+	BytesProcessed uint64
 	CurrentGame    string
 	Timestamp      time.Time
 }
 
-func (bi *BatchImporter) ImportWithChannels(ctx context.Context, gameStream <-chan *models.Game, progressChan chan<- ImportProgress) error {
+// maxImportErrors bounds the errors kept for an import result; failures
+// beyond it are only counted.
+const maxImportErrors = 100
+
+// importStats counts the games of one import, so that concurrent imports
+// each report their own progress.
+type importStats struct {
+	start    time.Time
+	imported atomic.Uint64
+	failed   atomic.Uint64
+	bytes    atomic.Uint64
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (s *importStats) fail(job ImportJob, err error) {
+	s.failed.Add(1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errors) < maxImportErrors {
+		if job.Game != nil {
+			err = fmt.Errorf("%s vs %s: %w", job.Game.White, job.Game.Black, err)
+		}
+		s.errors = append(s.errors, fmt.Sprintf("game %d: %v", job.Index+1, err))
+	}
+}
+
+func (s *importStats) progress(current string) ImportProgress {
+	imported, failed := s.imported.Load(), s.failed.Load()
+	return ImportProgress{
+		TotalProcessed: imported + failed,
+		Imported:       imported,
+		Failed:         failed,
+		BytesProcessed: s.bytes.Load(),
+		CurrentGame:    current,
+		Timestamp:      time.Now(),
+	}
+}
+
+func (s *importStats) result() *models.ImportResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	imported, failed := s.imported.Load(), s.failed.Load()
+	return &models.ImportResult{
+		TotalGames:     int(imported + failed),
+		ImportedGames:  int(imported),
+		FailedGames:    int(failed),
+		Errors:         append([]string(nil), s.errors...),
+		ProcessingTime: time.Since(s.start).Seconds(),
+	}
+}
+
+// ImportWithChannels imports a stream of games and returns the result of
+// the import, which is also returned when it fails or is cancelled.
+// progressChan, if not nil, receives the final counts when the import ends
+// and is then closed, so it must be read until it is closed.
+func (bi *BatchImporter) ImportWithChannels(ctx context.Context, gameStream <-chan *models.Game, progressChan chan<- ImportProgress) (*models.ImportResult, error) {
+	games := make(chan IndexedGame)
+	go func() {
+		defer close(games)
+		index := 0
+		for game := range gameStream {
+			select {
+			case games <- IndexedGame{Index: index, Size: len(game.PGN), Game: game}:
+			case <-ctx.Done():
+			}
+			index++
+		}
+	}()
+
+	return bi.importGames(ctx, "", games, progressChan)
+}
+
+// ImportJobGames imports the games of an import job. Each game is recorded
+// with its index in the transaction that stores it, so that a job resumed
+// after a restart can skip the games it already stored. The result counts
+// only the games of this call. progressChan is handled as by
+// ImportWithChannels.
+func (bi *BatchImporter) ImportJobGames(ctx context.Context, jobID string, games <-chan IndexedGame, progressChan chan<- ImportProgress) (*models.ImportResult, error) {
+	return bi.importGames(ctx, jobID, games, progressChan)
+}
+
+func (bi *BatchImporter) importGames(ctx context.Context, jobID string, gameStream <-chan IndexedGame, progressChan chan<- ImportProgress) (*models.ImportResult, error) {
+	stats := &importStats{start: time.Now()}
 	jobs := make(chan ImportJob, bi.batchSize)
 	errors := make(chan error, bi.numWorkers)
 	
@@ -54,33 +155,55 @@ func (bi *BatchImporter) ImportWithChannels(ctx context.Context, gameStream <-ch
 	
 	for i := 0; i < bi.numWorkers; i++ {
 		wg.Add(1)
-		go bi.importWorker(ctx, jobs, errors, &wg)
+		go bi.importWorker(ctx, stats, jobs, errors, &wg)
 	}
 	
+	// The feeder stops sending once the import is cancelled, so that
+	// progressChan can be closed safely after it returns.
+	fed := make(chan struct{})
 	go func() {
+		defer close(fed)
 		defer close(jobs)
 		
-		for game := range gameStream {
+		for {
+			var indexed IndexedGame
 			select {
+			case game, ok := <-gameStream:
+				if !ok {
+					return
+				}
+				indexed = game
 			case <-ctx.Done():
 				return
-			default:
+			}
+			
+			job := ImportJob{Game: indexed.Game, JobID: jobID, Index: indexed.Index, Size: indexed.Size}
+			current := ""
+			if indexed.Err != nil || indexed.Game == nil {
+				err := indexed.Err
+				if err == nil {
+					err = fmt.Errorf("no game found")
+				}
+				stats.fail(job, err)
+				stats.bytes.Add(uint64(job.Size))
+			} else {
 				parser := &PGNParserHelper{}
-				positions, _ := parser.ExtractPositions(game.Moves)
+				job.Positions, _ = parser.ExtractPositions(job.Game.Moves)
+				AttachComments(job.Positions, Movetext(job.Game.PGN))
+				current = job.Game.White + " vs " + job.Game.Black
 				
-				jobs <- ImportJob{
-					Game:      game,
-					Positions: positions,
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return
 				}
-				
-				if progressChan != nil {
-					progressChan <- ImportProgress{
-						TotalProcessed: bi.importStats.Load() + bi.failedStats.Load(),
-						Imported:       bi.importStats.Load(),
-						Failed:         bi.failedStats.Load(),
-						CurrentGame:    game.White + " vs " + game.Black,
-						Timestamp:      time.Now(),
-					}
+			}
+			
+			if progressChan != nil {
+				select {
+				case progressChan <- stats.progress(current):
+				case <-ctx.Done():
+					return
 				}
 			}
 		}
@@ -97,49 +220,65 @@ func (bi *BatchImporter) ImportWithChannels(ctx context.Context, gameStream <-ch
 			lastErr = err
 		}
 	}
+	<-fed
 	
 	if progressChan != nil {
+		progressChan <- stats.progress("")
 		close(progressChan)
 	}
 	
-	return lastErr
+	return stats.result(), lastErr
 }
 
-func (bi *BatchImporter) importWorker(ctx context.Context, jobs <-chan ImportJob, errors chan<- error, wg *sync.WaitGroup) {
+func (bi *BatchImporter) importWorker(ctx context.Context, stats *importStats, jobs <-chan ImportJob, errors chan<- error, wg *sync.WaitGroup) {
 	defer wg.Done()
 	
 	batch := make([]ImportJob, 0, bi.batchSize)
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 	
+	// The games of a batch are counted once it is committed, so that a
+	// failed commit counts them all as failed.
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
+		defer func() {
+			for _, job := range batch {
+				stats.bytes.Add(uint64(job.Size))
+			}
+			batch = batch[:0]
+		}()
 		
 		tx, err := bi.db.conn.Begin()
 		if err != nil {
 			errors <- err
-			bi.failedStats.Add(uint64(len(batch)))
-			batch = batch[:0]
+			for _, job := range batch {
+				stats.fail(job, err)
+			}
 			return
 		}
 		
-		for _, job := range batch {
-			_, err := bi.insertGameInTx(tx, job.Game, job.Positions)
-			if err != nil {
-				bi.failedStats.Add(1)
-			} else {
-				bi.importStats.Add(1)
-			}
+		failures := make([]error, len(batch))
+		for i, job := range batch {
+			failures[i] = bi.insertJobInTx(tx, job)
 		}
 		
 		if err := tx.Commit(); err != nil {
 			errors <- err
-			bi.failedStats.Add(uint64(len(batch)))
+			for _, job := range batch {
+				stats.fail(job, err)
+			}
+			return
 		}
 		
-		batch = batch[:0]
+		for i, job := range batch {
+			if failures[i] != nil {
+				stats.fail(job, failures[i])
+			} else {
+				stats.imported.Add(1)
+			}
+		}
 	}
 	
 	for {
@@ -165,23 +304,30 @@ func (bi *BatchImporter) importWorker(ctx context.Context, jobs <-chan ImportJob
 	}
 }
 
+// insertJobInTx inserts the game of a job under a savepoint, so that a game
+// failing partway leaves none of its rows in the batch.
+func (bi *BatchImporter) insertJobInTx(tx *sql.Tx, job ImportJob) error {
+	if _, err := tx.Exec("SAVEPOINT import_game"); err != nil {
+		return err
+	}
+	_, err := bi.insertGameInTx(tx, job.Game, job.Positions)
+	if err == nil && job.JobID != "" {
+		err = recordImportedGame(tx, job.JobID, job.Index)
+	}
+	if err != nil {
+		tx.Exec("ROLLBACK TO import_game")
+	}
+	if _, releaseErr := tx.Exec("RELEASE import_game"); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
 func (bi *BatchImporter) insertGameInTx(tx *sql.Tx, game *models.Game, positions []Position) (int64, error) {
-	query := `
-		INSERT INTO games (
-			event, site, date, round, white, black, result,
-			white_elo, black_elo, eco, opening, variation,
-			pgn, moves, fen, positions, position_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	
-	result, err := tx.Exec(query,
-		game.Event, game.Site, game.Date, game.Round,
-		game.White, game.Black, game.Result,
-		game.WhiteElo, game.BlackElo, game.ECO,
-		game.Opening, game.Variation,
-		game.PGN, game.Moves, game.FEN,
-		game.Positions, game.PositionHash,
-	)
+	classifyOpening(game, positions)
+	applyTimeControl(game)
+
+	result, err := tx.Exec(insertGameQuery, gameInsertArgs(game)...)
 	
 	if err != nil {
 		return 0, err
@@ -192,24 +338,13 @@ func (bi *BatchImporter) insertGameInTx(tx *sql.Tx, game *models.Game, positions
 		return 0, err
 	}
 	
-	for _, pos := range positions {
-		_, err = tx.Exec(
-			"INSERT INTO position_index (game_id, move_number, fen, position_hash) VALUES (?, ?, ?, ?)",
-			gameID, pos.MoveNumber, pos.FEN, pos.Hash,
-		)
-		if err != nil {
-			return 0, err
-		}
+	if err := insertTagsInTx(tx, gameID, game.Tags); err != nil {
+		return 0, err
+	}
+	
+	if err := insertPositionsInTx(tx, gameID, positions); err != nil {
+		return 0, err
 	}
 	
 	return gameID, nil
 }
-
-func (bi *BatchImporter) GetStats() (imported, failed uint64) {
-	return bi.importStats.Load(), bi.failedStats.Load()
-}
-
-func (bi *BatchImporter) ResetStats() {
-	bi.importStats.Store(0)
-	bi.failedStats.Store(0)
-}
\ No newline at end of file
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/polyglot"
+)
+
+// SaveBook stores an opening book with its entries, setting its ID.
+func (db *DB) SaveBook(book *models.OpeningBook, entries []polyglot.Entry) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO opening_books (name) VALUES (?)", book.Name)
+	if err != nil {
+		return err
+	}
+	if book.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO book_entries (book_id, key, move, weight, learn) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// SQLite integers are signed, so keys are stored with the same bits as
+	// an int64.
+	positions := make(map[uint64]bool)
+	for _, e := range entries {
+		if _, err := stmt.Exec(book.ID, int64(e.Key), e.Move, e.Weight, e.Learn); err != nil {
+			return err
+		}
+		positions[e.Key] = true
+	}
+	book.Entries = len(entries)
+	book.Positions = len(positions)
+
+	return tx.Commit()
+}
+
+// GetBook returns an opening book, or nil if it does not exist.
+func (db *DB) GetBook(id int64) (*models.OpeningBook, error) {
+	books, err := db.queryBooks("WHERE b.id = ?", id)
+	if err != nil || len(books) == 0 {
+		return nil, err
+	}
+	return books[0], nil
+}
+
+func (db *DB) ListBooks() ([]*models.OpeningBook, error) {
+	return db.queryBooks("")
+}
+
+func (db *DB) queryBooks(where string, args ...interface{}) ([]*models.OpeningBook, error) {
+	rows, err := db.conn.Query(`
+		SELECT b.id, b.name, b.created_at, COUNT(e.book_id), COUNT(DISTINCT e.key)
+		FROM opening_books b
+		LEFT JOIN book_entries e ON e.book_id = b.id
+		`+where+`
+		GROUP BY b.id
+		ORDER BY b.name, b.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []*models.OpeningBook
+	for rows.Next() {
+		book := &models.OpeningBook{}
+		if err := rows.Scan(&book.ID, &book.Name, &book.CreatedAt, &book.Entries, &book.Positions); err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+
+	return books, rows.Err()
+}
+
+func (db *DB) DeleteBook(id int64) error {
+	_, err := db.conn.Exec("DELETE FROM opening_books WHERE id = ?", id)
+	return err
+}
+
+// BookEntries returns the entries of a book for a position key, by
+// decreasing weight.
+func (db *DB) BookEntries(bookID int64, key uint64) ([]polyglot.Entry, error) {
+	rows, err := db.conn.Query(
+		"SELECT move, weight, learn FROM book_entries WHERE book_id = ? AND key = ? ORDER BY weight DESC",
+		bookID, int64(key),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []polyglot.Entry
+	for rows.Next() {
+		e := polyglot.Entry{Key: key}
+		if err := rows.Scan(&e.Move, &e.Weight, &e.Learn); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// EachGameMoves calls fn for every game matching the search with the UCI
+// moves of its first maxPly plies. The game has only its ID, result and
+// ratings set. Iteration stops at the first error returned by fn.
+func (db *DB) EachGameMoves(params *models.SearchParams, maxPly int, fn func(game *models.Game, moves []string) error) error {
+	query, args := searchQuery(params)
+
+	rows, err := db.conn.Query(`
+		SELECT g.id, g.result, COALESCE(g.white_elo, 0), COALESCE(g.black_elo, 0), m.uci
+		FROM (`+query+`) g
+		JOIN game_moves m ON m.game_id = g.id
+		WHERE m.ply <= ?
+		ORDER BY g.id, m.ply`, append(args, maxPly)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var game *models.Game
+	var moves []string
+	for rows.Next() {
+		var row models.Game
+		var uci string
+		if err := rows.Scan(&row.ID, &row.Result, &row.WhiteElo, &row.BlackElo, &uci); err != nil {
+			return err
+		}
+
+		if game == nil || game.ID != row.ID {
+			if game != nil {
+				if err := fn(game, moves); err != nil {
+					return err
+				}
+			}
+			game = &row
+			moves = nil
+		}
+		moves = append(moves, uci)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if game != nil {
+		return fn(game, moves)
+	}
+	return nil
+}
+
+// PositionMoveStats returns the moves played from a position in the database
+// games, with their results, by decreasing number of games.
+func (db *DB) PositionMoveStats(fen string) ([]*models.MoveStats, error) {
+	hash := HashPosition(fen)
+
+	// The starting position is not indexed, as every game begins there.
+	source := "SELECT game_id, move_number FROM position_index WHERE position_hash = ?"
+	if hash == HashPosition(chess.StartingPosition().String()) {
+		source += " UNION SELECT id, 0 FROM games"
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT m.uci, m.san, g.result, COUNT(DISTINCT p.game_id)
+		FROM (`+source+`) p
+		JOIN game_moves m ON m.game_id = p.game_id AND m.ply = p.move_number + 1
+		JOIN games g ON g.id = p.game_id
+		GROUP BY m.uci, m.san, g.result`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	whiteToMove := true
+	if fields := strings.Fields(fen); len(fields) > 1 {
+		whiteToMove = fields[1] != "b"
+	}
+
+	var stats []*models.MoveStats
+	byMove := make(map[string]*models.MoveStats)
+	for rows.Next() {
+		var uci, san, result string
+		var games int
+		if err := rows.Scan(&uci, &san, &result, &games); err != nil {
+			return nil, err
+		}
+
+		s := byMove[uci]
+		if s == nil {
+			s = &models.MoveStats{UCI: uci, SAN: san}
+			byMove[uci] = s
+			stats = append(stats, s)
+		}
+		s.Games += games
+		switch result {
+		case "1-0":
+			s.WhiteWins += games
+		case "0-1":
+			s.BlackWins += games
+		case "1/2-1/2":
+			s.Draws += games
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range stats {
+		wins := s.WhiteWins
+		if !whiteToMove {
+			wins = s.BlackWins
+		}
+		s.Score = (float64(wins) + 0.5*float64(s.Draws)) / float64(s.Games)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Games > stats[j].Games
+	})
+
+	return stats, nil
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// gamesReaching counts the games that reached a collection position.
+const gamesReaching = "(SELECT COUNT(DISTINCT game_id) FROM position_index WHERE position_hash = cp.position_hash)"
+
+// SaveCollection stores a position collection with its positions and their
+// opcodes, setting their IDs.
+func (db *DB) SaveCollection(col *models.PositionCollection) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO position_collections (name) VALUES (?)", col.Name)
+	if err != nil {
+		return err
+	}
+	if col.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	for _, pos := range col.Positions {
+		pos.CollectionID = col.ID
+		pos.PositionKey = HashPosition(pos.FEN)
+
+		result, err := tx.Exec(
+			"INSERT INTO collection_positions (collection_id, line, fen, position_hash) VALUES (?, ?, ?, ?)",
+			col.ID, pos.Line, pos.FEN, pos.PositionKey,
+		)
+		if err != nil {
+			return err
+		}
+		if pos.ID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+
+		for i, op := range pos.Opcodes {
+			_, err := tx.Exec(
+				"INSERT INTO collection_opcodes (position_id, seq, opcode, operand) VALUES (?, ?, ?, ?)",
+				pos.ID, i, op.Opcode, op.Operand,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	col.PositionCount = len(col.Positions)
+
+	return tx.Commit()
+}
+
+// GetCollection returns a collection with its positions, each with the number
+// of database games that reached it, or nil if it does not exist.
+func (db *DB) GetCollection(id int64) (*models.PositionCollection, error) {
+	col := &models.PositionCollection{ID: id}
+	err := db.conn.QueryRow("SELECT name, created_at FROM position_collections WHERE id = ?", id).Scan(
+		&col.Name, &col.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	col.Positions, err = db.queryCollectionPositions("cp.collection_id = ?", []interface{}{id}, 0)
+	if err != nil {
+		return nil, err
+	}
+	col.PositionCount = len(col.Positions)
+
+	return col, nil
+}
+
+func (db *DB) ListCollections() ([]*models.PositionCollection, error) {
+	rows, err := db.conn.Query(`
+		SELECT c.id, c.name, c.created_at, COUNT(cp.id)
+		FROM position_collections c
+		LEFT JOIN collection_positions cp ON cp.collection_id = c.id
+		GROUP BY c.id
+		ORDER BY c.name, c.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []*models.PositionCollection
+	for rows.Next() {
+		col := &models.PositionCollection{}
+		if err := rows.Scan(&col.ID, &col.Name, &col.CreatedAt, &col.PositionCount); err != nil {
+			return nil, err
+		}
+		collections = append(collections, col)
+	}
+
+	return collections, rows.Err()
+}
+
+func (db *DB) DeleteCollection(id int64) error {
+	_, err := db.conn.Exec("DELETE FROM position_collections WHERE id = ?", id)
+	return err
+}
+
+// GetCollectionPosition returns a position of a collection, or nil if it does
+// not exist.
+func (db *DB) GetCollectionPosition(collectionID, id int64) (*models.CollectionPosition, error) {
+	positions, err := db.queryCollectionPositions("cp.collection_id = ? AND cp.id = ?", []interface{}{collectionID, id}, 0)
+	if err != nil || len(positions) == 0 {
+		return nil, err
+	}
+	return positions[0], nil
+}
+
+// SearchCollectionPositions finds the positions of every collection that
+// match a FEN, compared like the game position search, and an opcode, with
+// any operand when the operand is empty.
+func (db *DB) SearchCollectionPositions(fen string, op models.EPDOpcode, limit int) ([]*models.CollectionPosition, error) {
+	var conditions []string
+	var args []interface{}
+
+	if fen != "" {
+		conditions = append(conditions, "cp.position_hash = ?")
+		args = append(args, HashPosition(fen))
+	}
+
+	if op.Opcode != "" {
+		if op.Operand == "" {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM collection_opcodes o WHERE o.position_id = cp.id AND o.opcode = ?)")
+			args = append(args, op.Opcode)
+		} else {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM collection_opcodes o WHERE o.position_id = cp.id AND o.opcode = ? AND o.operand = ?)")
+			args = append(args, op.Opcode, op.Operand)
+		}
+	}
+
+	where := "1 = 1"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	return db.queryCollectionPositions(where, args, limit)
+}
+
+func (db *DB) queryCollectionPositions(where string, args []interface{}, limit int) ([]*models.CollectionPosition, error) {
+	selection := `
+		FROM collection_positions cp
+		WHERE ` + where + `
+		ORDER BY cp.collection_id, cp.line`
+	if limit > 0 {
+		selection += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT cp.id, cp.collection_id, cp.line, cp.fen, cp.position_hash, `+gamesReaching+selection, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []*models.CollectionPosition
+	byID := make(map[int64]*models.CollectionPosition)
+	for rows.Next() {
+		pos := &models.CollectionPosition{}
+		if err := rows.Scan(&pos.ID, &pos.CollectionID, &pos.Line, &pos.FEN, &pos.PositionKey, &pos.Games); err != nil {
+			return nil, err
+		}
+		positions = append(positions, pos)
+		byID[pos.ID] = pos
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 {
+		return positions, nil
+	}
+
+	opRows, err := db.conn.Query(`
+		SELECT position_id, opcode, operand FROM collection_opcodes
+		WHERE position_id IN (SELECT cp.id`+selection+`)
+		ORDER BY position_id, seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer opRows.Close()
+
+	for opRows.Next() {
+		var positionID int64
+		var op models.EPDOpcode
+		if err := opRows.Scan(&positionID, &op.Opcode, &op.Operand); err != nil {
+			return nil, err
+		}
+		if pos, ok := byID[positionID]; ok {
+			pos.Opcodes = append(pos.Opcodes, op)
+		}
+	}
+
+	return positions, opRows.Err()
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"database/sql"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+var (
+	evalCommand  = regexp.MustCompile(`\[%eval\s+([^\]\s,]+)[^\]]*\]`)
+	clockCommand = regexp.MustCompile(`\[%clk\s+(\d+):(\d{1,2}):(\d{1,2}(?:\.\d+)?)\s*\]`)
+	anyCommand   = regexp.MustCompile(`\[%[^\]]*\]`)
+)
+
+// Movetext returns the movetext section of a PGN game, skipping the tag pairs.
+func Movetext(pgn string) string {
+	lines := strings.Split(pgn, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "[") {
+			return strings.Join(lines[i:], "\n")
+		}
+	}
+	return ""
+}
+
+// MoveComments collects the comments of the main line keyed by the ply they
+// follow. Comments inside variations are ignored and comments before the
+// first move are keyed by ply 0.
+func MoveComments(movetext string) map[int]string {
+	comments := make(map[int]string)
+	ply, depth := 0, 0
+	var token strings.Builder
+
+	endToken := func() {
+		t := token.String()
+		token.Reset()
+		if depth > 0 || t == "" || IsMoveNumber(t) || strings.HasPrefix(t, "$") {
+			return
+		}
+		if t == "1-0" || t == "0-1" || t == "1/2-1/2" || t == "*" {
+			return
+		}
+		ply++
+	}
+
+	for i := 0; i < len(movetext); i++ {
+		c := movetext[i]
+		switch {
+		case c == '{':
+			endToken()
+			end := strings.IndexByte(movetext[i+1:], '}')
+			if end < 0 {
+				end = len(movetext) - i - 1
+			}
+			if depth == 0 {
+				addComment(comments, ply, movetext[i+1:i+1+end])
+			}
+			i += end + 1
+		case c == ';':
+			endToken()
+			end := strings.IndexByte(movetext[i+1:], '\n')
+			if end < 0 {
+				end = len(movetext) - i - 1
+			}
+			if depth == 0 {
+				addComment(comments, ply, movetext[i+1:i+1+end])
+			}
+			i += end
+		case c == '(':
+			endToken()
+			depth++
+		case c == ')':
+			endToken()
+			if depth > 0 {
+				depth--
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			endToken()
+		default:
+			token.WriteByte(c)
+			if c == '.' {
+				endToken()
+			}
+		}
+	}
+	endToken()
+
+	return comments
+}
+
+func addComment(comments map[int]string, ply int, comment string) {
+	comment = strings.Join(strings.Fields(comment), " ")
+	if comment == "" {
+		return
+	}
+	if existing := comments[ply]; existing != "" {
+		comment = existing + " " + comment
+	}
+	comments[ply] = comment
+}
+
+// IsMoveNumber matches move number indications such as "12." or "12...".
+func IsMoveNumber(token string) bool {
+	digits := strings.TrimRight(token, ".")
+	return digits != token && (digits == "" || isNumber(digits))
+}
+
+// ParseComment extracts the [%eval] and [%clk] commands from a comment and
+// returns the remaining text. Evaluations in pawns are converted to
+// centipawns and clock times to milliseconds.
+func ParseComment(comment string) (evalCP, evalMate *int, clockMS *int64, text string) {
+	if m := evalCommand.FindStringSubmatch(comment); m != nil {
+		value := m[1]
+		if strings.HasPrefix(value, "#") {
+			if mate, err := strconv.Atoi(value[1:]); err == nil {
+				evalMate = &mate
+			}
+		} else if pawns, err := strconv.ParseFloat(value, 64); err == nil {
+			cp := int(math.Round(pawns * 100))
+			evalCP = &cp
+		}
+	}
+
+	if m := clockCommand.FindStringSubmatch(comment); m != nil {
+		hours, _ := strconv.ParseInt(m[1], 10, 64)
+		minutes, _ := strconv.ParseInt(m[2], 10, 64)
+		seconds, _ := strconv.ParseFloat(m[3], 64)
+		ms := (hours*3600+minutes*60)*1000 + int64(math.Round(seconds*1000))
+		clockMS = &ms
+	}
+
+	text = strings.Join(strings.Fields(anyCommand.ReplaceAllString(comment, "")), " ")
+	return evalCP, evalMate, clockMS, text
+}
+
+// AttachComments copies the comments found in the movetext onto the moves of
+// the extracted positions.
+func AttachComments(positions []Position, movetext string) {
+	comments := MoveComments(movetext)
+	if len(comments) == 0 {
+		return
+	}
+
+	for i := range positions {
+		comment, ok := comments[positions[i].MoveNumber]
+		if !ok {
+			continue
+		}
+		m := &positions[i].Move
+		m.EvalCP, m.EvalMate, m.ClockMS, m.Comment = ParseComment(comment)
+	}
+}
+
+// GetGameComments returns the plies of a game that carry an imported
+// evaluation, clock time or comment.
+func (db *DB) GetGameComments(gameID int64) ([]models.PlyComment, error) {
+	rows, err := db.conn.Query(`
+		SELECT ply, color, san, eval_cp, eval_mate, clock_ms, comment
+		FROM game_moves
+		WHERE game_id = ?
+		  AND (eval_cp IS NOT NULL OR eval_mate IS NOT NULL OR clock_ms IS NOT NULL OR comment != '')
+		ORDER BY ply`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.PlyComment
+	for rows.Next() {
+		var c models.PlyComment
+		var cp, mate, clock sql.NullInt64
+		if err := rows.Scan(&c.Ply, &c.Color, &c.SAN, &cp, &mate, &clock, &c.Comment); err != nil {
+			return nil, err
+		}
+		c.EvalCP = nullIntPtr(cp)
+		c.EvalMate = nullIntPtr(mate)
+		if clock.Valid {
+			c.ClockMS = &clock.Int64
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
+
+// GetGamePlies returns every position of a game, starting with the initial
+// position, together with the move that led to it and its imported comment.
+// The evaluation is the imported one, or else the deepest stored engine
+// evaluation of the position.
+func (db *DB) GetGamePlies(gameID int64) ([]models.GamePly, error) {
+	rows, err := db.conn.Query(`
+		SELECT p.move_number, p.fen, p.position_hash,
+		       COALESCE(m.color, ''), COALESCE(m.san, ''), COALESCE(m.uci, ''),
+		       COALESCE(m.eval_cp, (SELECT e.score_cp FROM evaluations e
+		                            WHERE e.position_hash = p.position_hash AND e.multipv = 1 AND m.eval_mate IS NULL
+		                            ORDER BY e.depth DESC LIMIT 1)),
+		       COALESCE(m.eval_mate, (SELECT e.score_mate FROM evaluations e
+		                              WHERE e.position_hash = p.position_hash AND e.multipv = 1 AND m.eval_cp IS NULL
+		                              ORDER BY e.depth DESC LIMIT 1)),
+		       m.clock_ms, COALESCE(m.comment, '')
+		FROM position_index p
+		LEFT JOIN game_moves m ON m.game_id = p.game_id AND m.ply = p.move_number
+		WHERE p.game_id = ?
+		ORDER BY p.move_number`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	start, err := db.startingPly(gameID)
+	if err != nil {
+		return nil, err
+	}
+	plies := []models.GamePly{*start}
+	previous := chess.StartingPosition()
+
+	for rows.Next() {
+		var p models.GamePly
+		var cp, mate, clock sql.NullInt64
+		err := rows.Scan(
+			&p.Ply, &p.FEN, &p.PositionKey, &p.Side, &p.SAN, &p.UCI,
+			&cp, &mate, &clock, &p.Comment,
+		)
+		if err != nil {
+			return nil, err
+		}
+		p.MoveNumber = (p.Ply + 1) / 2
+		p.EvalCP = nullIntPtr(cp)
+		p.EvalMate = nullIntPtr(mate)
+		if clock.Valid {
+			p.ClockMS = &clock.Int64
+		}
+
+		// The stored SAN omits check and mate markers, so it is rebuilt for
+		// display from the previous position.
+		if previous != nil && p.UCI != "" {
+			for _, m := range previous.ValidMoves() {
+				if (chess.UCINotation{}).Encode(previous, m) == p.UCI {
+					p.SAN = chess.AlgebraicNotation{}.Encode(previous, m)
+					break
+				}
+			}
+		}
+
+		previous = &chess.Position{}
+		if err := previous.UnmarshalText([]byte(p.FEN)); err != nil {
+			previous = nil
+		}
+
+		plies = append(plies, p)
+	}
+
+	return plies, rows.Err()
+}
+
+// startingPly returns the initial position of a game. Its comment is the one
+// before the first move, which is only kept in the PGN, and its evaluation
+// the imported one or else the deepest stored engine evaluation.
+func (db *DB) startingPly(gameID int64) (*models.GamePly, error) {
+	fen := chess.StartingPosition().String()
+	ply := &models.GamePly{
+		FEN:         fen,
+		PositionKey: HashPosition(fen),
+		MoveNumber:  1,
+	}
+
+	var pgn string
+	err := db.conn.QueryRow("SELECT pgn FROM games WHERE id = ?", gameID).Scan(&pgn)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	ply.EvalCP, ply.EvalMate, ply.ClockMS, ply.Comment = ParseComment(MoveComments(Movetext(pgn))[0])
+	if ply.EvalCP != nil || ply.EvalMate != nil {
+		return ply, nil
+	}
+
+	var cp, mate sql.NullInt64
+	err = db.conn.QueryRow(`
+		SELECT score_cp, score_mate FROM evaluations
+		WHERE position_hash = ? AND multipv = 1
+		ORDER BY depth DESC LIMIT 1`, ply.PositionKey).Scan(&cp, &mate)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	ply.EvalCP = nullIntPtr(cp)
+	ply.EvalMate = nullIntPtr(mate)
+
+	return ply, nil
+}
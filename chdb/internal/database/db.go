@@ -6,16 +6,22 @@ import (
 	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/chdb/chessdb/internal/models"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
 	conn *sql.DB
 }
 
+// connectionOptions configures every connection of the pool. SQLite ignores
+// FOREIGN KEY clauses unless _foreign_keys is set, so it is needed for
+// deleting a game, repertoire, collection, book or import job to cascade to
+// the rows that reference it.
+const connectionOptions = "?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=10000&_busy_timeout=5000&_foreign_keys=1"
+
 func New(dbPath string) (*DB, error) {
-	conn, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=10000&_busy_timeout=5000")
+	conn, err := sql.Open("sqlite3", dbPath+connectionOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -89,35 +95,355 @@ func (db *DB) createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_pattern_hash ON piece_patterns(pattern_hash);
 	CREATE INDEX IF NOT EXISTS idx_pattern_game_id ON piece_patterns(game_id);
 
-	`
+	CREATE TABLE IF NOT EXISTS game_moves (
+		game_id INTEGER NOT NULL,
+		ply INTEGER NOT NULL,
+		color TEXT NOT NULL,
+		piece TEXT NOT NULL,
+		from_square TEXT NOT NULL,
+		to_square TEXT NOT NULL,
+		san TEXT NOT NULL,
+		uci TEXT NOT NULL,
+		captured TEXT,
+		promotion TEXT,
+		PRIMARY KEY (game_id, ply),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
 
-	_, err := db.conn.Exec(schema)
-	return err
-}
+	CREATE INDEX IF NOT EXISTS idx_game_moves_san ON game_moves(san);
+	CREATE INDEX IF NOT EXISTS idx_game_moves_piece_to ON game_moves(piece, to_square);
+
+	CREATE TABLE IF NOT EXISTS game_tags (
+		game_id INTEGER NOT NULL,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (game_id, position),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_game_tags_name_value ON game_tags(name, value);
+
+	CREATE TABLE IF NOT EXISTS game_motifs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		ply INTEGER NOT NULL,
+		motif TEXT NOT NULL,
+		color TEXT NOT NULL,
+		piece TEXT NOT NULL,
+		targets TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_game_motifs_motif ON game_motifs(motif, piece);
+	CREATE INDEX IF NOT EXISTS idx_game_motifs_game_id ON game_motifs(game_id);
+
+	CREATE TABLE IF NOT EXISTS position_features (
+		game_id INTEGER NOT NULL,
+		ply INTEGER NOT NULL,
+		side_to_move TEXT NOT NULL,
+		material_key TEXT NOT NULL,
+		white_material INTEGER NOT NULL,
+		black_material INTEGER NOT NULL,
+		bitboards BLOB NOT NULL,
+		PRIMARY KEY (game_id, ply),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_features_material_key_game ON position_features(material_key, game_id, ply);
+	CREATE INDEX IF NOT EXISTS idx_features_material ON position_features(white_material, black_material);
+
+	CREATE TABLE IF NOT EXISTS evaluations (
+		position_hash TEXT NOT NULL,
+		fen TEXT NOT NULL,
+		engine_name TEXT NOT NULL,
+		engine_version TEXT NOT NULL DEFAULT '',
+		multipv INTEGER NOT NULL DEFAULT 1,
+		depth INTEGER NOT NULL,
+		score_cp INTEGER,
+		score_mate INTEGER,
+		best_move TEXT NOT NULL DEFAULT '',
+		pv TEXT NOT NULL DEFAULT '',
+		nodes INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (position_hash, engine_name, engine_version, multipv)
+	);
+
+	CREATE TABLE IF NOT EXISTS game_analysis (
+		game_id INTEGER PRIMARY KEY,
+		engine TEXT NOT NULL,
+		depth INTEGER NOT NULL,
+		inaccuracy_threshold INTEGER NOT NULL,
+		mistake_threshold INTEGER NOT NULL,
+		blunder_threshold INTEGER NOT NULL,
+		white_acpl REAL NOT NULL,
+		white_accuracy REAL NOT NULL,
+		white_inaccuracies INTEGER NOT NULL,
+		white_mistakes INTEGER NOT NULL,
+		white_blunders INTEGER NOT NULL,
+		black_acpl REAL NOT NULL,
+		black_accuracy REAL NOT NULL,
+		black_inaccuracies INTEGER NOT NULL,
+		black_mistakes INTEGER NOT NULL,
+		black_blunders INTEGER NOT NULL,
+		annotated_pgn TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS move_annotations (
+		game_id INTEGER NOT NULL,
+		ply INTEGER NOT NULL,
+		color TEXT NOT NULL,
+		san TEXT NOT NULL,
+		phase TEXT NOT NULL,
+		cp_before INTEGER NOT NULL,
+		cp_after INTEGER NOT NULL,
+		cp_loss INTEGER NOT NULL,
+		accuracy REAL NOT NULL,
+		classification TEXT NOT NULL DEFAULT '',
+		best_move TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (game_id, ply),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_move_annotations_class ON move_annotations(classification, phase, color);
+
+	CREATE TABLE IF NOT EXISTS puzzles (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		game_id INTEGER NOT NULL,
+		ply INTEGER NOT NULL,
+		fen TEXT NOT NULL,
+		color TEXT NOT NULL,
+		solution TEXT NOT NULL,
+		solution_san TEXT NOT NULL,
+		rating INTEGER NOT NULL,
+		eval_cp INTEGER,
+		eval_mate INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (game_id, ply),
+		FOREIGN KEY (game_id) REFERENCES games(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_puzzles_rating ON puzzles(rating);
+
+	CREATE TABLE IF NOT EXISTS puzzle_themes (
+		puzzle_id INTEGER NOT NULL,
+		theme TEXT NOT NULL,
+		PRIMARY KEY (puzzle_id, theme),
+		FOREIGN KEY (puzzle_id) REFERENCES puzzles(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_puzzle_themes_theme ON puzzle_themes(theme);
+
+	CREATE TABLE IF NOT EXISTS repertoires (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS repertoire_moves (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repertoire_id INTEGER NOT NULL,
+		parent_id INTEGER,
+		ply INTEGER NOT NULL,
+		uci TEXT NOT NULL,
+		san TEXT NOT NULL,
+		fen TEXT NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (repertoire_id) REFERENCES repertoires(id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_id) REFERENCES repertoire_moves(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_repertoire_moves_repertoire ON repertoire_moves(repertoire_id);
+
+	CREATE TABLE IF NOT EXISTS training_cards (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user TEXT NOT NULL,
+		source TEXT NOT NULL,
+		source_id INTEGER NOT NULL,
+		fen TEXT NOT NULL,
+		ease REAL NOT NULL,
+		interval_days INTEGER NOT NULL DEFAULT 0,
+		repetitions INTEGER NOT NULL DEFAULT 0,
+		lapses INTEGER NOT NULL DEFAULT 0,
+		due_at DATETIME NOT NULL,
+		last_reviewed DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user, source, source_id, fen)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_training_cards_due ON training_cards(user, due_at);
+
+	CREATE TABLE IF NOT EXISTS training_reviews (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		card_id INTEGER NOT NULL,
+		move TEXT NOT NULL,
+		correct BOOLEAN NOT NULL,
+		quality INTEGER NOT NULL,
+		interval_days INTEGER NOT NULL,
+		ease REAL NOT NULL,
+		reviewed_at DATETIME NOT NULL,
+		FOREIGN KEY (card_id) REFERENCES training_cards(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_training_reviews_card ON training_reviews(card_id);
+
+	CREATE TABLE IF NOT EXISTS position_collections (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS collection_positions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		collection_id INTEGER NOT NULL,
+		line INTEGER NOT NULL,
+		fen TEXT NOT NULL,
+		position_hash TEXT NOT NULL,
+		FOREIGN KEY (collection_id) REFERENCES position_collections(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_collection_positions_collection ON collection_positions(collection_id);
+	CREATE INDEX IF NOT EXISTS idx_collection_positions_hash ON collection_positions(position_hash);
+
+	CREATE TABLE IF NOT EXISTS collection_opcodes (
+		position_id INTEGER NOT NULL,
+		seq INTEGER NOT NULL,
+		opcode TEXT NOT NULL,
+		operand TEXT NOT NULL,
+		PRIMARY KEY (position_id, seq),
+		FOREIGN KEY (position_id) REFERENCES collection_positions(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_collection_opcodes_opcode ON collection_opcodes(opcode, operand);
+
+	CREATE TABLE IF NOT EXISTS opening_books (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS book_entries (
+		book_id INTEGER NOT NULL,
+		key INTEGER NOT NULL,
+		move INTEGER NOT NULL,
+		weight INTEGER NOT NULL,
+		learn INTEGER NOT NULL,
+		FOREIGN KEY (book_id) REFERENCES opening_books(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_book_entries_key ON book_entries(book_id, key);
+
+	CREATE TABLE IF NOT EXISTS import_jobs (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		total_games INTEGER NOT NULL DEFAULT 0,
+		total_processed INTEGER NOT NULL DEFAULT 0,
+		imported INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		resumed INTEGER NOT NULL DEFAULT 0,
+		started_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs(status);
+
+	CREATE TABLE IF NOT EXISTS import_job_files (
+		job_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		filename TEXT NOT NULL,
+		format TEXT NOT NULL,
+		encoding TEXT NOT NULL,
+		games INTEGER NOT NULL,
+		skipped INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		content TEXT,
+		PRIMARY KEY (job_id, seq),
+		FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS import_job_games (
+		job_id TEXT NOT NULL,
+		game_index INTEGER NOT NULL,
+		PRIMARY KEY (job_id, game_index),
+		FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS data_migrations (
+		name TEXT PRIMARY KEY,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS import_job_errors (
+		job_id TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		PRIMARY KEY (job_id, seq),
+		FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE
+	);
 
-func (db *DB) InsertGame(game *models.Game) (int64, error) {
-	query := `
-		INSERT INTO games (
-			event, site, date, round, white, black, result,
-			white_elo, black_elo, eco, opening, variation,
-			pgn, moves, fen, positions, position_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := db.conn.Exec(query,
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+
+	return db.migrate()
+}
+
+const insertGameQuery = `
+	INSERT INTO games (
+		event, site, date, round, white, black, result,
+		white_elo, black_elo, eco, opening, variation,
+		computed_eco, computed_opening, computed_variation, computed_ply,
+		time_control, time_class, tc_base_seconds, tc_increment_seconds, tc_moves,
+		pgn, moves, fen, positions, position_hash
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+func gameInsertArgs(game *models.Game) []interface{} {
+	return []interface{}{
 		game.Event, game.Site, game.Date, game.Round,
 		game.White, game.Black, game.Result,
 		game.WhiteElo, game.BlackElo, game.ECO,
 		game.Opening, game.Variation,
+		game.ComputedECO, game.ComputedOpening, game.ComputedVariation, game.ComputedPly,
+		game.TimeControl, game.TimeClass, game.BaseSeconds, game.IncrementSeconds, game.PeriodMoves,
 		game.PGN, game.Moves, game.FEN,
 		game.Positions, game.PositionHash,
-	)
+	}
+}
+
+func (db *DB) InsertGame(game *models.Game) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applyTimeControl(game)
+
+	result, err := tx.Exec(insertGameQuery, gameInsertArgs(game)...)
 
 	if err != nil {
 		return 0, err
 	}
 
-	return result.LastInsertId()
+	gameID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertTagsInTx(tx, gameID, game.Tags); err != nil {
+		return 0, err
+	}
+
+	return gameID, tx.Commit()
 }
 
 func (db *DB) InsertGameWithPositions(game *models.Game, positions []Position) (int64, error) {
@@ -127,22 +453,10 @@ func (db *DB) InsertGameWithPositions(game *models.Game, positions []Position) (
 	}
 	defer tx.Rollback()
 
-	query := `
-		INSERT INTO games (
-			event, site, date, round, white, black, result,
-			white_elo, black_elo, eco, opening, variation,
-			pgn, moves, fen, positions, position_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+	classifyOpening(game, positions)
+	applyTimeControl(game)
 
-	result, err := tx.Exec(query,
-		game.Event, game.Site, game.Date, game.Round,
-		game.White, game.Black, game.Result,
-		game.WhiteElo, game.BlackElo, game.ECO,
-		game.Opening, game.Variation,
-		game.PGN, game.Moves, game.FEN,
-		game.Positions, game.PositionHash,
-	)
+	result, err := tx.Exec(insertGameQuery, gameInsertArgs(game)...)
 
 	if err != nil {
 		return 0, err
@@ -153,23 +467,92 @@ func (db *DB) InsertGameWithPositions(game *models.Game, positions []Position) (
 		return 0, err
 	}
 
-	for _, pos := range positions {
-		_, err = tx.Exec(
-			"INSERT INTO position_index (game_id, move_number, fen, position_hash) VALUES (?, ?, ?, ?)",
-			gameID, pos.MoveNumber, pos.FEN, pos.Hash,
-		)
-		if err != nil {
-			return 0, err
-		}
+	if err := insertTagsInTx(tx, gameID, game.Tags); err != nil {
+		return 0, err
+	}
+
+	if err := insertPositionsInTx(tx, gameID, positions); err != nil {
+		return 0, err
 	}
 
 	return gameID, tx.Commit()
 }
 
 func (db *DB) SearchGames(params *models.SearchParams) ([]*models.Game, error) {
+	query, args := searchQuery(params)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []*models.Game
+	for rows.Next() {
+		game, err := scanSearchGame(rows)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+
+	return games, nil
+}
+
+// EachGame calls fn for every game matching the search, with its PGN and
+// moves, reading the games one at a time so that exports of any size run in
+// constant memory. Iteration stops at the first error returned by fn.
+func (db *DB) EachGame(params *models.SearchParams, fn func(*models.Game) error) error {
+	withMoves := *params
+	withMoves.IncludeMoves = true
+	query, args := searchQuery(&withMoves)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		game, err := scanSearchGame(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(game); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func searchQuery(params *models.SearchParams) (string, []interface{}) {
 	var conditions []string
 	var args []interface{}
 
+	if len(params.IDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(params.IDs)), ",")
+		conditions = append(conditions, "id IN ("+placeholders+")")
+		for _, id := range params.IDs {
+			args = append(args, id)
+		}
+	}
+
+	if params.Position != "" {
+		conditions = append(conditions, "id IN (SELECT game_id FROM position_index WHERE position_hash = ?)")
+		args = append(args, HashPosition(params.Position))
+	}
+
+	for _, tag := range params.Tags {
+		if tag.Value == "" {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM game_tags t WHERE t.game_id = games.id AND t.name = ?)")
+			args = append(args, tag.Name)
+		} else {
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM game_tags t WHERE t.game_id = games.id AND t.name = ? AND t.value = ?)")
+			args = append(args, tag.Name, tag.Value)
+		}
+	}
+
 	if params.White != "" {
 		conditions = append(conditions, "white LIKE ?")
 		args = append(args, "%"+params.White+"%")
@@ -190,6 +573,16 @@ func (db *DB) SearchGames(params *models.SearchParams) ([]*models.Game, error) {
 		args = append(args, params.ECO)
 	}
 
+	if params.ComputedECO != "" {
+		conditions = append(conditions, "computed_eco = ?")
+		args = append(args, params.ComputedECO)
+	}
+
+	if params.TimeClass != "" {
+		conditions = append(conditions, "time_class = ?")
+		args = append(args, params.TimeClass)
+	}
+
 	if params.Opening != "" {
 		conditions = append(conditions, "opening LIKE ?")
 		args = append(args, "%"+params.Opening+"%")
@@ -220,14 +613,64 @@ func (db *DB) SearchGames(params *models.SearchParams) ([]*models.Game, error) {
 		args = append(args, params.MaxElo, params.MaxElo)
 	}
 
+	if params.Motif != "" {
+		motifConditions := []string{"m.game_id = games.id", "m.motif = ?"}
+		args = append(args, params.Motif)
+
+		if params.MotifPiece != "" {
+			motifConditions = append(motifConditions, "m.piece = ?")
+			args = append(args, strings.ToUpper(params.MotifPiece))
+		}
+
+		for _, target := range strings.ToUpper(params.MotifTargets) {
+			motifConditions = append(motifConditions, "m.targets LIKE ?")
+			args = append(args, "%"+string(target)+"%")
+		}
+
+		if params.MotifWon {
+			motifConditions = append(motifConditions, "((m.color = 'w' AND games.result = '1-0') OR (m.color = 'b' AND games.result = '0-1'))")
+		}
+
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM game_motifs m WHERE "+strings.Join(motifConditions, " AND ")+")")
+	}
+
+	if params.Annotation != "" || params.AnnotationPlayer != "" || params.AnnotationPhase != "" {
+		annotationConditions := []string{"a.game_id = games.id"}
+
+		if params.Annotation != "" {
+			annotationConditions = append(annotationConditions, "a.classification = ?")
+			args = append(args, params.Annotation)
+		} else {
+			annotationConditions = append(annotationConditions, "a.classification != ''")
+		}
+
+		if params.AnnotationPhase != "" {
+			annotationConditions = append(annotationConditions, "a.phase = ?")
+			args = append(args, params.AnnotationPhase)
+		}
+
+		if params.AnnotationPlayer != "" {
+			annotationConditions = append(annotationConditions, "((a.color = 'w' AND games.white LIKE ?) OR (a.color = 'b' AND games.black LIKE ?))")
+			args = append(args, "%"+params.AnnotationPlayer+"%", "%"+params.AnnotationPlayer+"%")
+		}
+
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM move_annotations a WHERE "+strings.Join(annotationConditions, " AND ")+")")
+	}
+
+	if params.TablebaseChanged {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM move_annotations t WHERE t.game_id = games.id AND t.tablebase_after < t.tablebase_before)")
+	}
+
 	query := "SELECT id, event, site, date, round, white, black, result, white_elo, black_elo, eco, opening, variation"
-	
+	query += ", computed_eco, computed_opening, computed_variation, computed_ply"
+	query += ", time_control, time_class, tc_base_seconds, tc_increment_seconds, tc_moves"
+
 	if params.IncludeMoves {
 		query += ", pgn, moves"
 	} else {
 		query += ", '', ''"
 	}
-	
+
 	query += ", created_at, updated_at FROM games"
 
 	if len(conditions) > 0 {
@@ -243,35 +686,30 @@ func (db *DB) SearchGames(params *models.SearchParams) ([]*models.Game, error) {
 		}
 	}
 
-	rows, err := db.conn.Query(query, args...)
+	return query, args
+}
+
+func scanSearchGame(rows *sql.Rows) (*models.Game, error) {
+	game := &models.Game{}
+	err := rows.Scan(
+		&game.ID, &game.Event, &game.Site, &game.Date, &game.Round,
+		&game.White, &game.Black, &game.Result,
+		&game.WhiteElo, &game.BlackElo,
+		&game.ECO, &game.Opening, &game.Variation,
+		&game.ComputedECO, &game.ComputedOpening, &game.ComputedVariation, &game.ComputedPly,
+		&game.TimeControl, &game.TimeClass, &game.BaseSeconds, &game.IncrementSeconds, &game.PeriodMoves,
+		&game.PGN, &game.Moves,
+		&game.CreatedAt, &game.UpdatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var games []*models.Game
-	for rows.Next() {
-		game := &models.Game{}
-		err := rows.Scan(
-			&game.ID, &game.Event, &game.Site, &game.Date, &game.Round,
-			&game.White, &game.Black, &game.Result,
-			&game.WhiteElo, &game.BlackElo,
-			&game.ECO, &game.Opening, &game.Variation,
-			&game.PGN, &game.Moves,
-			&game.CreatedAt, &game.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		games = append(games, game)
-	}
-
-	return games, nil
+	return game, nil
 }
 
 func (db *DB) SearchByPosition(fen string, limit int) ([]*models.Game, error) {
 	hash := HashPosition(fen)
-	
+
 	query := `
 		SELECT DISTINCT g.id, g.event, g.site, g.date, g.round, 
 		       g.white, g.black, g.result, g.white_elo, g.black_elo,
@@ -314,6 +752,8 @@ func (db *DB) GetGame(id int64) (*models.Game, error) {
 	query := `
 		SELECT id, event, site, date, round, white, black, result,
 		       white_elo, black_elo, eco, opening, variation,
+		       computed_eco, computed_opening, computed_variation, computed_ply,
+		       time_control, time_class, tc_base_seconds, tc_increment_seconds, tc_moves,
 		       pgn, moves, created_at, updated_at
 		FROM games WHERE id = ?
 	`
@@ -324,6 +764,8 @@ func (db *DB) GetGame(id int64) (*models.Game, error) {
 		&game.White, &game.Black, &game.Result,
 		&game.WhiteElo, &game.BlackElo,
 		&game.ECO, &game.Opening, &game.Variation,
+		&game.ComputedECO, &game.ComputedOpening, &game.ComputedVariation, &game.ComputedPly,
+		&game.TimeControl, &game.TimeClass, &game.BaseSeconds, &game.IncrementSeconds, &game.PeriodMoves,
 		&game.PGN, &game.Moves,
 		&game.CreatedAt, &game.UpdatedAt,
 	)
@@ -335,9 +777,26 @@ func (db *DB) GetGame(id int64) (*models.Game, error) {
 	return game, err
 }
 
+// DeleteGame deletes a game with the rows that depend on it. Training cards
+// only reference their puzzle by ID, so those of the game's puzzles are
+// deleted here.
 func (db *DB) DeleteGame(id int64) error {
-	_, err := db.conn.Exec("DELETE FROM games WHERE id = ?", id)
-	return err
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM training_cards
+		WHERE source = 'puzzle' AND source_id IN (SELECT id FROM puzzles WHERE game_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM games WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (db *DB) GetStats() (map[string]interface{}, error) {
@@ -377,4 +836,5 @@ type Position struct {
 	MoveNumber int
 	FEN        string
 	Hash       string
-}
\ No newline at end of file
+	Move       Move
+}
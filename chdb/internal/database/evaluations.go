@@ -0,0 +1,146 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// Evaluation is one engine line stored for a position. Scores are from
+// White's point of view and rows are shared by every game reaching the
+// position, since they are keyed by position hash rather than by game.
+type Evaluation struct {
+	PositionHash  string
+	FEN           string
+	EngineName    string
+	EngineVersion string
+	MultiPV       int
+	Depth         int
+	ScoreCP       *int
+	ScoreMate     *int
+	BestMove      string
+	PV            string
+	Nodes         int64
+}
+
+// SaveEvaluations stores engine lines, keeping an existing line for the same
+// position, engine and rank when it was searched at least as deep.
+func (db *DB) SaveEvaluations(evals []Evaluation) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range evals {
+		_, err := tx.Exec(`
+			INSERT INTO evaluations (
+				position_hash, fen, engine_name, engine_version, multipv, depth,
+				score_cp, score_mate, best_move, pv, nodes
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (position_hash, engine_name, engine_version, multipv) DO UPDATE SET
+				fen = excluded.fen, depth = excluded.depth,
+				score_cp = excluded.score_cp, score_mate = excluded.score_mate,
+				best_move = excluded.best_move, pv = excluded.pv, nodes = excluded.nodes,
+				created_at = CURRENT_TIMESTAMP
+			WHERE excluded.depth >= evaluations.depth`,
+			e.PositionHash, e.FEN, e.EngineName, e.EngineVersion, e.MultiPV, e.Depth,
+			e.ScoreCP, e.ScoreMate, e.BestMove, e.PV, e.Nodes,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetEvaluations returns the stored lines of the deepest analysis of a
+// position, ordered by rank.
+func (db *DB) GetEvaluations(positionHash string) ([]Evaluation, error) {
+	rows, err := db.conn.Query(`
+		SELECT position_hash, fen, engine_name, engine_version, multipv, depth,
+		       score_cp, score_mate, best_move, pv, nodes
+		FROM evaluations
+		WHERE position_hash = ?
+		ORDER BY depth DESC, multipv`, positionHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var evals []Evaluation
+	for rows.Next() {
+		e, err := scanEvaluation(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(evals) > 0 && (e.EngineName != evals[0].EngineName || e.EngineVersion != evals[0].EngineVersion) {
+			continue
+		}
+		evals = append(evals, e)
+	}
+
+	return evals, rows.Err()
+}
+
+// GetGameEvaluations returns the evaluation graph of a game: the best stored
+// line for every ply whose resulting position has been analysed.
+func (db *DB) GetGameEvaluations(gameID int64) ([]models.PlyEvaluation, error) {
+	rows, err := db.conn.Query(`
+		SELECT p.move_number, e.depth, e.score_cp, e.score_mate, e.best_move, e.engine_name, e.engine_version
+		FROM position_index p
+		JOIN evaluations e ON e.position_hash = p.position_hash AND e.multipv = 1
+		WHERE p.game_id = ?
+		ORDER BY p.move_number, e.depth DESC`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var evals []models.PlyEvaluation
+	for rows.Next() {
+		var e models.PlyEvaluation
+		var cp, mate sql.NullInt64
+		var name, version string
+		if err := rows.Scan(&e.Ply, &e.Depth, &cp, &mate, &e.BestMove, &name, &version); err != nil {
+			return nil, err
+		}
+		if n := len(evals); n > 0 && evals[n-1].Ply == e.Ply {
+			continue
+		}
+		e.ScoreCP = nullIntPtr(cp)
+		e.ScoreMate = nullIntPtr(mate)
+		e.Engine = joinEngineName(name, version)
+		evals = append(evals, e)
+	}
+
+	return evals, rows.Err()
+}
+
+func scanEvaluation(rows *sql.Rows) (Evaluation, error) {
+	var e Evaluation
+	var cp, mate sql.NullInt64
+	err := rows.Scan(
+		&e.PositionHash, &e.FEN, &e.EngineName, &e.EngineVersion, &e.MultiPV, &e.Depth,
+		&cp, &mate, &e.BestMove, &e.PV, &e.Nodes,
+	)
+	e.ScoreCP = nullIntPtr(cp)
+	e.ScoreMate = nullIntPtr(mate)
+	return e, err
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+func joinEngineName(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + " " + version
+}
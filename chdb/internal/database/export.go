@@ -1,7 +1,179 @@
 package database
 
-import "database/sql"
+import (
+	"bufio"
+	"database/sql"
+	"io"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// pgnLineWidth is the maximum length of an exported movetext line, as the PGN
+// standard recommends.
+const pgnLineWidth = 80
+
+// sevenTagRoster lists the tags every exported game starts with, in the order
+// required by the PGN standard.
+var sevenTagRoster = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result"}
 
 func (db *DB) GetConn() *sql.DB {
 	return db.conn
-}
\ No newline at end of file
+}
+
+// ExportPGN writes the games matching the search to w as PGN, one game at a
+// time, and returns the number of games written.
+func (db *DB) ExportPGN(w io.Writer, params *models.SearchParams) (int, error) {
+	bw := bufio.NewWriter(w)
+	count := 0
+
+	err := db.EachGame(params, func(game *models.Game) error {
+		if count > 0 {
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		count++
+		return WritePGN(bw, game)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// WritePGN writes a game in export format: the Seven Tag Roster first, the
+// remaining tags in their original order, then the movetext wrapped at 80 columns and
+// terminated by the result.
+func WritePGN(w io.Writer, game *models.Game) error {
+	var sb strings.Builder
+
+	for _, tag := range gameTags(game) {
+		sb.WriteString(FormatTag(tag[0], tag[1]) + "\n")
+	}
+	sb.WriteByte('\n')
+
+	movetext := strings.TrimSpace(Movetext(game.PGN))
+	if movetext == "" {
+		movetext = game.Moves
+	}
+	writeMovetext(&sb, movetext, game.Result)
+	sb.WriteByte('\n')
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// gameTags returns the tag pairs of a game in export order. The Seven Tag
+// Roster comes from the game's columns, with the PGN placeholders for
+// unknown values, and the other tags from the header of its stored PGN.
+func gameTags(game *models.Game) [][2]string {
+	roster := map[string]string{
+		"Event":  game.Event,
+		"Site":   game.Site,
+		"Date":   game.Date,
+		"Round":  game.Round,
+		"White":  game.White,
+		"Black":  game.Black,
+		"Result": game.Result,
+	}
+
+	var tags [][2]string
+	for _, name := range sevenTagRoster {
+		value := roster[name]
+		if value == "" {
+			switch name {
+			case "Date":
+				value = "????.??.??"
+			case "Result":
+				value = "*"
+			default:
+				value = "?"
+			}
+		}
+		tags = append(tags, [2]string{name, value})
+	}
+
+	var extra [][2]string
+	for _, tag := range headerTags(game.PGN) {
+		if _, ok := roster[tag.Name]; !ok {
+			extra = append(extra, [2]string{tag.Name, tag.Value})
+		}
+	}
+
+	return append(tags, extra...)
+}
+
+// writeMovetext wraps the movetext tokens at the line width. Lines escaped
+// with "%" are dropped and ";" comments, which run to the end of the line,
+// are kept intact. The result is appended when the movetext does not end with
+// a termination marker.
+func writeMovetext(sb *strings.Builder, movetext, result string) {
+	if result == "" {
+		result = "*"
+	}
+
+	lineLen := 0
+	write := func(token string) {
+		if lineLen > 0 && lineLen+1+len(token) > pgnLineWidth {
+			sb.WriteByte('\n')
+			lineLen = 0
+		}
+		if lineLen > 0 {
+			sb.WriteByte(' ')
+			lineLen++
+		}
+		sb.WriteString(token)
+		lineLen += len(token)
+	}
+
+	last, inBrace := "", false
+	for _, line := range strings.Split(movetext, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+
+		comment := ""
+		if i := restOfLineComment(line, &inBrace); i >= 0 {
+			line, comment = line[:i], line[i:]
+		}
+
+		for _, token := range strings.Fields(line) {
+			write(token)
+			last = token
+		}
+
+		if comment != "" {
+			write(comment)
+			sb.WriteByte('\n')
+			lineLen = 0
+			last = comment
+		}
+	}
+
+	switch last {
+	case "1-0", "0-1", "1/2-1/2", "*":
+	default:
+		write(result)
+	}
+}
+
+// restOfLineComment returns the index of a ";" comment outside braces, or -1.
+// inBrace carries an open brace comment over to the next line.
+func restOfLineComment(line string, inBrace *bool) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '{':
+			*inBrace = true
+		case '}':
+			*inBrace = false
+		case ';':
+			if !*inBrace {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+const featurePieces = "PNBRQKpnbrqk"
+
+var featureValues = map[byte]int{
+	'P': 1, 'N': 3, 'B': 3, 'R': 5, 'Q': 9,
+	'p': 1, 'n': 3, 'b': 3, 'r': 5, 'q': 9,
+}
+
+// PositionFeatures is the feature vector stored for every indexed position:
+// one bitboard per piece kind (in featurePieces order, a1 = bit 0) plus the
+// material totals used to narrow down candidates.
+type PositionFeatures struct {
+	Bitboards     [12]uint64
+	SideToMove    string
+	MaterialKey   string
+	WhiteMaterial int
+	BlackMaterial int
+}
+
+func ExtractFeatures(fen string) PositionFeatures {
+	var f PositionFeatures
+	parts := strings.Fields(fen)
+	if len(parts) == 0 {
+		return f
+	}
+	if len(parts) > 1 {
+		f.SideToMove = parts[1]
+	}
+
+	var white, black []byte
+	rank, file := 7, 0
+	for i := 0; i < len(parts[0]); i++ {
+		c := parts[0][i]
+		switch {
+		case c == '/':
+			rank--
+			file = 0
+		case c >= '1' && c <= '8':
+			file += int(c - '0')
+		default:
+			idx := strings.IndexByte(featurePieces, c)
+			if idx >= 0 && rank >= 0 && file < 8 {
+				f.Bitboards[idx] |= 1 << uint(rank*8+file)
+				if idx < 6 {
+					white = append(white, c)
+					f.WhiteMaterial += featureValues[c]
+				} else {
+					black = append(black, c)
+					f.BlackMaterial += featureValues[c]
+				}
+			}
+			file++
+		}
+	}
+
+	f.MaterialKey = materialKey(white) + "v" + materialKey(black)
+	return f
+}
+
+func materialKey(pieces []byte) string {
+	sort.Slice(pieces, func(i, j int) bool {
+		return strings.IndexByte(featurePieces, pieces[i]) > strings.IndexByte(featurePieces, pieces[j])
+	})
+	return strings.ToUpper(string(pieces))
+}
+
+func (f PositionFeatures) MarshalBitboards() []byte {
+	data := make([]byte, 8*len(f.Bitboards))
+	for i, bb := range f.Bitboards {
+		binary.LittleEndian.PutUint64(data[i*8:], bb)
+	}
+	return data
+}
+
+func (f *PositionFeatures) UnmarshalBitboards(data []byte) {
+	for i := range f.Bitboards {
+		if len(data) < (i+1)*8 {
+			return
+		}
+		f.Bitboards[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+}
+
+func insertFeaturesInTx(tx *sql.Tx, gameID int64, positions []Position) error {
+	for _, pos := range positions {
+		f := ExtractFeatures(pos.FEN)
+		_, err := tx.Exec(`
+			INSERT INTO position_features (game_id, ply, side_to_move, material_key, white_material, black_material, bitboards)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, pos.MoveNumber, f.SideToMove, f.MaterialKey, f.WhiteMaterial, f.BlackMaterial, f.MarshalBitboards(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
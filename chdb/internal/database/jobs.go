@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// CreateImportJob stores a new import job with its files and their PGN, which
+// is kept until the job finishes so that it can be resumed after a restart.
+// errors are those of games that could not be converted to PGN.
+func (db *DB) CreateImportJob(job *models.ImportJob, contents []string, errors []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO import_jobs (id, kind, status, total_games, total_bytes, error, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.Kind, job.Status, job.TotalGames, job.TotalBytes, job.Error, job.StartTime, job.LastUpdate,
+	)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range job.Files {
+		var content interface{}
+		if i < len(contents) && f.Error == "" {
+			content = contents[i]
+		}
+		_, err := tx.Exec(`
+			INSERT INTO import_job_files (job_id, seq, filename, format, encoding, games, skipped, error, content)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			job.ID, i, f.Filename, f.Format, f.Encoding, f.Games, f.Skipped, f.Error, content,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := insertImportJobErrors(tx, job.ID, errors); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertImportJobErrors(tx *sql.Tx, jobID string, errors []string) error {
+	for i, msg := range errors {
+		if _, err := tx.Exec("INSERT INTO import_job_errors (job_id, seq, error) VALUES (?, ?, ?)", jobID, i, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateImportJob stores the status and counts of an import job.
+func (db *DB) UpdateImportJob(job *models.ImportJob) error {
+	return updateImportJob(db.conn, job)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func updateImportJob(conn execer, job *models.ImportJob) error {
+	_, err := conn.Exec(`
+		UPDATE import_jobs SET status = ?, total_games = ?, total_processed = ?, imported = ?, failed = ?,
+		       total_bytes = ?, bytes_processed = ?, games_per_second = ?,
+		       error = ?, resumed = ?, updated_at = ?, finished_at = ?
+		WHERE id = ?`,
+		job.Status, job.TotalGames, job.TotalProcessed, job.Imported, job.Failed,
+		job.TotalBytes, job.BytesProcessed, job.GamesPerSecond,
+		job.Error, job.Resumed, job.LastUpdate, job.FinishedAt, job.ID,
+	)
+	return err
+}
+
+// FinishImportJob stores the final state of an import job with the errors of
+// its result, which replace those stored with the job, and drops what was
+// only kept to resume it.
+func (db *DB) FinishImportJob(job *models.ImportJob) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateImportJob(tx, job); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE import_job_files SET content = NULL WHERE job_id = ?", job.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM import_job_games WHERE job_id = ?", job.ID); err != nil {
+		return err
+	}
+	if job.Result != nil {
+		if _, err := tx.Exec("DELETE FROM import_job_errors WHERE job_id = ?", job.ID); err != nil {
+			return err
+		}
+		if err := insertImportJobErrors(tx, job.ID, job.Result.Errors); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetImportJob returns an import job with its files, or nil if it does not
+// exist.
+func (db *DB) GetImportJob(id string) (*models.ImportJob, error) {
+	jobs, err := db.queryImportJobs("WHERE id = ?", id)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return jobs[0], nil
+}
+
+// ListImportJobs returns the most recent import jobs, newest first.
+func (db *DB) ListImportJobs(limit int) ([]*models.ImportJob, error) {
+	return db.queryImportJobs("ORDER BY started_at DESC, id DESC LIMIT ?", limit)
+}
+
+// RunningImportJobs returns the jobs that were running when the server last
+// stopped.
+func (db *DB) RunningImportJobs() ([]*models.ImportJob, error) {
+	return db.queryImportJobs("WHERE status = 'running' ORDER BY started_at, id")
+}
+
+func (db *DB) queryImportJobs(clause string, args ...interface{}) ([]*models.ImportJob, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, kind, status, total_games, total_processed, imported, failed,
+		       total_bytes, bytes_processed, games_per_second, error, resumed,
+		       started_at, updated_at, finished_at
+		FROM import_jobs `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*models.ImportJob
+	for rows.Next() {
+		job := &models.ImportJob{}
+		var finished sql.NullTime
+		err := rows.Scan(
+			&job.ID, &job.Kind, &job.Status, &job.TotalGames, &job.TotalProcessed, &job.Imported, &job.Failed,
+			&job.TotalBytes, &job.BytesProcessed, &job.GamesPerSecond, &job.Error, &job.Resumed, &job.StartTime, &job.LastUpdate, &finished,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			job.FinishedAt = &finished.Time
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.Files, err = db.importJobFiles(job.ID); err != nil {
+			return nil, err
+		}
+		if job.FinishedAt != nil {
+			if job.Result, err = db.importJobResult(job); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return jobs, nil
+}
+
+func (db *DB) importJobFiles(jobID string) ([]models.ImportJobFile, error) {
+	rows, err := db.conn.Query(`
+		SELECT filename, format, encoding, games, skipped, error
+		FROM import_job_files WHERE job_id = ? ORDER BY seq`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.ImportJobFile
+	for rows.Next() {
+		var f models.ImportJobFile
+		if err := rows.Scan(&f.Filename, &f.Format, &f.Encoding, &f.Games, &f.Skipped, &f.Error); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// importJobResult returns the result of a finished import job.
+func (db *DB) importJobResult(job *models.ImportJob) (*models.ImportResult, error) {
+	errors, err := db.ImportJobErrors(job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ImportResult{
+		TotalGames:     job.TotalGames,
+		ImportedGames:  int(job.Imported),
+		FailedGames:    int(job.Failed),
+		Errors:         errors,
+		ProcessingTime: job.FinishedAt.Sub(job.StartTime).Seconds(),
+	}, nil
+}
+
+// ImportJobErrors returns the stored errors of an import job: those of its
+// result once it finished, or of the games that could not be converted to PGN
+// while it runs.
+func (db *DB) ImportJobErrors(jobID string) ([]string, error) {
+	rows, err := db.conn.Query("SELECT error FROM import_job_errors WHERE job_id = ? ORDER BY seq", jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errors []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		errors = append(errors, msg)
+	}
+
+	return errors, rows.Err()
+}
+
+// ImportJobContents returns the PGN of every file of an import job, empty for
+// files that could not be read. ok is false when the job has no files or the
+// PGN of a readable file is no longer kept, so the job cannot be resumed.
+func (db *DB) ImportJobContents(jobID string) (contents []string, ok bool, err error) {
+	rows, err := db.conn.Query("SELECT error, content FROM import_job_files WHERE job_id = ? ORDER BY seq", jobID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	ok = true
+	for rows.Next() {
+		var fileErr string
+		var content sql.NullString
+		if err := rows.Scan(&fileErr, &content); err != nil {
+			return nil, false, err
+		}
+		if fileErr == "" && !content.Valid {
+			ok = false
+		}
+		contents = append(contents, content.String)
+	}
+
+	return contents, ok && len(contents) > 0, rows.Err()
+}
+
+// ImportedGameIndexes returns the indexes of the games of an import job that
+// are already stored.
+func (db *DB) ImportedGameIndexes(jobID string) (map[int]bool, error) {
+	rows, err := db.conn.Query("SELECT game_index FROM import_job_games WHERE job_id = ?", jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[int]bool)
+	for rows.Next() {
+		var index int
+		if err := rows.Scan(&index); err != nil {
+			return nil, err
+		}
+		indexes[index] = true
+	}
+
+	return indexes, rows.Err()
+}
+
+// recordImportedGame marks a game of an import job as stored, in the
+// transaction that stores it.
+func recordImportedGame(tx *sql.Tx, jobID string, index int) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO import_job_games (job_id, game_index) VALUES (?, ?)", jobID, index)
+	return err
+}
@@ -0,0 +1,115 @@
+package database
+
+import "fmt"
+
+// columnMigrations adds columns introduced after a table was first created.
+// CREATE TABLE IF NOT EXISTS leaves existing databases untouched, so new
+// columns are listed here instead and added on startup when missing.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"games", "computed_eco", "TEXT NOT NULL DEFAULT ''"},
+	{"games", "computed_opening", "TEXT NOT NULL DEFAULT ''"},
+	{"games", "computed_variation", "TEXT NOT NULL DEFAULT ''"},
+	{"games", "computed_ply", "INTEGER NOT NULL DEFAULT 0"},
+	{"games", "time_control", "TEXT NOT NULL DEFAULT ''"},
+	{"games", "time_class", "TEXT NOT NULL DEFAULT ''"},
+	{"games", "tc_base_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"games", "tc_increment_seconds", "INTEGER NOT NULL DEFAULT 0"},
+	{"games", "tc_moves", "INTEGER NOT NULL DEFAULT 0"},
+	{"game_moves", "eval_cp", "INTEGER"},
+	{"game_moves", "eval_mate", "INTEGER"},
+	{"game_moves", "clock_ms", "INTEGER"},
+	{"game_moves", "comment", "TEXT NOT NULL DEFAULT ''"},
+	{"move_annotations", "tablebase_before", "INTEGER"},
+	{"move_annotations", "tablebase_after", "INTEGER"},
+	{"import_jobs", "total_bytes", "INTEGER NOT NULL DEFAULT 0"},
+	{"import_jobs", "bytes_processed", "INTEGER NOT NULL DEFAULT 0"},
+	{"import_jobs", "games_per_second", "REAL NOT NULL DEFAULT 0"},
+}
+
+const migrationIndexes = `
+	CREATE INDEX IF NOT EXISTS idx_computed_eco ON games(computed_eco);
+	CREATE INDEX IF NOT EXISTS idx_time_class ON games(time_class);
+	DROP INDEX IF EXISTS idx_features_material_key;
+`
+
+// dataMigrations fill data for games stored before a feature was added. Each
+// runs once per database and is recorded in data_migrations when it
+// completes, so that startups do not scan the games again.
+var dataMigrations = []struct {
+	name string
+	run  func(*DB) error
+}{
+	{"backfill_time_controls", (*DB).backfillTimeControls},
+	{"backfill_tags", (*DB).backfillTags},
+	{"drop_orphan_puzzle_cards", (*DB).dropOrphanPuzzleCards},
+	{"drop_orphan_repertoire_cards", (*DB).dropOrphanRepertoireCards},
+}
+
+func (db *DB) migrate() error {
+	for _, m := range columnMigrations {
+		exists, err := db.columnExists(m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	if _, err := db.conn.Exec(migrationIndexes); err != nil {
+		return err
+	}
+
+	for _, m := range dataMigrations {
+		if err := db.runDataMigration(m.name, m.run); err != nil {
+			return fmt.Errorf("data migration %s: %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) runDataMigration(name string, run func(*DB) error) error {
+	var applied int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM data_migrations WHERE name = ?", name).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	if err := run(db); err != nil {
+		return err
+	}
+
+	_, err := db.conn.Exec("INSERT INTO data_migrations (name) VALUES (?)", name)
+	return err
+}
+
+func (db *DB) columnExists(table, column string) (bool, error) {
+	rows, err := db.conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/motif"
+)
+
+func insertMotifsInTx(tx *sql.Tx, gameID int64, positions []Position) error {
+	plies := make([]motif.Ply, 0, len(positions))
+	for _, pos := range positions {
+		plies = append(plies, motif.Ply{
+			Number: pos.MoveNumber,
+			FEN:    pos.FEN,
+			From:   pos.Move.From,
+			To:     pos.Move.To,
+		})
+	}
+
+	for _, tag := range motif.Detect(plies) {
+		_, err := tx.Exec(
+			"INSERT INTO game_motifs (game_id, ply, motif, color, piece, targets) VALUES (?, ?, ?, ?, ?, ?)",
+			gameID, tag.Ply, tag.Motif, tag.Color, tag.Piece, tag.Targets,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) GetGameMotifs(gameID int64) ([]motif.Tag, error) {
+	rows, err := db.conn.Query(
+		"SELECT ply, motif, color, piece, targets FROM game_motifs WHERE game_id = ? ORDER BY ply, motif",
+		gameID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []motif.Tag
+	for rows.Next() {
+		var tag motif.Tag
+		if err := rows.Scan(&tag.Ply, &tag.Motif, &tag.Color, &tag.Piece, &tag.Targets); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/notnil/chess"
+)
+
+type Move struct {
+	Ply       int    `json:"ply"`
+	Color     string `json:"color"`
+	Piece     string `json:"piece"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	SAN       string `json:"san"`
+	UCI       string `json:"uci"`
+	Captured  string `json:"captured,omitempty"`
+	Promotion string `json:"promotion,omitempty"`
+	EvalCP    *int   `json:"eval_cp,omitempty"`
+	EvalMate  *int   `json:"eval_mate,omitempty"`
+	ClockMS   *int64 `json:"clock_ms,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+// DescribeMove records the move played from pos as the given ply. The SAN is
+// stored without check or mate markers so that searches do not depend on them.
+func DescribeMove(ply int, pos *chess.Position, m *chess.Move) Move {
+	board := pos.Board()
+	piece := board.Piece(m.S1())
+
+	move := Move{
+		Ply:   ply,
+		Color: piece.Color().String(),
+		Piece: pieceLetter(piece.Type()),
+		From:  m.S1().String(),
+		To:    m.S2().String(),
+		SAN:   strings.TrimRight(chess.AlgebraicNotation{}.Encode(pos, m), "+#"),
+		UCI:   chess.UCINotation{}.Encode(pos, m),
+	}
+
+	if m.HasTag(chess.EnPassant) {
+		move.Captured = "P"
+	} else if captured := board.Piece(m.S2()); captured != chess.NoPiece {
+		move.Captured = pieceLetter(captured.Type())
+	}
+
+	if m.Promo() != chess.NoPieceType {
+		move.Promotion = pieceLetter(m.Promo())
+	}
+
+	return move
+}
+
+func pieceLetter(t chess.PieceType) string {
+	if t == chess.NoPieceType {
+		return ""
+	}
+	return strings.ToUpper(t.String())
+}
+
+func insertPositionsInTx(tx *sql.Tx, gameID int64, positions []Position) error {
+	for _, pos := range positions {
+		_, err := tx.Exec(
+			"INSERT INTO position_index (game_id, move_number, fen, position_hash) VALUES (?, ?, ?, ?)",
+			gameID, pos.MoveNumber, pos.FEN, pos.Hash,
+		)
+		if err != nil {
+			return err
+		}
+
+		m := pos.Move
+		if m.SAN == "" {
+			continue
+		}
+		_, err = tx.Exec(`
+			INSERT INTO game_moves (
+				game_id, ply, color, piece, from_square, to_square, san, uci, captured, promotion,
+				eval_cp, eval_mate, clock_ms, comment
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			gameID, pos.MoveNumber, m.Color, m.Piece, m.From, m.To, m.SAN, m.UCI, m.Captured, m.Promotion,
+			m.EvalCP, m.EvalMate, m.ClockMS, m.Comment,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := insertFeaturesInTx(tx, gameID, positions); err != nil {
+		return err
+	}
+
+	return insertMotifsInTx(tx, gameID, positions)
+}
+
+func (db *DB) GetGameMoves(gameID int64) ([]Move, error) {
+	rows, err := db.conn.Query(`
+		SELECT ply, color, piece, from_square, to_square, san, uci,
+		       COALESCE(captured, ''), COALESCE(promotion, '')
+		FROM game_moves WHERE game_id = ? ORDER BY ply`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moves []Move
+	for rows.Next() {
+		var m Move
+		if err := rows.Scan(&m.Ply, &m.Color, &m.Piece, &m.From, &m.To, &m.SAN, &m.UCI, &m.Captured, &m.Promotion); err != nil {
+			return nil, err
+		}
+		moves = append(moves, m)
+	}
+
+	return moves, rows.Err()
+}
+
+// ReindexGames rebuilds the indexed positions, moves, features and motifs of
+// every stored game from its stored moves and PGN, calling progress after
+// each batch. Games imported before one of these tables was added are
+// otherwise missing from the searches and exports that read them.
+func (db *DB) ReindexGames(progress func(done int)) (int, error) {
+	const batchSize = 500
+	var lastID int64
+	done := 0
+
+	for {
+		games, err := db.gameMovesAfter(lastID, batchSize)
+		if err != nil {
+			return done, err
+		}
+		if len(games) == 0 {
+			return done, nil
+		}
+
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return done, err
+		}
+
+		for _, game := range games {
+			if err := reindexGameInTx(tx, game); err != nil {
+				tx.Rollback()
+				return done, fmt.Errorf("game %d: %w", game.ID, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return done, err
+		}
+
+		done += len(games)
+		lastID = games[len(games)-1].ID
+		if progress != nil {
+			progress(done)
+		}
+	}
+}
+
+// indexTables are the per-ply tables built by insertPositionsInTx.
+var indexTables = []string{"position_index", "game_moves", "position_features", "game_motifs"}
+
+func reindexGameInTx(tx *sql.Tx, game *models.Game) error {
+	for _, table := range indexTables {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE game_id = ?", game.ID); err != nil {
+			return err
+		}
+	}
+
+	parser := &PGNParserHelper{}
+	positions, _ := parser.ExtractPositions(game.Moves)
+	AttachComments(positions, Movetext(game.PGN))
+
+	return insertPositionsInTx(tx, game.ID, positions)
+}
+
+func (db *DB) gameMovesAfter(lastID int64, limit int) ([]*models.Game, error) {
+	rows, err := db.conn.Query("SELECT id, moves, pgn FROM games WHERE id > ? ORDER BY id LIMIT ?", lastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []*models.Game
+	for rows.Next() {
+		game := &models.Game{}
+		if err := rows.Scan(&game.ID, &game.Moves, &game.PGN); err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+
+	return games, rows.Err()
+}
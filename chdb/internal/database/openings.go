@@ -0,0 +1,120 @@
+package database
+
+import (
+	"github.com/chdb/chessdb/internal/eco"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// classifyOpening fills the computed opening fields of a game from the deepest
+// position it reaches that is known to the embedded ECO table, leaving the
+// header values untouched.
+func classifyOpening(game *models.Game, positions []Position) {
+	fens := make([]string, len(positions))
+	for i, pos := range positions {
+		fens[i] = pos.FEN
+	}
+
+	opening, idx := eco.Default().Classify(fens)
+	if opening == nil {
+		return
+	}
+
+	game.ComputedECO = opening.ECO
+	game.ComputedOpening = opening.Opening
+	game.ComputedVariation = opening.Variation
+	game.ComputedPly = positions[idx].MoveNumber
+}
+
+// ReclassifyOpenings recomputes the opening classification of every stored
+// game from its indexed positions, calling progress after each batch.
+func (db *DB) ReclassifyOpenings(progress func(done int)) (int, error) {
+	const batchSize = 500
+	var lastID int64
+	done := 0
+
+	for {
+		ids, err := db.gameIDsAfter(lastID, batchSize)
+		if err != nil {
+			return done, err
+		}
+		if len(ids) == 0 {
+			return done, nil
+		}
+
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return done, err
+		}
+
+		for _, id := range ids {
+			positions, err := db.GetGamePositions(id)
+			if err != nil {
+				tx.Rollback()
+				return done, err
+			}
+
+			game := &models.Game{}
+			classifyOpening(game, positions)
+
+			_, err = tx.Exec(
+				"UPDATE games SET computed_eco = ?, computed_opening = ?, computed_variation = ?, computed_ply = ? WHERE id = ?",
+				game.ComputedECO, game.ComputedOpening, game.ComputedVariation, game.ComputedPly, id,
+			)
+			if err != nil {
+				tx.Rollback()
+				return done, err
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return done, err
+		}
+
+		done += len(ids)
+		lastID = ids[len(ids)-1]
+		if progress != nil {
+			progress(done)
+		}
+	}
+}
+
+func (db *DB) gameIDsAfter(lastID int64, limit int) ([]int64, error) {
+	rows, err := db.conn.Query("SELECT id FROM games WHERE id > ? ORDER BY id LIMIT ?", lastID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (db *DB) GetGamePositions(gameID int64) ([]Position, error) {
+	rows, err := db.conn.Query(
+		"SELECT move_number, fen, position_hash FROM position_index WHERE game_id = ? ORDER BY move_number",
+		gameID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var positions []Position
+	for rows.Next() {
+		var pos Position
+		if err := rows.Scan(&pos.MoveNumber, &pos.FEN, &pos.Hash); err != nil {
+			return nil, err
+		}
+		positions = append(positions, pos)
+	}
+
+	return positions, rows.Err()
+}
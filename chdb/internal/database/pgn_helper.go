@@ -13,15 +13,18 @@ func (p *PGNParserHelper) ExtractPositions(moveText string) ([]Position, error)
 	positions := make([]Position, 0, len(moves))
 	
 	for i, moveStr := range moves {
+		before := game.Position()
 		if err := game.MoveStr(moveStr); err != nil {
 			continue
 		}
 		
+		played := game.Moves()
 		fen := game.FEN()
 		positions = append(positions, Position{
 			MoveNumber: i + 1,
 			FEN:        fen,
 			Hash:       HashPosition(fen),
+			Move:       DescribeMove(i+1, before, played[len(played)-1]),
 		})
 	}
 	
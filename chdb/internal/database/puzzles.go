@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// SavePuzzles replaces the puzzles extracted from a game. A puzzle found
+// again at the same ply keeps its ID, so training cards made from it stay
+// valid; cards of puzzles that are no longer found are removed with them.
+func (db *DB) SavePuzzles(gameID int64, puzzles []*models.Puzzle) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	plies := make([]interface{}, 0, len(puzzles)+1)
+	plies = append(plies, gameID)
+	for _, p := range puzzles {
+		var id int64
+		err := tx.QueryRow(`
+			INSERT INTO puzzles (game_id, ply, fen, color, solution, solution_san, rating, eval_cp, eval_mate)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (game_id, ply) DO UPDATE SET
+				fen = excluded.fen, color = excluded.color,
+				solution = excluded.solution, solution_san = excluded.solution_san,
+				rating = excluded.rating, eval_cp = excluded.eval_cp, eval_mate = excluded.eval_mate
+			RETURNING id`,
+			gameID, p.Ply, p.FEN, p.Color, strings.Join(p.Solution, " "), strings.Join(p.SolutionSAN, " "),
+			p.Rating, p.EvalCP, p.EvalMate,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		p.ID = id
+		p.GameID = gameID
+		plies = append(plies, p.Ply)
+
+		if _, err := tx.Exec("DELETE FROM puzzle_themes WHERE puzzle_id = ?", id); err != nil {
+			return err
+		}
+		for _, theme := range p.Themes {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO puzzle_themes (puzzle_id, theme) VALUES (?, ?)", id, theme); err != nil {
+				return err
+			}
+		}
+	}
+
+	stale := "SELECT id FROM puzzles WHERE game_id = ?"
+	if len(puzzles) > 0 {
+		stale += " AND ply NOT IN (" + strings.TrimSuffix(strings.Repeat("?,", len(puzzles)), ",") + ")"
+	}
+	if _, err := tx.Exec("DELETE FROM training_cards WHERE source = 'puzzle' AND source_id IN ("+stale+")", plies...); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM puzzles WHERE id IN ("+stale+")", plies...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dropOrphanPuzzleCards removes the training cards of puzzles that no longer
+// exist, left behind when puzzles were replaced with new IDs or their game
+// was deleted.
+func (db *DB) dropOrphanPuzzleCards() error {
+	_, err := db.conn.Exec(`
+		DELETE FROM training_cards
+		WHERE source = 'puzzle' AND source_id NOT IN (SELECT id FROM puzzles)`)
+	return err
+}
+
+func (db *DB) SearchPuzzles(query *models.PuzzleQuery) ([]*models.Puzzle, error) {
+	var conditions []string
+	var args []interface{}
+
+	if query.Theme != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM puzzle_themes t WHERE t.puzzle_id = p.id AND t.theme = ?)")
+		args = append(args, query.Theme)
+	}
+
+	if query.MinRating > 0 {
+		conditions = append(conditions, "p.rating >= ?")
+		args = append(args, query.MinRating)
+	}
+
+	if query.MaxRating > 0 {
+		conditions = append(conditions, "p.rating <= ?")
+		args = append(args, query.MaxRating)
+	}
+
+	if query.Player != "" {
+		conditions = append(conditions, "(g.white LIKE ? OR g.black LIKE ?)")
+		args = append(args, "%"+query.Player+"%", "%"+query.Player+"%")
+	}
+
+	sqlQuery := puzzleSelect
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY p.rating, p.id"
+
+	if query.Limit > 0 {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", query.Limit)
+		if query.Offset > 0 {
+			sqlQuery += fmt.Sprintf(" OFFSET %d", query.Offset)
+		}
+	}
+
+	rows, err := db.conn.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var puzzles []*models.Puzzle
+	for rows.Next() {
+		p, err := scanPuzzle(rows)
+		if err != nil {
+			return nil, err
+		}
+		puzzles = append(puzzles, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range puzzles {
+		if p.Themes, err = db.puzzleThemes(p.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return puzzles, nil
+}
+
+func (db *DB) GetPuzzle(id int64) (*models.Puzzle, error) {
+	rows, err := db.conn.Query(puzzleSelect+" WHERE p.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	p, err := scanPuzzle(rows)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	p.Themes, err = db.puzzleThemes(id)
+	return p, err
+}
+
+// GameIDs returns the IDs of the games played by a player, or of all games
+// when player is empty.
+func (db *DB) GameIDs(player string) ([]int64, error) {
+	query := "SELECT id FROM games"
+	var args []interface{}
+	if player != "" {
+		query += " WHERE white LIKE ? OR black LIKE ?"
+		args = append(args, "%"+player+"%", "%"+player+"%")
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+const puzzleSelect = `
+	SELECT p.id, p.game_id, p.ply, p.fen, p.color, p.solution, p.solution_san, p.rating,
+	       p.eval_cp, p.eval_mate, g.white, g.black, p.created_at
+	FROM puzzles p
+	JOIN games g ON g.id = p.game_id`
+
+func scanPuzzle(rows *sql.Rows) (*models.Puzzle, error) {
+	p := &models.Puzzle{}
+	var solution, solutionSAN string
+	var cp, mate sql.NullInt64
+
+	err := rows.Scan(
+		&p.ID, &p.GameID, &p.Ply, &p.FEN, &p.Color, &solution, &solutionSAN, &p.Rating,
+		&cp, &mate, &p.White, &p.Black, &p.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Solution = strings.Fields(solution)
+	p.SolutionSAN = strings.Fields(solutionSAN)
+	p.EvalCP = nullIntPtr(cp)
+	p.EvalMate = nullIntPtr(mate)
+	return p, nil
+}
+
+func (db *DB) puzzleThemes(puzzleID int64) ([]string, error) {
+	rows, err := db.conn.Query("SELECT theme FROM puzzle_themes WHERE puzzle_id = ? ORDER BY theme", puzzleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var themes []string
+	for rows.Next() {
+		var theme string
+		if err := rows.Scan(&theme); err != nil {
+			return nil, err
+		}
+		themes = append(themes, theme)
+	}
+
+	return themes, rows.Err()
+}
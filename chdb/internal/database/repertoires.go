@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// SaveRepertoire stores a repertoire and its move tree, setting the IDs of the
+// repertoire and of its moves.
+func (db *DB) SaveRepertoire(rep *models.Repertoire) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO repertoires (name, color) VALUES (?, ?)", rep.Name, rep.Color)
+	if err != nil {
+		return err
+	}
+	if rep.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	var insert func(parentID *int64, nodes []*models.RepertoireNode) error
+	insert = func(parentID *int64, nodes []*models.RepertoireNode) error {
+		for _, node := range nodes {
+			result, err := tx.Exec(`
+				INSERT INTO repertoire_moves (repertoire_id, parent_id, ply, uci, san, fen, comment)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				rep.ID, parentID, node.Ply, node.UCI, node.SAN, node.FEN, node.Comment,
+			)
+			if err != nil {
+				return err
+			}
+			if node.ID, err = result.LastInsertId(); err != nil {
+				return err
+			}
+			if err := insert(&node.ID, node.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := insert(nil, rep.Moves); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRepertoire returns a repertoire with its move tree, or nil if it does not
+// exist.
+func (db *DB) GetRepertoire(id int64) (*models.Repertoire, error) {
+	rep := &models.Repertoire{ID: id}
+	err := db.conn.QueryRow("SELECT name, color, created_at FROM repertoires WHERE id = ?", id).Scan(
+		&rep.Name, &rep.Color, &rep.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Moves are inserted depth first, so a parent is always read before its
+	// children.
+	rows, err := db.conn.Query(`
+		SELECT id, parent_id, ply, uci, san, fen, comment
+		FROM repertoire_moves WHERE repertoire_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := make(map[int64]*models.RepertoireNode)
+	for rows.Next() {
+		node := &models.RepertoireNode{}
+		var parentID sql.NullInt64
+		if err := rows.Scan(&node.ID, &parentID, &node.Ply, &node.UCI, &node.SAN, &node.FEN, &node.Comment); err != nil {
+			return nil, err
+		}
+		nodes[node.ID] = node
+		rep.MoveCount++
+
+		if parent, ok := nodes[parentID.Int64]; parentID.Valid && ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			rep.Moves = append(rep.Moves, node)
+		}
+	}
+
+	return rep, rows.Err()
+}
+
+func (db *DB) ListRepertoires() ([]*models.Repertoire, error) {
+	rows, err := db.conn.Query(`
+		SELECT r.id, r.name, r.color, r.created_at, COUNT(m.id)
+		FROM repertoires r
+		LEFT JOIN repertoire_moves m ON m.repertoire_id = r.id
+		GROUP BY r.id
+		ORDER BY r.name, r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var repertoires []*models.Repertoire
+	for rows.Next() {
+		rep := &models.Repertoire{}
+		if err := rows.Scan(&rep.ID, &rep.Name, &rep.Color, &rep.CreatedAt, &rep.MoveCount); err != nil {
+			return nil, err
+		}
+		repertoires = append(repertoires, rep)
+	}
+
+	return repertoires, rows.Err()
+}
+
+// DeleteRepertoire deletes a repertoire with its moves and the training cards
+// of its positions.
+func (db *DB) DeleteRepertoire(id int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM training_cards WHERE source = 'repertoire' AND source_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM repertoires WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dropOrphanRepertoireCards removes the training cards of repertoires that no
+// longer exist, left behind when repertoires were deleted.
+func (db *DB) dropOrphanRepertoireCards() error {
+	_, err := db.conn.Exec(`
+		DELETE FROM training_cards
+		WHERE source = 'repertoire' AND source_id NOT IN (SELECT id FROM repertoires)`)
+	return err
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tabular"
+)
+
+// ExportTable writes a row for every game matching the search, or with plies
+// a row for every move of those games, and returns the number of rows
+// written. Games are read one at a time, so tables of any size are written
+// in constant memory apart from what the writer buffers.
+func (db *DB) ExportTable(w tabular.Writer, params *models.SearchParams, plies bool) (int, error) {
+	headers := *params
+	headers.IncludeMoves = false
+	query, args := searchQuery(&headers)
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		game, err := scanSearchGame(rows)
+		if err != nil {
+			return count, err
+		}
+
+		if !plies {
+			if err := w.Write(tabular.Row{Game: game}); err != nil {
+				return count, err
+			}
+			count++
+			continue
+		}
+
+		features, err := db.gamePlyFeatures(game.ID)
+		if err != nil {
+			return count, err
+		}
+		for i := range features {
+			if err := w.Write(tabular.Row{Game: game, Ply: &features[i]}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, w.Close()
+}
+
+// gamePlyFeatures returns the moves of a game with the material of the
+// positions they lead to. The evaluation is the imported one, or else the
+// deepest stored engine evaluation of the position.
+func (db *DB) gamePlyFeatures(gameID int64) ([]models.PlyFeatures, error) {
+	rows, err := db.conn.Query(`
+		SELECT m.ply, m.color, m.san, m.uci, p.fen,
+		       COALESCE(m.eval_cp, (SELECT e.score_cp FROM evaluations e
+		                            WHERE e.position_hash = p.position_hash AND e.multipv = 1 AND m.eval_mate IS NULL
+		                            ORDER BY e.depth DESC LIMIT 1)),
+		       COALESCE(m.eval_mate, (SELECT e.score_mate FROM evaluations e
+		                              WHERE e.position_hash = p.position_hash AND e.multipv = 1 AND m.eval_cp IS NULL
+		                              ORDER BY e.depth DESC LIMIT 1)),
+		       m.clock_ms
+		FROM game_moves m
+		JOIN position_index p ON p.game_id = m.game_id AND p.move_number = m.ply
+		WHERE m.game_id = ?
+		ORDER BY m.ply`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plies []models.PlyFeatures
+	for rows.Next() {
+		var p models.PlyFeatures
+		var cp, mate, clock sql.NullInt64
+		if err := rows.Scan(&p.Ply, &p.Color, &p.SAN, &p.UCI, &p.FEN, &cp, &mate, &clock); err != nil {
+			return nil, err
+		}
+		p.MoveNumber = (p.Ply + 1) / 2
+		p.EvalCP = nullIntPtr(cp)
+		p.EvalMate = nullIntPtr(mate)
+		if clock.Valid {
+			p.ClockMS = &clock.Int64
+		}
+
+		f := ExtractFeatures(p.FEN)
+		p.WhiteMaterial = f.WhiteMaterial
+		p.BlackMaterial = f.BlackMaterial
+
+		plies = append(plies, p)
+	}
+
+	return plies, rows.Err()
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+func insertTagsInTx(tx *sql.Tx, gameID int64, tags []models.Tag) error {
+	for i, tag := range tags {
+		_, err := tx.Exec(
+			"INSERT INTO game_tags (game_id, position, name, value) VALUES (?, ?, ?, ?)",
+			gameID, i, tag.Name, tag.Value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGameTags returns the PGN header tags of a game in their original order.
+func (db *DB) GetGameTags(gameID int64) ([]models.Tag, error) {
+	rows, err := db.conn.Query("SELECT name, value FROM game_tags WHERE game_id = ? ORDER BY position", gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(&tag.Name, &tag.Value); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// backfillTags stores the header tags of games imported before game_tags
+// existed, parsing them from the stored PGN. It runs once per database as a
+// data migration.
+func (db *DB) backfillTags() error {
+	const batchSize = 500
+	var lastID int64
+
+	for {
+		rows, err := db.conn.Query(`
+			SELECT id, pgn FROM games
+			WHERE id > ? AND ltrim(pgn) LIKE '[%'
+			  AND NOT EXISTS (SELECT 1 FROM game_tags t WHERE t.game_id = games.id)
+			ORDER BY id LIMIT ?`, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+
+		var games []*models.Game
+		for rows.Next() {
+			game := &models.Game{}
+			if err := rows.Scan(&game.ID, &game.PGN); err != nil {
+				rows.Close()
+				return err
+			}
+			games = append(games, game)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(games) == 0 {
+			return nil
+		}
+
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		for _, game := range games {
+			if err := insertTagsInTx(tx, game.ID, headerTags(game.PGN)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		lastID = games[len(games)-1].ID
+	}
+}
+
+// ParseTagPairs reads the tag pairs of a header line. Tag values are PGN
+// strings, where a quote or backslash is escaped with a backslash, and may be
+// empty. It returns false if the line is not made of tag pairs.
+func ParseTagPairs(line string) ([]models.Tag, bool) {
+	var tags []models.Tag
+	i := skipSpace(line, 0)
+
+	for i < len(line) {
+		if line[i] != '[' {
+			return nil, false
+		}
+		i = skipSpace(line, i+1)
+
+		start := i
+		for i < len(line) && isSymbolChar(line[i]) {
+			i++
+		}
+		name := line[start:i]
+		if name == "" || !isAlphanumeric(name[0]) {
+			return nil, false
+		}
+
+		i = skipSpace(line, i)
+		if i >= len(line) || line[i] != '"' {
+			return nil, false
+		}
+
+		var value strings.Builder
+		closed := false
+		for i++; i < len(line); i++ {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				i++
+				value.WriteByte(line[i])
+				continue
+			}
+			if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return nil, false
+		}
+
+		i = skipSpace(line, i)
+		if i >= len(line) || line[i] != ']' {
+			return nil, false
+		}
+		i = skipSpace(line, i+1)
+
+		tags = append(tags, models.Tag{Name: name, Value: value.String()})
+	}
+
+	return tags, len(tags) > 0
+}
+
+// headerTags returns the tag pairs of the header of a stored PGN, which ends
+// at the first line that is not made of tag pairs.
+func headerTags(pgn string) []models.Tag {
+	var tags []models.Tag
+	for _, line := range strings.Split(pgn, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pairs, ok := ParseTagPairs(line)
+		if !ok {
+			break
+		}
+		tags = append(tags, pairs...)
+	}
+	return tags
+}
+
+// FormatTag writes a tag pair, escaping quotes and backslashes in its value.
+func FormatTag(name, value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return "[" + name + " \"" + value + "\"]"
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// isSymbolChar reports whether c can continue a PGN symbol such as a tag name.
+func isSymbolChar(c byte) bool {
+	return isAlphanumeric(c) || strings.IndexByte("_+#=:-", c) >= 0
+}
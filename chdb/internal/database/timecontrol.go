@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/outcome"
+	"github.com/chdb/chessdb/internal/timecontrol"
+)
+
+// applyTimeControl fills the structured time control fields of a game from
+// its TimeControl tag, leaving them empty when the tag is missing or invalid.
+func applyTimeControl(game *models.Game) {
+	tc, err := timecontrol.Parse(game.TimeControl)
+	if err != nil {
+		return
+	}
+
+	game.TimeClass = tc.Class()
+	game.BaseSeconds = tc.Base()
+	game.IncrementSeconds = tc.Increment()
+	game.PeriodMoves = tc.Moves()
+}
+
+// backfillTimeControls fills the time control fields of games stored before
+// they were added, from the TimeControl tag of their stored PGN. It runs
+// once per database as a data migration.
+func (db *DB) backfillTimeControls() error {
+	const batchSize = 500
+	var lastID int64
+
+	for {
+		rows, err := db.conn.Query(`
+			SELECT id, pgn FROM games
+			WHERE id > ? AND time_control = '' AND pgn LIKE '%[TimeControl "_%'
+			ORDER BY id LIMIT ?`, lastID, batchSize)
+		if err != nil {
+			return err
+		}
+
+		var games []*models.Game
+		for rows.Next() {
+			game := &models.Game{}
+			if err := rows.Scan(&game.ID, &game.PGN); err != nil {
+				rows.Close()
+				return err
+			}
+			games = append(games, game)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(games) == 0 {
+			return nil
+		}
+
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		for _, game := range games {
+			for _, tag := range headerTags(game.PGN) {
+				if tag.Name == "TimeControl" {
+					game.TimeControl = tag.Value
+				}
+			}
+			applyTimeControl(game)
+
+			_, err := tx.Exec(
+				"UPDATE games SET time_control = ?, time_class = ?, tc_base_seconds = ?, tc_increment_seconds = ?, tc_moves = ? WHERE id = ?",
+				game.TimeControl, game.TimeClass, game.BaseSeconds, game.IncrementSeconds, game.PeriodMoves, game.ID,
+			)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		lastID = games[len(games)-1].ID
+	}
+}
+
+// GetTimeTroubleStats compares a player's results in games where their clock
+// fell below the threshold with their other games. Only finished games with
+// imported clock times are counted; unfinished ones are counted apart.
+func (db *DB) GetTimeTroubleStats(player string, thresholdSeconds int, timeClass string) (*models.TimeTroubleStats, error) {
+	query := `
+		SELECT g.result, m.color, MIN(m.clock_ms), SUM(m.clock_ms < ?)
+		FROM games g
+		JOIN game_moves m ON m.game_id = g.id
+		WHERE m.clock_ms IS NOT NULL
+		  AND ((m.color = 'w' AND g.white = ?) OR (m.color = 'b' AND g.black = ?))`
+	args := []interface{}{int64(thresholdSeconds) * 1000, player, player}
+
+	if timeClass != "" {
+		query += " AND g.time_class = ?"
+		args = append(args, timeClass)
+	}
+	query += " GROUP BY g.id, m.color"
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &models.TimeTroubleStats{
+		Player:           player,
+		ThresholdSeconds: thresholdSeconds,
+		TimeClass:        timeClass,
+	}
+	var troublePoints, otherPoints, minClockTotal float64
+
+	for rows.Next() {
+		var result, color string
+		var minClock sql.NullInt64
+		var troubleMoves int
+		if err := rows.Scan(&result, &color, &minClock, &troubleMoves); err != nil {
+			return nil, err
+		}
+
+		points, ok := outcome.Score(result, color)
+		if !ok {
+			stats.Unfinished++
+			continue
+		}
+		stats.Games++
+		minClockTotal += float64(minClock.Int64) / 1000
+
+		if troubleMoves > 0 {
+			stats.TimeTroubleGames++
+			stats.TimeTroubleMoves += troubleMoves
+			troublePoints += points
+		} else {
+			otherPoints += points
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if stats.Games > 0 {
+		stats.TimeTroubleRate = float64(stats.TimeTroubleGames) / float64(stats.Games)
+		stats.AverageMinClock = minClockTotal / float64(stats.Games)
+	}
+	if stats.TimeTroubleGames > 0 {
+		stats.TimeTroubleScore = troublePoints / float64(stats.TimeTroubleGames)
+	}
+	if other := stats.Games - stats.TimeTroubleGames; other > 0 {
+		stats.OtherScore = otherPoints / float64(other)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// AddTrainingCards creates new cards for a user, due immediately, and returns
+// how many were added. Positions the user already has a card for are skipped,
+// and the user's cards of the source for positions not in fens are removed.
+func (db *DB) AddTrainingCards(user, source string, sourceID int64, fens []string, ease float64) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := dropStaleCards(tx, user, source, sourceID, fens); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT OR IGNORE INTO training_cards (user, source, source_id, fen, ease, due_at)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	added := 0
+	for _, fen := range fens {
+		result, err := stmt.Exec(user, source, sourceID, fen, ease, now)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := result.RowsAffected(); err == nil {
+			added += int(n)
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+// dropStaleCards deletes the user's cards of a source whose position is not
+// in fens, as when the positions of a repertoire changed.
+func dropStaleCards(tx *sql.Tx, user, source string, sourceID int64, fens []string) error {
+	keep := make(map[string]bool, len(fens))
+	for _, fen := range fens {
+		keep[fen] = true
+	}
+
+	rows, err := tx.Query("SELECT id, fen FROM training_cards WHERE user = ? AND source = ? AND source_id = ?", user, source, sourceID)
+	if err != nil {
+		return err
+	}
+	var stale []int64
+	for rows.Next() {
+		var id int64
+		var fen string
+		if err := rows.Scan(&id, &fen); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[fen] {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range stale {
+		if _, err := tx.Exec("DELETE FROM training_cards WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DueTrainingCards returns the user's cards due at the given time, most
+// overdue first.
+func (db *DB) DueTrainingCards(user string, now time.Time, limit int) ([]*models.TrainingCard, error) {
+	rows, err := db.conn.Query(trainingCardSelect+`
+		WHERE user = ? AND due_at <= ?
+		ORDER BY due_at, id
+		LIMIT ?`, user, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cards []*models.TrainingCard
+	for rows.Next() {
+		card, err := scanTrainingCard(rows)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+
+	return cards, rows.Err()
+}
+
+// CountTrainingCards returns the total number of cards of a user and how many
+// of them are due.
+func (db *DB) CountTrainingCards(user string, now time.Time) (total, due int, err error) {
+	err = db.conn.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(due_at <= ?), 0)
+		FROM training_cards WHERE user = ?`, now.UTC(), user).Scan(&total, &due)
+	return total, due, err
+}
+
+// GetTrainingCard returns a card of the user, or nil if it does not exist.
+func (db *DB) GetTrainingCard(user string, id int64) (*models.TrainingCard, error) {
+	rows, err := db.conn.Query(trainingCardSelect+" WHERE user = ? AND id = ?", user, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanTrainingCard(rows)
+}
+
+// DeleteTrainingCard deletes a user's card with its reviews.
+func (db *DB) DeleteTrainingCard(user string, id int64) error {
+	_, err := db.conn.Exec("DELETE FROM training_cards WHERE user = ? AND id = ?", user, id)
+	return err
+}
+
+// SaveTrainingReview stores the new schedule of a card together with the
+// review that produced it.
+func (db *DB) SaveTrainingReview(card *models.TrainingCard, review *models.TrainingReview) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE training_cards
+		SET ease = ?, interval_days = ?, repetitions = ?, lapses = ?, due_at = ?, last_reviewed = ?
+		WHERE id = ?`,
+		card.Ease, card.IntervalDays, card.Repetitions, card.Lapses, card.DueAt.UTC(), review.ReviewedAt.UTC(), card.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO training_reviews (card_id, move, correct, quality, interval_days, ease, reviewed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		card.ID, review.Move, review.Correct, review.Quality, card.IntervalDays, card.Ease, review.ReviewedAt.UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	if review.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TrainingHistory returns the user's most recent reviews.
+func (db *DB) TrainingHistory(user string, limit, offset int) ([]models.TrainingReview, error) {
+	rows, err := db.conn.Query(`
+		SELECT r.id, r.card_id, c.source, c.fen, r.move, r.correct, r.quality, r.interval_days, r.ease, r.reviewed_at
+		FROM training_reviews r
+		JOIN training_cards c ON c.id = r.card_id
+		WHERE c.user = ?
+		ORDER BY r.reviewed_at DESC, r.id DESC
+		LIMIT ? OFFSET ?`, user, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []models.TrainingReview
+	for rows.Next() {
+		var r models.TrainingReview
+		err := rows.Scan(&r.ID, &r.CardID, &r.Source, &r.FEN, &r.Move, &r.Correct, &r.Quality, &r.IntervalDays, &r.Ease, &r.ReviewedAt)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, r)
+	}
+
+	return reviews, rows.Err()
+}
+
+const trainingCardSelect = `
+	SELECT id, user, source, source_id, fen, ease, interval_days, repetitions, lapses,
+	       due_at, last_reviewed, created_at
+	FROM training_cards`
+
+func scanTrainingCard(rows *sql.Rows) (*models.TrainingCard, error) {
+	card := &models.TrainingCard{}
+	var lastReviewed sql.NullTime
+
+	err := rows.Scan(
+		&card.ID, &card.User, &card.Source, &card.SourceID, &card.FEN, &card.Ease,
+		&card.IntervalDays, &card.Repetitions, &card.Lapses,
+		&card.DueAt, &lastReviewed, &card.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastReviewed.Valid {
+		card.LastReviewed = &lastReviewed.Time
+	}
+	return card, nil
+}
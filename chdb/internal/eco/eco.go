@@ -0,0 +1,88 @@
+// Package eco classifies games by opening using an embedded copy of the
+// lichess chess-openings table (https://github.com/lichess-org/chess-openings),
+// keyed by position so that transpositions resolve to the same opening.
+package eco
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+//go:embed eco.tsv
+var ecoData string
+
+type Opening struct {
+	ECO       string `json:"eco"`
+	Name      string `json:"name"`
+	Opening   string `json:"opening"`
+	Variation string `json:"variation,omitempty"`
+	Moves     string `json:"moves"`
+}
+
+type Classifier struct {
+	positions map[string]*Opening
+}
+
+var (
+	defaultClassifier *Classifier
+	defaultOnce       sync.Once
+)
+
+// Default returns a shared classifier, parsing the embedded table on first use.
+func Default() *Classifier {
+	defaultOnce.Do(func() {
+		defaultClassifier = New()
+	})
+	return defaultClassifier
+}
+
+func New() *Classifier {
+	c := &Classifier{positions: make(map[string]*Opening)}
+
+	for i, line := range strings.Split(ecoData, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if i == 0 || len(fields) < 5 {
+			continue
+		}
+
+		opening := &Opening{ECO: fields[0], Name: fields[1], Opening: fields[1], Moves: fields[2]}
+		if idx := strings.Index(fields[1], ":"); idx >= 0 {
+			opening.Opening = strings.TrimSpace(fields[1][:idx])
+			opening.Variation = strings.TrimSpace(fields[1][idx+1:])
+		}
+
+		key := positionKey(fields[4])
+		if _, exists := c.positions[key]; !exists {
+			c.positions[key] = opening
+		}
+	}
+
+	return c
+}
+
+// Lookup returns the opening whose position matches the FEN, if any.
+func (c *Classifier) Lookup(fen string) *Opening {
+	return c.positions[positionKey(fen)]
+}
+
+// Classify returns the opening of the deepest known position reached in a game
+// given the FENs after each ply, along with the index of that ply in fens.
+func (c *Classifier) Classify(fens []string) (*Opening, int) {
+	for i := len(fens) - 1; i >= 0; i-- {
+		if opening := c.Lookup(fens[i]); opening != nil {
+			return opening, i
+		}
+	}
+	return nil, -1
+}
+
+// positionKey keeps piece placement, side to move and castling rights. The en
+// passant field is left out because sources disagree on when to record it.
+func positionKey(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 3 {
+		fields = fields[:3]
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,351 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as a scripted UCI engine: started with
+// CHESSDB_FAKE_UCI set, it speaks UCI on stdin and stdout instead of running
+// the tests, and appends every command it receives to CHESSDB_FAKE_UCI_LOG.
+func TestMain(m *testing.M) {
+	if os.Getenv("CHESSDB_FAKE_UCI") == "1" {
+		runFakeEngine()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runFakeEngine() {
+	log, err := os.OpenFile(os.Getenv("CHESSDB_FAKE_UCI_LOG"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		os.Exit(2)
+	}
+	defer log.Close()
+	record := func(event string) {
+		fmt.Fprintf(log, "%d %s\n", os.Getpid(), event)
+	}
+
+	multiPV := 1
+	searching := false
+	bestMove := func() {
+		record(">bestmove")
+		fmt.Println("bestmove e2e4 ponder e7e5")
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		command := strings.TrimSpace(in.Text())
+		record(command)
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name Fake Engine 1.0")
+			fmt.Println("id author chessdb")
+			fmt.Println("option name MultiPV type spin default 1 min 1 max 10")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "setoption":
+			if len(fields) == 5 && fields[2] == "MultiPV" {
+				multiPV, _ = strconv.Atoi(fields[4])
+			}
+		case "go":
+			if len(fields) > 1 && fields[1] == "infinite" {
+				fakeInfo(1, multiPV)
+				searching = true
+				continue
+			}
+			depth := 1
+			if len(fields) > 2 && fields[1] == "depth" {
+				depth, _ = strconv.Atoi(fields[2])
+			}
+			if len(fields) > 2 && fields[1] == "movetime" {
+				ms, _ := strconv.Atoi(fields[2])
+				time.Sleep(time.Duration(ms) * time.Millisecond)
+			}
+			for d := 1; d <= depth; d++ {
+				fakeInfo(d, multiPV)
+			}
+			// A bound score must not replace the exact score of the line.
+			fmt.Printf("info depth %d multipv 1 score cp 999 lowerbound pv a2a3\n", depth)
+			bestMove()
+		case "stop":
+			if searching {
+				searching = false
+				bestMove()
+			}
+		case "quit":
+			return
+		}
+	}
+}
+
+func fakeInfo(depth, multiPV int) {
+	pvs := []string{"e2e4 e7e5", "d2d4 d7d5", "g1f3 g8f6"}
+	for k := 1; k <= multiPV; k++ {
+		fmt.Printf("info depth %d seldepth %d multipv %d score cp %d nodes %d pv %s\n",
+			depth, depth+2, k, 50-10*k, 1000*depth, pvs[(k-1)%len(pvs)])
+	}
+}
+
+// fakeConfig points an engine at the test binary and returns the path of the
+// log of the commands it receives.
+func fakeConfig(t *testing.T, options map[string]string) (Config, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "uci.log")
+	t.Setenv("CHESSDB_FAKE_UCI", "1")
+	t.Setenv("CHESSDB_FAKE_UCI_LOG", logPath)
+	return Config{Path: os.Args[0], Options: options}, logPath
+}
+
+type logEntry struct {
+	pid   string
+	event string
+}
+
+func readLog(t *testing.T, path string) []logEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []logEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		pid, event, _ := strings.Cut(line, " ")
+		entries = append(entries, logEntry{pid, event})
+	}
+	return entries
+}
+
+func events(entries []logEntry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.event)
+	}
+	return out
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+const startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+const afterE4FEN = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"
+
+func TestHandshakeAndQuit(t *testing.T) {
+	cfg, logPath := fakeConfig(t, map[string]string{"Threads": "2", "Hash": "16"})
+
+	e, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name() != "Fake Engine 1.0" {
+		t.Errorf("Name() = %q, want %q", e.Name(), "Fake Engine 1.0")
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Close() = %v, want a clean exit", err)
+	}
+
+	got := events(readLog(t, logPath))
+	want := []string{"uci", "setoption name Hash value 16", "setoption name Threads value 2", "isready", "quit"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestAnalyzeMultiPV(t *testing.T) {
+	cfg, logPath := fakeConfig(t, nil)
+	e, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	analysis, err := e.Analyze(context.Background(), afterE4FEN, Limits{Depth: 3, MultiPV: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if analysis.Depth != 3 || analysis.BestMove != "e2e4" || analysis.Ponder != "e7e5" {
+		t.Errorf("depth %d, best move %q, ponder %q", analysis.Depth, analysis.BestMove, analysis.Ponder)
+	}
+	if len(analysis.Lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(analysis.Lines))
+	}
+	// Black is to move, so the engine's scores are negated for White.
+	for i, want := range []struct {
+		cp int
+		pv string
+	}{{-40, "e2e4 e7e5"}, {-30, "d2d4 d7d5"}} {
+		line := analysis.Lines[i]
+		if line.MultiPV != i+1 || line.Depth != 3 || line.Score.CP == nil || *line.Score.CP != want.cp ||
+			strings.Join(line.PV, " ") != want.pv || line.Nodes != 3000 {
+			t.Errorf("line %d = %+v, want multipv %d cp %d pv %q", i, line, i+1, want.cp, want.pv)
+		}
+	}
+
+	got := events(readLog(t, logPath))
+	for _, command := range []string{"setoption name MultiPV value 2", "position fen " + afterE4FEN, "go depth 3"} {
+		if indexOf(got, command) < 0 {
+			t.Errorf("engine did not receive %q: %q", command, got)
+		}
+	}
+}
+
+func TestAnalyzeMoveTime(t *testing.T) {
+	cfg, logPath := fakeConfig(t, nil)
+	e, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	start := time.Now()
+	if _, err := e.Analyze(context.Background(), startFEN, Limits{MoveTime: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("search returned after %v, before its movetime", elapsed)
+	}
+	if got := events(readLog(t, logPath)); indexOf(got, "go movetime 50") < 0 {
+		t.Errorf("engine did not receive go movetime 50: %q", got)
+	}
+}
+
+func TestAnalyzeStopsOnCancel(t *testing.T) {
+	cfg, logPath := fakeConfig(t, nil)
+	e, err := Start(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	analysis, err := e.Analyze(ctx, startFEN, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.BestMove != "e2e4" || len(analysis.Lines) != 1 {
+		t.Errorf("analysis after stop = %+v", analysis)
+	}
+
+	got := events(readLog(t, logPath))
+	if g, s := indexOf(got, "go infinite"), indexOf(got, "stop"); g < 0 || s < g {
+		t.Errorf("engine was not stopped after go infinite: %q", got)
+	}
+
+	// The engine is still usable after a stopped search.
+	if _, err := e.Analyze(context.Background(), startFEN, Limits{Depth: 1}); err != nil {
+		t.Errorf("search after stop: %v", err)
+	}
+}
+
+func TestPoolLimitsConcurrency(t *testing.T) {
+	cfg, logPath := fakeConfig(t, nil)
+	pool := NewPool(cfg, 2)
+
+	fens := make([]string, 6)
+	for i := range fens {
+		fens[i] = startFEN
+	}
+	results, err := pool.AnalyzePositions(context.Background(), fens, Limits{MoveTime: 30 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r == nil || r.BestMove != "e2e4" {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+	pool.Close()
+
+	entries := readLog(t, logPath)
+	running, maxRunning := 0, 0
+	started := make(map[string]bool)
+	quit := make(map[string]bool)
+	for _, e := range entries {
+		switch {
+		case e.event == "uci":
+			started[e.pid] = true
+		case e.event == "quit":
+			quit[e.pid] = true
+		case strings.HasPrefix(e.event, "go "):
+			running++
+			maxRunning = max(maxRunning, running)
+		case e.event == ">bestmove":
+			running--
+		}
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d searches ran at once, pool size is 2", maxRunning)
+	}
+	if len(started) > 2 {
+		t.Errorf("%d engines started, pool size is 2", len(started))
+	}
+	if len(quit) != len(started) {
+		t.Errorf("%d of %d engines quit on Close", len(quit), len(started))
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	cfg, _ := fakeConfig(t, nil)
+	pool := NewPool(cfg, 1)
+	pool.Close()
+
+	if _, err := pool.Analyze(context.Background(), startFEN, Limits{Depth: 1}); err != ErrPoolClosed {
+		t.Errorf("Analyze on a closed pool = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestGoCommand(t *testing.T) {
+	tests := []struct {
+		limits Limits
+		want   string
+	}{
+		{Limits{}, "go infinite"},
+		{Limits{Depth: 12}, "go depth 12"},
+		{Limits{MoveTime: 1500 * time.Millisecond}, "go movetime 1500"},
+		{Limits{Depth: 10, Nodes: 50000}, "go depth 10 nodes 50000"},
+	}
+	for _, tt := range tests {
+		if got := goCommand(tt.limits); got != tt.want {
+			t.Errorf("goCommand(%+v) = %q, want %q", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	line, ok := parseInfo(strings.Fields("depth 20 multipv 3 score mate 4 nodes 12345 pv h5f7 e8f7"), false)
+	if !ok || line.Depth != 20 || line.MultiPV != 3 || line.Nodes != 12345 || line.Score.Mate == nil || *line.Score.Mate != -4 {
+		t.Errorf("parseInfo = %+v, %v", line, ok)
+	}
+
+	for _, info := range []string{
+		"depth 20 score cp 31 upperbound pv e2e4",
+		"depth 20 score cp 31",
+		"depth 5 currmove e2e4 currmovenumber 1",
+	} {
+		if _, ok := parseInfo(strings.Fields(info), true); ok {
+			t.Errorf("parseInfo(%q) accepted a line without an exact score and PV", info)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+const DefaultDepth = 18
+
+var ErrPoolClosed = errors.New("engine pool is closed")
+
+// Pool hands out engine processes to callers, starting them on demand and
+// never running more than size engines at the same time. Engines that fail
+// during a search are discarded rather than reused.
+type Pool struct {
+	cfg    Config
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []*Engine
+	closed bool
+}
+
+func NewPool(cfg Config, size int) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	return &Pool{
+		cfg:   cfg,
+		slots: make(chan struct{}, size),
+	}
+}
+
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
+
+// Analyze runs a single search on an engine from the pool. Searches without
+// any limit are bounded to DefaultDepth.
+func (p *Pool) Analyze(ctx context.Context, fen string, limits Limits) (*Analysis, error) {
+	if limits.Depth <= 0 && limits.MoveTime <= 0 && limits.Nodes <= 0 {
+		limits.Depth = DefaultDepth
+	}
+
+	e, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := e.Analyze(ctx, fen, limits)
+	p.release(e, err != nil)
+	return analysis, err
+}
+
+// AnalyzePositions analyses every FEN, using up to the pool size in parallel,
+// and returns the results in the same order.
+func (p *Pool) AnalyzePositions(ctx context.Context, fens []string, limits Limits) ([]*Analysis, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*Analysis, len(fens))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+
+	for w := 0; w < p.Size() && w < len(fens); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				analysis, err := p.Analyze(ctx, fens[i], limits)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				results[i] = analysis
+			}
+		}()
+	}
+
+	for i := range fens {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, ctx.Err()
+}
+
+func (p *Pool) acquire(ctx context.Context) (*Engine, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		e := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return e, nil
+	}
+	p.mu.Unlock()
+
+	e, err := Start(ctx, p.cfg)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *Pool) release(e *Engine, broken bool) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	if broken || p.closed {
+		p.mu.Unlock()
+		e.Close()
+		return
+	}
+	p.idle = append(p.idle, e)
+	p.mu.Unlock()
+}
+
+// Close shuts down idle engines; engines still searching are closed when
+// they are released.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, e := range idle {
+		e.Close()
+	}
+}
@@ -0,0 +1,382 @@
+// Package engine runs external UCI chess engines and collects their analysis.
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	quitTimeout      = 2 * time.Second
+)
+
+var ErrEngineExited = errors.New("engine process exited")
+
+type Config struct {
+	Path    string
+	Args    []string
+	Options map[string]string
+}
+
+type Limits struct {
+	Depth    int           `json:"depth,omitempty"`
+	MoveTime time.Duration `json:"-"`
+	Nodes    int64         `json:"nodes,omitempty"`
+	MultiPV  int           `json:"multipv,omitempty"`
+}
+
+// Score is reported from White's point of view: positive centipawns or a
+// positive mate distance favour White.
+type Score struct {
+	CP   *int `json:"cp,omitempty"`
+	Mate *int `json:"mate,omitempty"`
+}
+
+type Line struct {
+	MultiPV int      `json:"multipv"`
+	Depth   int      `json:"depth"`
+	Score   Score    `json:"score"`
+	Nodes   int64    `json:"nodes,omitempty"`
+	PV      []string `json:"pv"`
+}
+
+type Analysis struct {
+	FEN      string `json:"fen"`
+	Engine   string `json:"engine"`
+	Depth    int    `json:"depth"`
+	BestMove string `json:"best_move"`
+	Ponder   string `json:"ponder,omitempty"`
+	Lines    []Line `json:"lines"`
+	Cached   bool   `json:"cached,omitempty"`
+}
+
+// Engine is a running UCI engine process. It is not safe for concurrent use;
+// share engines through a Pool instead.
+type Engine struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string
+	name    string
+	multiPV int
+	closeMu sync.Once
+}
+
+// Start launches the engine binary and performs the UCI handshake, applying
+// the configured options before waiting for the engine to become ready.
+func Start(ctx context.Context, cfg Config) (*Engine, error) {
+	cmd := exec.Command(cfg.Path, cfg.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting engine %s: %w", cfg.Path, err)
+	}
+
+	e := &Engine{
+		cmd:     cmd,
+		stdin:   stdin,
+		lines:   make(chan string, 256),
+		name:    cfg.Path,
+		multiPV: 1,
+	}
+
+	go func() {
+		defer close(e.lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			e.lines <- strings.TrimSpace(scanner.Text())
+		}
+	}()
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	if err := e.handshake(handshakeCtx, cfg.Options); err != nil {
+		e.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *Engine) handshake(ctx context.Context, options map[string]string) error {
+	if err := e.send("uci"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := e.readLine(ctx)
+		if err != nil {
+			return fmt.Errorf("uci handshake: %w", err)
+		}
+		if strings.HasPrefix(line, "id name ") {
+			e.name = strings.TrimPrefix(line, "id name ")
+		}
+		if line == "uciok" {
+			break
+		}
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := e.send(fmt.Sprintf("setoption name %s value %s", name, options[name])); err != nil {
+			return err
+		}
+	}
+
+	return e.waitReady(ctx)
+}
+
+func (e *Engine) Name() string {
+	return e.name
+}
+
+// SplitName separates the version from an engine's "id name", such as
+// "Stockfish 16.1", when the last word starts with a digit.
+func SplitName(id string) (name, version string) {
+	id = strings.TrimSpace(id)
+	idx := strings.LastIndex(id, " ")
+	if idx < 0 || id[idx+1] < '0' || id[idx+1] > '9' {
+		return id, ""
+	}
+	return id[:idx], id[idx+1:]
+}
+
+// Analyze searches the position until one of the limits is reached. If ctx is
+// cancelled the search is stopped and the best result so far is returned.
+func (e *Engine) Analyze(ctx context.Context, fen string, limits Limits) (*Analysis, error) {
+	multiPV := limits.MultiPV
+	if multiPV <= 0 {
+		multiPV = 1
+	}
+	if multiPV != e.multiPV {
+		if err := e.send(fmt.Sprintf("setoption name MultiPV value %d", multiPV)); err != nil {
+			return nil, err
+		}
+		e.multiPV = multiPV
+	}
+
+	if err := e.send("position fen " + fen); err != nil {
+		return nil, err
+	}
+	if err := e.send(goCommand(limits)); err != nil {
+		return nil, err
+	}
+
+	whiteToMove := true
+	if fields := strings.Fields(fen); len(fields) > 1 && fields[1] == "b" {
+		whiteToMove = false
+	}
+
+	analysis := &Analysis{FEN: fen, Engine: e.name}
+	lines := make(map[int]Line)
+	stopped := false
+
+	for {
+		line, err := e.readLine(ctx)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			if stopped {
+				return nil, err
+			}
+			stopped = true
+			if err := e.send("stop"); err != nil {
+				return nil, err
+			}
+			stopCtx, cancel := context.WithTimeout(context.Background(), quitTimeout)
+			defer cancel()
+			ctx = stopCtx
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "info":
+			if info, ok := parseInfo(fields[1:], whiteToMove); ok {
+				lines[info.MultiPV] = info
+			}
+		case "bestmove":
+			if len(fields) > 1 && fields[1] != "(none)" {
+				analysis.BestMove = fields[1]
+			}
+			if len(fields) > 3 && fields[2] == "ponder" {
+				analysis.Ponder = fields[3]
+			}
+
+			for _, l := range lines {
+				analysis.Lines = append(analysis.Lines, l)
+				if l.Depth > analysis.Depth {
+					analysis.Depth = l.Depth
+				}
+			}
+			sort.Slice(analysis.Lines, func(i, j int) bool {
+				return analysis.Lines[i].MultiPV < analysis.Lines[j].MultiPV
+			})
+			return analysis, nil
+		}
+	}
+}
+
+// NewGame tells the engine that following searches belong to a different game.
+func (e *Engine) NewGame(ctx context.Context) error {
+	if err := e.send("ucinewgame"); err != nil {
+		return err
+	}
+	return e.waitReady(ctx)
+}
+
+// Close asks the engine to quit and kills the process if it does not exit in time.
+func (e *Engine) Close() error {
+	var err error
+	e.closeMu.Do(func() {
+		e.send("quit")
+		e.stdin.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- e.cmd.Wait()
+		}()
+
+		select {
+		case err = <-done:
+		case <-time.After(quitTimeout):
+			e.cmd.Process.Kill()
+			err = <-done
+		}
+
+		for range e.lines {
+		}
+	})
+	return err
+}
+
+func (e *Engine) waitReady(ctx context.Context) error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	for {
+		line, err := e.readLine(ctx)
+		if err != nil {
+			return err
+		}
+		if line == "readyok" {
+			return nil
+		}
+	}
+}
+
+func (e *Engine) send(command string) error {
+	_, err := io.WriteString(e.stdin, command+"\n")
+	return err
+}
+
+func (e *Engine) readLine(ctx context.Context) (string, error) {
+	select {
+	case line, ok := <-e.lines:
+		if !ok {
+			return "", ErrEngineExited
+		}
+		return line, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func goCommand(limits Limits) string {
+	command := "go"
+	if limits.Depth > 0 {
+		command += " depth " + strconv.Itoa(limits.Depth)
+	}
+	if limits.MoveTime > 0 {
+		command += " movetime " + strconv.FormatInt(limits.MoveTime.Milliseconds(), 10)
+	}
+	if limits.Nodes > 0 {
+		command += " nodes " + strconv.FormatInt(limits.Nodes, 10)
+	}
+	if command == "go" {
+		command += " infinite"
+	}
+	return command
+}
+
+// parseInfo reads the fields of an "info" line that carries a principal
+// variation. Bound scores and lines without a PV are ignored.
+func parseInfo(fields []string, whiteToMove bool) (Line, bool) {
+	line := Line{MultiPV: 1}
+	hasScore := false
+
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "depth":
+			if i+1 < len(fields) {
+				line.Depth, _ = strconv.Atoi(fields[i+1])
+				i++
+			}
+		case "multipv":
+			if i+1 < len(fields) {
+				line.MultiPV, _ = strconv.Atoi(fields[i+1])
+				i++
+			}
+		case "nodes":
+			if i+1 < len(fields) {
+				line.Nodes, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				i++
+			}
+		case "score":
+			if i+2 >= len(fields) {
+				return line, false
+			}
+			value, err := strconv.Atoi(fields[i+2])
+			if err != nil {
+				return line, false
+			}
+			if !whiteToMove {
+				value = -value
+			}
+			switch fields[i+1] {
+			case "cp":
+				line.Score.CP = &value
+			case "mate":
+				line.Score.Mate = &value
+			default:
+				return line, false
+			}
+			hasScore = true
+			i += 2
+			if i+1 < len(fields) && (fields[i+1] == "lowerbound" || fields[i+1] == "upperbound") {
+				return line, false
+			}
+		case "pv":
+			line.PV = append([]string(nil), fields[i+1:]...)
+			i = len(fields)
+		}
+	}
+
+	return line, hasScore && len(line.PV) > 0
+}
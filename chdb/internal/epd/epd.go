@@ -0,0 +1,149 @@
+// Package epd reads position collections, such as test suites and opening
+// books, in Extended Position Description format or as plain lists of FENs.
+package epd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// Parse reads one position per line. Blank lines are skipped and lines that
+// cannot be read are reported with their line number.
+func Parse(text string) ([]*models.CollectionPosition, []error) {
+	var positions []*models.CollectionPosition
+	var errs []error
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		pos, err := ParseLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		pos.Line = i + 1
+		positions = append(positions, pos)
+	}
+
+	return positions, errs
+}
+
+// ParseLine reads an EPD record, the four position fields of a FEN followed
+// by opcodes such as `bm Qg6; id "WAC.001";`, or a complete FEN. The move
+// counters of the returned FEN come from the hmvc and fmvn opcodes when given.
+func ParseLine(line string) (*models.CollectionPosition, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("expected at least 4 fields")
+	}
+
+	// Skip the four position fields and the space after them.
+	rest := line
+	for i := 0; i < 4; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[len(fields[i]):]
+	}
+	rest = strings.TrimSpace(rest)
+
+	halfmove, fullmove := "0", "1"
+	var opcodes []models.EPDOpcode
+
+	if len(fields) == 6 && isCounter(fields[4]) && isCounter(fields[5]) {
+		halfmove, fullmove = fields[4], fields[5]
+	} else if rest != "" {
+		var err error
+		if opcodes, err = parseOpcodes(rest); err != nil {
+			return nil, err
+		}
+		for _, op := range opcodes {
+			switch {
+			case op.Opcode == "hmvc" && isCounter(op.Operand):
+				halfmove = op.Operand
+			case op.Opcode == "fmvn" && isCounter(op.Operand):
+				fullmove = op.Operand
+			}
+		}
+	}
+
+	fen := strings.Join(append(fields[:4:4], halfmove, fullmove), " ")
+	if _, err := chess.FEN(fen); err != nil {
+		return nil, err
+	}
+
+	return &models.CollectionPosition{FEN: fen, Opcodes: opcodes}, nil
+}
+
+// parseOpcodes reads opcodes terminated by semicolons. String operands are
+// quoted and may escape a quote or backslash with a backslash; an opcode's
+// operands are kept as one space separated string.
+func parseOpcodes(text string) ([]models.EPDOpcode, error) {
+	var opcodes []models.EPDOpcode
+	var tokens []string
+
+	for i := 0; i < len(text); {
+		switch c := text[i]; {
+		case c == ' ' || c == '\t':
+			i++
+
+		case c == ';':
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("empty opcode")
+			}
+			opcodes = append(opcodes, models.EPDOpcode{
+				Opcode:  tokens[0],
+				Operand: strings.Join(tokens[1:], " "),
+			})
+			tokens = nil
+			i++
+
+		case c == '"':
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("opcode expected before string")
+			}
+			var sb strings.Builder
+			closed := false
+			for i++; i < len(text); i++ {
+				if text[i] == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\') {
+					i++
+					sb.WriteByte(text[i])
+					continue
+				}
+				if text[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteByte(text[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, sb.String())
+
+		default:
+			start := i
+			for i < len(text) && strings.IndexByte(" \t;\"", text[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		}
+	}
+
+	if len(tokens) > 0 {
+		return nil, fmt.Errorf("opcode %q is not terminated by a semicolon", tokens[0])
+	}
+
+	return opcodes, nil
+}
+
+func isCounter(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0
+}
@@ -5,49 +5,76 @@ import (
 )
 
 type Game struct {
-	ID           int64     `json:"id"`
-	Event        string    `json:"event"`
-	Site         string    `json:"site"`
-	Date         string    `json:"date"`
-	Round        string    `json:"round"`
-	White        string    `json:"white"`
-	Black        string    `json:"black"`
-	Result       string    `json:"result"`
-	WhiteElo     int       `json:"white_elo,omitempty"`
-	BlackElo     int       `json:"black_elo,omitempty"`
-	ECO          string    `json:"eco,omitempty"`
-	Opening      string    `json:"opening,omitempty"`
-	Variation    string    `json:"variation,omitempty"`
-	PGN          string    `json:"pgn"`
-	Moves        string    `json:"moves"`
-	FEN          string    `json:"fen,omitempty"`
-	Positions    []byte    `json:"-"`
-	PositionHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID                int64           `json:"id"`
+	Event             string          `json:"event"`
+	Site              string          `json:"site"`
+	Date              string          `json:"date"`
+	Round             string          `json:"round"`
+	White             string          `json:"white"`
+	Black             string          `json:"black"`
+	Result            string          `json:"result"`
+	WhiteElo          int             `json:"white_elo,omitempty"`
+	BlackElo          int             `json:"black_elo,omitempty"`
+	ECO               string          `json:"eco,omitempty"`
+	Opening           string          `json:"opening,omitempty"`
+	Variation         string          `json:"variation,omitempty"`
+	ComputedECO       string          `json:"computed_eco,omitempty"`
+	ComputedOpening   string          `json:"computed_opening,omitempty"`
+	ComputedVariation string          `json:"computed_variation,omitempty"`
+	ComputedPly       int             `json:"computed_ply,omitempty"`
+	TimeControl       string          `json:"time_control,omitempty"`
+	TimeClass         string          `json:"time_class,omitempty"`
+	BaseSeconds       int             `json:"base_seconds,omitempty"`
+	IncrementSeconds  int             `json:"increment_seconds,omitempty"`
+	PeriodMoves       int             `json:"period_moves,omitempty"`
+	PGN               string          `json:"pgn"`
+	Moves             string          `json:"moves"`
+	FEN               string          `json:"fen,omitempty"`
+	Positions         []byte          `json:"-"`
+	PositionHash      string          `json:"-"`
+	Transform         string          `json:"transformation,omitempty"`
+	Evaluations       []PlyEvaluation `json:"evaluations,omitempty"`
+	Comments          []PlyComment    `json:"comments,omitempty"`
+	Tags              []Tag           `json:"tags,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
 }
 
 type SearchParams struct {
-	White          string   `json:"white,omitempty"`
-	Black          string   `json:"black,omitempty"`
-	Either         string   `json:"either,omitempty"`
-	ECO            string   `json:"eco,omitempty"`
-	Opening        string   `json:"opening,omitempty"`
-	Result         string   `json:"result,omitempty"`
-	DateFrom       string   `json:"date_from,omitempty"`
-	DateTo         string   `json:"date_to,omitempty"`
-	MinElo         int      `json:"min_elo,omitempty"`
-	MaxElo         int      `json:"max_elo,omitempty"`
-	Position       string   `json:"position,omitempty"`
-	Pattern        *Pattern `json:"pattern,omitempty"`
-	IncludeMoves   bool     `json:"include_moves,omitempty"`
-	Limit          int      `json:"limit,omitempty"`
-	Offset         int      `json:"offset,omitempty"`
+	IDs              []int64  `json:"ids,omitempty"`
+	White            string   `json:"white,omitempty"`
+	Black            string   `json:"black,omitempty"`
+	Either           string   `json:"either,omitempty"`
+	ECO              string   `json:"eco,omitempty"`
+	ComputedECO      string   `json:"computed_eco,omitempty"`
+	TimeClass        string   `json:"time_class,omitempty"`
+	Opening          string   `json:"opening,omitempty"`
+	Result           string   `json:"result,omitempty"`
+	DateFrom         string   `json:"date_from,omitempty"`
+	DateTo           string   `json:"date_to,omitempty"`
+	MinElo           int      `json:"min_elo,omitempty"`
+	MaxElo           int      `json:"max_elo,omitempty"`
+	Position         string   `json:"position,omitempty"`
+	FlipColors       bool     `json:"flip_colors,omitempty"`
+	Mirror           bool     `json:"mirror,omitempty"`
+	Pattern          *Pattern `json:"pattern,omitempty"`
+	Motif            string   `json:"motif,omitempty"`
+	MotifPiece       string   `json:"motif_piece,omitempty"`
+	MotifTargets     string   `json:"motif_targets,omitempty"`
+	MotifWon         bool     `json:"motif_won,omitempty"`
+	Annotation       string   `json:"annotation,omitempty"`
+	AnnotationPlayer string   `json:"annotation_player,omitempty"`
+	AnnotationPhase  string   `json:"annotation_phase,omitempty"`
+	TablebaseChanged bool     `json:"tablebase_changed,omitempty"`
+	Tags             []Tag    `json:"tags,omitempty"`
+	IncludeMoves     bool     `json:"include_moves,omitempty"`
+	Limit            int      `json:"limit,omitempty"`
+	Offset           int      `json:"offset,omitempty"`
 }
 
 type Pattern struct {
-	Board    [8][8]SquarePattern `json:"board"`
-	SideToMove string            `json:"side_to_move,omitempty"`
+	Board      [8][8]SquarePattern `json:"board"`
+	SideToMove string              `json:"side_to_move,omitempty"`
 }
 
 type SquarePattern struct {
@@ -62,4 +89,395 @@ type ImportResult struct {
 	FailedGames    int      `json:"failed_games"`
 	Errors         []string `json:"errors,omitempty"`
 	ProcessingTime float64  `json:"processing_time_seconds"`
-}
\ No newline at end of file
+}
+
+// MoveSequenceQuery finds games where Moves are played, in order unless
+// AnyOrder is set, anywhere in the game or after Position.
+type MoveSequenceQuery struct {
+	Moves      []string `json:"moves" binding:"required"`
+	Side       string   `json:"side,omitempty"`
+	Contiguous bool     `json:"contiguous,omitempty"`
+	MaxGap     int      `json:"max_gap,omitempty"`
+	AnyOrder   bool     `json:"any_order,omitempty"`
+	Within     int      `json:"within,omitempty"`
+	Position   string   `json:"position,omitempty"`
+	Limit      int      `json:"limit,omitempty"`
+}
+
+type PlyRange struct {
+	StartPly int   `json:"start_ply"`
+	EndPly   int   `json:"end_ply"`
+	Plies    []int `json:"plies"`
+}
+
+type MoveSequenceMatch struct {
+	Game   *Game      `json:"game"`
+	Ranges []PlyRange `json:"ranges"`
+}
+
+type SimilarityWeights struct {
+	Pieces   float64 `json:"pieces"`
+	Pawns    float64 `json:"pawns"`
+	Material float64 `json:"material"`
+}
+
+type SimilarPositionQuery struct {
+	FEN             string             `json:"fen" binding:"required"`
+	Weights         *SimilarityWeights `json:"weights,omitempty"`
+	MaxMaterialDiff int                `json:"max_material_diff,omitempty"`
+	SameSideToMove  bool               `json:"same_side_to_move,omitempty"`
+	MinScore        float64            `json:"min_score,omitempty"`
+	MaxCandidates   int                `json:"max_candidates,omitempty"`
+	Limit           int                `json:"limit,omitempty"`
+}
+
+type SimilarPosition struct {
+	Game  *Game   `json:"game"`
+	Ply   int     `json:"ply"`
+	FEN   string  `json:"fen"`
+	Score float64 `json:"score"`
+}
+
+type PlyEvaluation struct {
+	Ply       int    `json:"ply"`
+	Depth     int    `json:"depth"`
+	ScoreCP   *int   `json:"score_cp,omitempty"`
+	ScoreMate *int   `json:"score_mate,omitempty"`
+	BestMove  string `json:"best_move,omitempty"`
+	Engine    string `json:"engine"`
+}
+
+// GamePly is one move of a game with the position it leads to. Ply 0 is the
+// starting position and has no move.
+type GamePly struct {
+	Ply         int    `json:"ply"`
+	MoveNumber  int    `json:"move_number"`
+	Side        string `json:"side,omitempty"`
+	SAN         string `json:"san,omitempty"`
+	UCI         string `json:"uci,omitempty"`
+	FEN         string `json:"fen"`
+	PositionKey string `json:"position_key"`
+	EvalCP      *int   `json:"eval_cp,omitempty"`
+	EvalMate    *int   `json:"eval_mate,omitempty"`
+	ClockMS     *int64 `json:"clock_ms,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+// Tag is a PGN tag pair. A game's tags keep the order of its PGN header.
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PlyComment holds the evaluation, clock time and text imported from the PGN
+// comment following a move.
+type PlyComment struct {
+	Ply      int    `json:"ply"`
+	Color    string `json:"color"`
+	SAN      string `json:"san"`
+	EvalCP   *int   `json:"eval_cp,omitempty"`
+	EvalMate *int   `json:"eval_mate,omitempty"`
+	ClockMS  *int64 `json:"clock_ms,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type AnnotationThresholds struct {
+	Inaccuracy int `json:"inaccuracy"`
+	Mistake    int `json:"mistake"`
+	Blunder    int `json:"blunder"`
+}
+
+type MoveAnnotation struct {
+	Ply             int     `json:"ply"`
+	Color           string  `json:"color"`
+	SAN             string  `json:"san"`
+	Phase           string  `json:"phase"`
+	CPBefore        int     `json:"cp_before"`
+	CPAfter         int     `json:"cp_after"`
+	CPLoss          int     `json:"cp_loss"`
+	Accuracy        float64 `json:"accuracy"`
+	Classification  string  `json:"classification,omitempty"`
+	BestMove        string  `json:"best_move,omitempty"`
+	TablebaseBefore *int    `json:"tablebase_before,omitempty"`
+	TablebaseAfter  *int    `json:"tablebase_after,omitempty"`
+}
+
+type PlayerAnalysis struct {
+	ACPL         float64 `json:"acpl"`
+	Accuracy     float64 `json:"accuracy"`
+	Inaccuracies int     `json:"inaccuracies"`
+	Mistakes     int     `json:"mistakes"`
+	Blunders     int     `json:"blunders"`
+}
+
+type GameAnalysis struct {
+	GameID       int64                `json:"game_id"`
+	Engine       string               `json:"engine"`
+	Depth        int                  `json:"depth"`
+	Thresholds   AnnotationThresholds `json:"thresholds"`
+	White        PlayerAnalysis       `json:"white"`
+	Black        PlayerAnalysis       `json:"black"`
+	Moves        []MoveAnnotation     `json:"moves"`
+	AnnotatedPGN string               `json:"annotated_pgn,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+type TimeTroubleStats struct {
+	Player           string  `json:"player"`
+	ThresholdSeconds int     `json:"threshold_seconds"`
+	TimeClass        string  `json:"time_class,omitempty"`
+	Games            int     `json:"games"`
+	Unfinished       int     `json:"unfinished"`
+	TimeTroubleGames int     `json:"time_trouble_games"`
+	TimeTroubleRate  float64 `json:"time_trouble_rate"`
+	TimeTroubleScore float64 `json:"time_trouble_score"`
+	OtherScore       float64 `json:"other_score"`
+	TimeTroubleMoves int     `json:"time_trouble_moves"`
+	AverageMinClock  float64 `json:"average_min_clock_seconds"`
+}
+
+type Puzzle struct {
+	ID          int64     `json:"id"`
+	GameID      int64     `json:"game_id"`
+	Ply         int       `json:"ply"`
+	FEN         string    `json:"fen"`
+	Color       string    `json:"color"`
+	Solution    []string  `json:"solution"`
+	SolutionSAN []string  `json:"solution_san"`
+	Themes      []string  `json:"themes"`
+	Rating      int       `json:"rating"`
+	EvalCP      *int      `json:"eval_cp,omitempty"`
+	EvalMate    *int      `json:"eval_mate,omitempty"`
+	White       string    `json:"white,omitempty"`
+	Black       string    `json:"black,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type PuzzleQuery struct {
+	Theme     string `json:"theme,omitempty"`
+	MinRating int    `json:"min_rating,omitempty"`
+	MaxRating int    `json:"max_rating,omitempty"`
+	Player    string `json:"player,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	Offset    int    `json:"offset,omitempty"`
+}
+
+type RepertoireNode struct {
+	ID       int64             `json:"id,omitempty"`
+	Ply      int               `json:"ply"`
+	UCI      string            `json:"uci"`
+	SAN      string            `json:"san"`
+	FEN      string            `json:"fen"`
+	Comment  string            `json:"comment,omitempty"`
+	Children []*RepertoireNode `json:"children,omitempty"`
+}
+
+type Repertoire struct {
+	ID        int64             `json:"id"`
+	Name      string            `json:"name"`
+	Color     string            `json:"color"`
+	MoveCount int               `json:"move_count"`
+	Moves     []*RepertoireNode `json:"moves,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type RepertoireDeviation struct {
+	GameID   int64    `json:"game_id"`
+	White    string   `json:"white"`
+	Black    string   `json:"black"`
+	Date     string   `json:"date,omitempty"`
+	Result   string   `json:"result"`
+	Type     string   `json:"type"`
+	Ply      int      `json:"ply"`
+	Line     string   `json:"line"`
+	Played   string   `json:"played"`
+	Expected []string `json:"expected"`
+}
+
+type RepertoireBranch struct {
+	Line       string  `json:"line"`
+	Ply        int     `json:"ply"`
+	Games      int     `json:"games"`
+	Wins       int     `json:"wins"`
+	Draws      int     `json:"draws"`
+	Losses     int     `json:"losses"`
+	Unfinished int     `json:"unfinished"`
+	Score      float64 `json:"score"`
+}
+
+type RepertoireReport struct {
+	RepertoireID       int64                 `json:"repertoire_id"`
+	Player             string                `json:"player"`
+	Color              string                `json:"color"`
+	Games              int                   `json:"games"`
+	PlayerDeviations   int                   `json:"player_deviations"`
+	OpponentDeviations int                   `json:"opponent_deviations"`
+	EndOfBook          int                   `json:"end_of_book"`
+	Deviations         []RepertoireDeviation `json:"deviations"`
+	Branches           []RepertoireBranch    `json:"branches"`
+}
+
+type TrainingCard struct {
+	ID           int64      `json:"id"`
+	User         string     `json:"user"`
+	Source       string     `json:"source"`
+	SourceID     int64      `json:"source_id"`
+	FEN          string     `json:"fen"`
+	Ease         float64    `json:"ease"`
+	IntervalDays int        `json:"interval_days"`
+	Repetitions  int        `json:"repetitions"`
+	Lapses       int        `json:"lapses"`
+	DueAt        time.Time  `json:"due_at"`
+	LastReviewed *time.Time `json:"last_reviewed,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type TrainingReview struct {
+	ID           int64     `json:"id"`
+	CardID       int64     `json:"card_id"`
+	Source       string    `json:"source,omitempty"`
+	FEN          string    `json:"fen,omitempty"`
+	Move         string    `json:"move"`
+	Correct      bool      `json:"correct"`
+	Quality      int       `json:"quality"`
+	IntervalDays int       `json:"interval_days"`
+	Ease         float64   `json:"ease"`
+	ReviewedAt   time.Time `json:"reviewed_at"`
+}
+
+type TrainingResult struct {
+	Correct  bool          `json:"correct"`
+	Move     string        `json:"move"`
+	Expected []string      `json:"expected"`
+	Line     []string      `json:"line"`
+	Comment  string        `json:"comment,omitempty"`
+	Card     *TrainingCard `json:"card"`
+}
+
+type EPDOpcode struct {
+	Opcode  string `json:"opcode"`
+	Operand string `json:"operand,omitempty"`
+}
+
+type CollectionPosition struct {
+	ID           int64       `json:"id"`
+	CollectionID int64       `json:"collection_id"`
+	Line         int         `json:"line"`
+	FEN          string      `json:"fen"`
+	PositionKey  string      `json:"position_key"`
+	Opcodes      []EPDOpcode `json:"opcodes,omitempty"`
+	Games        int         `json:"games"`
+	Transform    string      `json:"transformation,omitempty"`
+	Score        float64     `json:"score,omitempty"`
+}
+
+type PositionCollection struct {
+	ID            int64                 `json:"id"`
+	Name          string                `json:"name"`
+	PositionCount int                   `json:"position_count"`
+	Positions     []*CollectionPosition `json:"positions,omitempty"`
+	CreatedAt     time.Time             `json:"created_at"`
+}
+
+type OpeningBook struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Entries   int       `json:"entries"`
+	Positions int       `json:"positions"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MoveStats are the results of the database games in which a move was played
+// from a position. Score is that of the side that played the move.
+type MoveStats struct {
+	UCI       string  `json:"uci"`
+	SAN       string  `json:"san"`
+	Games     int     `json:"games"`
+	WhiteWins int     `json:"white_wins"`
+	Draws     int     `json:"draws"`
+	BlackWins int     `json:"black_wins"`
+	Score     float64 `json:"score"`
+}
+
+// BookMove compares a move of a position in an opening book with the database
+// games. A move of the book that was never played has no games.
+type BookMove struct {
+	UCI           string  `json:"uci"`
+	SAN           string  `json:"san"`
+	InBook        bool    `json:"in_book"`
+	Weight        int     `json:"weight"`
+	BookPercent   float64 `json:"book_percent"`
+	Games         int     `json:"games"`
+	PlayedPercent float64 `json:"played_percent"`
+	WhiteWins     int     `json:"white_wins"`
+	Draws         int     `json:"draws"`
+	BlackWins     int     `json:"black_wins"`
+	Score         float64 `json:"score"`
+}
+
+type BookComparison struct {
+	BookID int64      `json:"book_id"`
+	FEN    string     `json:"fen"`
+	Key    string     `json:"key"`
+	Games  int        `json:"games"`
+	Moves  []BookMove `json:"moves"`
+}
+
+// PlyFeatures are a move of a game and features of the position it leads to.
+// Evaluations are from White's point of view.
+type PlyFeatures struct {
+	Ply           int    `json:"ply"`
+	MoveNumber    int    `json:"move_number"`
+	Color         string `json:"color"`
+	SAN           string `json:"san"`
+	UCI           string `json:"uci"`
+	FEN           string `json:"fen"`
+	WhiteMaterial int    `json:"white_material"`
+	BlackMaterial int    `json:"black_material"`
+	EvalCP        *int   `json:"eval_cp,omitempty"`
+	EvalMate      *int   `json:"eval_mate,omitempty"`
+	ClockMS       *int64 `json:"clock_ms,omitempty"`
+}
+
+// ImportJobFile is the result of reading one file of an import job. Games is
+// the number of games found in it and Skipped the number that could not be
+// converted to PGN.
+type ImportJobFile struct {
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+	Encoding string `json:"encoding,omitempty"`
+	Games    int    `json:"games"`
+	Skipped  int    `json:"skipped"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ImportJob is a background import of one or more files. Jobs are kept in
+// the database, and a job still running when the server stopped is resumed
+// on startup; Resumed counts those restarts.
+//
+// TotalBytes is the size of the PGN of the job's games and BytesProcessed
+// the size of those processed so far, from which ETASeconds is estimated
+// while the job runs. GamesPerSecond is the throughput since the job last
+// started. Result is set once the job has finished.
+type ImportJob struct {
+	ID             string          `json:"job_id"`
+	Kind           string          `json:"kind"`
+	Status         string          `json:"status"`
+	TotalGames     int             `json:"total_games"`
+	TotalProcessed uint64          `json:"total_processed"`
+	Imported       uint64          `json:"imported"`
+	Failed         uint64          `json:"failed"`
+	TotalBytes     int64           `json:"total_bytes"`
+	BytesProcessed int64           `json:"bytes_processed"`
+	GamesPerSecond float64         `json:"games_per_second"`
+	ETASeconds     float64         `json:"eta_seconds,omitempty"`
+	CurrentGame    string          `json:"current_game,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Resumed        int             `json:"resumed,omitempty"`
+	Files          []ImportJobFile `json:"files,omitempty"`
+	Result         *ImportResult   `json:"result,omitempty"`
+	StartTime      time.Time       `json:"start_time"`
+	LastUpdate     time.Time       `json:"last_update"`
+	FinishedAt     *time.Time      `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,184 @@
+package motif
+
+import "strings"
+
+var pieceValues = map[byte]int{
+	'P': 1, 'N': 3, 'B': 3, 'R': 5, 'Q': 9, 'K': 100,
+}
+
+var (
+	knightSteps   = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingSteps     = [][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	rookLines     = [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	bishopLines   = [][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	allLines      = append(append([][2]int{}, rookLines...), bishopLines...)
+	emptySquare   = byte(0)
+	startPosition = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+)
+
+// board holds the piece placement of a FEN indexed by square, a1 = 0 and
+// h8 = 63, using FEN letters for the pieces and 0 for empty squares.
+type board [64]byte
+
+func parseBoard(fen string) board {
+	var b board
+	placement := strings.SplitN(fen, " ", 2)[0]
+	rank, file := 7, 0
+	for i := 0; i < len(placement); i++ {
+		c := placement[i]
+		switch {
+		case c == '/':
+			rank--
+			file = 0
+		case c >= '1' && c <= '8':
+			file += int(c - '0')
+		default:
+			if rank >= 0 && file < 8 {
+				b[rank*8+file] = c
+			}
+			file++
+		}
+	}
+	return b
+}
+
+func parseSquare(s string) int {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return -1
+	}
+	return int(s[1]-'1')*8 + int(s[0]-'a')
+}
+
+func pieceType(p byte) byte {
+	if p >= 'a' && p <= 'z' {
+		return p - 'a' + 'A'
+	}
+	return p
+}
+
+func pieceColor(p byte) byte {
+	if p == emptySquare {
+		return 0
+	}
+	if p >= 'a' && p <= 'z' {
+		return 'b'
+	}
+	return 'w'
+}
+
+func value(p byte) int {
+	return pieceValues[pieceType(p)]
+}
+
+func offset(sq, df, dr int) int {
+	f, r := sq%8+df, sq/8+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return -1
+	}
+	return r*8 + f
+}
+
+func lines(p byte) [][2]int {
+	switch pieceType(p) {
+	case 'B':
+		return bishopLines
+	case 'R':
+		return rookLines
+	case 'Q':
+		return allLines
+	}
+	return nil
+}
+
+func isSlider(p byte) bool {
+	return lines(p) != nil
+}
+
+// attacks returns the squares attacked by the piece standing on sq.
+func (b *board) attacks(sq int) []int {
+	p := b[sq]
+	var result []int
+
+	switch pieceType(p) {
+	case 'P':
+		dr := 1
+		if pieceColor(p) == 'b' {
+			dr = -1
+		}
+		for _, df := range []int{-1, 1} {
+			if to := offset(sq, df, dr); to >= 0 {
+				result = append(result, to)
+			}
+		}
+	case 'N':
+		for _, step := range knightSteps {
+			if to := offset(sq, step[0], step[1]); to >= 0 {
+				result = append(result, to)
+			}
+		}
+	case 'K':
+		for _, step := range kingSteps {
+			if to := offset(sq, step[0], step[1]); to >= 0 {
+				result = append(result, to)
+			}
+		}
+	default:
+		for _, line := range lines(p) {
+			for to := offset(sq, line[0], line[1]); to >= 0; to = offset(to, line[0], line[1]) {
+				result = append(result, to)
+				if b[to] != emptySquare {
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+func (b *board) attackedBy(sq int, color byte) bool {
+	for from := 0; from < 64; from++ {
+		if pieceColor(b[from]) != color {
+			continue
+		}
+		for _, to := range b.attacks(from) {
+			if to == sq {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// between reports whether mid lies strictly between from and to on a straight line.
+func between(from, to, mid int) bool {
+	for _, line := range allLines {
+		seen := false
+		for sq := offset(from, line[0], line[1]); sq >= 0; sq = offset(sq, line[0], line[1]) {
+			if sq == to {
+				return seen
+			}
+			if sq == mid {
+				seen = true
+			}
+		}
+	}
+	return false
+}
+
+func material(b *board, color byte) int {
+	total := 0
+	for _, p := range b {
+		if pieceColor(p) == color && pieceType(p) != 'K' {
+			total += value(p)
+		}
+	}
+	return total
+}
+
+func opponent(color byte) byte {
+	if color == 'w' {
+		return 'b'
+	}
+	return 'w'
+}
@@ -0,0 +1,218 @@
+package motif
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	Fork             = "fork"
+	Pin              = "pin"
+	Skewer           = "skewer"
+	DiscoveredAttack = "discovered_attack"
+	Sacrifice        = "sacrifice"
+)
+
+var Motifs = []string{Fork, Pin, Skewer, DiscoveredAttack, Sacrifice}
+
+// Ply is a single half-move of a game: the squares the piece moved between and
+// the FEN of the resulting position.
+type Ply struct {
+	Number int
+	FEN    string
+	From   string
+	To     string
+}
+
+// Tag marks a ply on which the side to move executed a tactical motif. Piece is
+// the piece carrying out the motif (or the one sacrificed) and Targets lists the
+// enemy pieces involved.
+type Tag struct {
+	Ply     int    `json:"ply"`
+	Motif   string `json:"motif"`
+	Color   string `json:"color"`
+	Piece   string `json:"piece"`
+	Targets string `json:"targets,omitempty"`
+}
+
+func IsMotif(name string) bool {
+	for _, m := range Motifs {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect runs every motif detector over the plies of a game played from the
+// standard starting position.
+func Detect(plies []Ply) []Tag {
+	boards := make([]board, len(plies)+1)
+	boards[0] = parseBoard(startPosition)
+	for i, ply := range plies {
+		boards[i+1] = parseBoard(ply.FEN)
+	}
+
+	var tags []Tag
+	for i, ply := range plies {
+		from, to := parseSquare(ply.From), parseSquare(ply.To)
+		if from < 0 || to < 0 {
+			continue
+		}
+
+		before, after := &boards[i], &boards[i+1]
+		color := pieceColor(after[to])
+		if color == 0 {
+			continue
+		}
+
+		tags = append(tags, detectFork(after, ply.Number, to, color)...)
+		tags = append(tags, detectLineMotifs(after, ply.Number, to, color)...)
+		tags = append(tags, detectDiscovered(after, ply.Number, from, to, color)...)
+		if tag, ok := detectSacrifice(boards, plies, i, before, to, color); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+func detectFork(b *board, ply, sq int, color byte) []Tag {
+	attacker := b[sq]
+	enemy := opponent(color)
+
+	var targets []byte
+	for _, target := range b.attacks(sq) {
+		p := b[target]
+		if pieceColor(p) != enemy {
+			continue
+		}
+		if pieceType(p) == 'K' || value(p) > value(attacker) || (value(p) >= 3 && !b.attackedBy(target, enemy)) {
+			targets = append(targets, pieceType(p))
+		}
+	}
+
+	if len(targets) < 2 {
+		return nil
+	}
+
+	return []Tag{newTag(ply, Fork, color, attacker, sortByValue(targets))}
+}
+
+func detectLineMotifs(b *board, ply, sq int, color byte) []Tag {
+	attacker := b[sq]
+	if !isSlider(attacker) {
+		return nil
+	}
+	enemy := opponent(color)
+
+	var tags []Tag
+	for _, line := range lines(attacker) {
+		front, back := -1, -1
+		for to := offset(sq, line[0], line[1]); to >= 0; to = offset(to, line[0], line[1]) {
+			if b[to] == emptySquare {
+				continue
+			}
+			if front < 0 {
+				front = to
+				continue
+			}
+			back = to
+			break
+		}
+
+		if front < 0 || back < 0 || pieceColor(b[front]) != enemy || pieceColor(b[back]) != enemy {
+			continue
+		}
+
+		x, y := b[front], b[back]
+		targets := string([]byte{pieceType(x), pieceType(y)})
+		switch {
+		case pieceType(x) == 'K':
+			if pieceType(y) != 'P' {
+				tags = append(tags, newTag(ply, Skewer, color, attacker, targets))
+			}
+		case pieceType(y) == 'K':
+			tags = append(tags, newTag(ply, Pin, color, attacker, targets))
+		case value(y) > value(x) && (value(y) > value(attacker) || !b.attackedBy(back, enemy)):
+			tags = append(tags, newTag(ply, Pin, color, attacker, targets))
+		case value(x) > value(y) && value(x) > value(attacker) && pieceType(y) != 'P':
+			tags = append(tags, newTag(ply, Skewer, color, attacker, targets))
+		}
+	}
+
+	return tags
+}
+
+func detectDiscovered(b *board, ply, from, to int, color byte) []Tag {
+	enemy := opponent(color)
+
+	var tags []Tag
+	for sq := 0; sq < 64; sq++ {
+		p := b[sq]
+		if sq == to || pieceColor(p) != color || !isSlider(p) {
+			continue
+		}
+		for _, target := range b.attacks(sq) {
+			t := b[target]
+			if pieceColor(t) != enemy || !between(sq, target, from) {
+				continue
+			}
+			if pieceType(t) == 'K' || value(t) > value(p) || (value(t) >= 3 && !b.attackedBy(target, enemy)) {
+				tags = append(tags, newTag(ply, DiscoveredAttack, color, p, string(pieceType(t))))
+			}
+		}
+	}
+
+	return tags
+}
+
+// detectSacrifice reports a ply whose moved piece is captured on the next ply
+// while the mover stays at least two points of material down a full move later.
+func detectSacrifice(boards []board, plies []Ply, i int, before *board, to int, color byte) (Tag, bool) {
+	moved := boards[i+1][to]
+	if value(moved) < 3 || pieceType(moved) == 'K' || i+1 >= len(plies) {
+		return Tag{}, false
+	}
+
+	reply := plies[i+1]
+	if parseSquare(reply.To) != to {
+		return Tag{}, false
+	}
+
+	balance := func(b *board) int {
+		return material(b, color) - material(b, opponent(color))
+	}
+
+	start := balance(before)
+	if balance(&boards[i+2])-start > -2 {
+		return Tag{}, false
+	}
+
+	later := i + 4
+	if later > len(plies) {
+		later = len(plies)
+	}
+	if balance(&boards[later])-start > -2 {
+		return Tag{}, false
+	}
+
+	return newTag(plies[i].Number, Sacrifice, color, moved, ""), true
+}
+
+func newTag(ply int, motif string, color, piece byte, targets string) Tag {
+	return Tag{
+		Ply:     ply,
+		Motif:   motif,
+		Color:   string(color),
+		Piece:   string(pieceType(piece)),
+		Targets: targets,
+	}
+}
+
+func sortByValue(pieces []byte) string {
+	sort.Slice(pieces, func(i, j int) bool {
+		return value(pieces[i]) > value(pieces[j])
+	})
+	return strings.ToUpper(string(pieces))
+}
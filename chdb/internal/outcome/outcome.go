@@ -0,0 +1,17 @@
+// Package outcome scores PGN game results.
+package outcome
+
+// Score returns the points a result scores for a color, "w" or "b": 1 for a
+// win, 0.5 for a draw and 0 for a loss. ok is false for unfinished games
+// ("*") and unknown results, which score nothing.
+func Score(result, color string) (points float64, ok bool) {
+	switch {
+	case result == "1/2-1/2":
+		return 0.5, true
+	case result == "1-0" && color == "w", result == "0-1" && color == "b":
+		return 1, true
+	case result == "1-0", result == "0-1":
+		return 0, true
+	}
+	return 0, false
+}
@@ -118,4 +118,24 @@ func (cp *ConcurrentParser) StreamParsePGN(pgnChannel <-chan string) <-chan *mod
 	}()
 	
 	return gameChannel
-}
\ No newline at end of file
+}
+
+// StreamParseIndexed parses games as they arrive, keeping the index of each
+// and reporting games that fail to parse instead of dropping them. Results
+// are not in index order.
+func (cp *ConcurrentParser) StreamParseIndexed(jobs <-chan ParseJob) <-chan ParseResult {
+	results := make(chan ParseResult, 100)
+	
+	var wg sync.WaitGroup
+	for i := 0; i < cp.numWorkers; i++ {
+		wg.Add(1)
+		go cp.worker(jobs, results, &wg)
+	}
+	
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	
+	return results
+}
@@ -11,10 +11,7 @@ import (
 	"github.com/chdb/chessdb/internal/models"
 )
 
-var (
-	headerRegex = regexp.MustCompile(`\[(\w+)\s+"([^"]+)"\]`)
-	moveRegex   = regexp.MustCompile(`\d+\.`)
-)
+var moveRegex = regexp.MustCompile(`\d+\.`)
 
 type PGNParser struct{}
 
@@ -23,7 +20,7 @@ func New() *PGNParser {
 }
 
 func (p *PGNParser) ParsePGN(pgnText string) ([]*models.Game, error) {
-	games := p.splitGames(pgnText)
+	games := SplitGames(pgnText)
 	parsedGames := make([]*models.Game, 0, len(games))
 
 	for _, gameText := range games {
@@ -47,36 +44,110 @@ func (p *PGNParser) ParseGameWithPositions(pgnText string) (*models.Game, []data
 	if err != nil {
 		return nil, nil, err
 	}
+	database.AttachComments(positions, database.Movetext(game.PGN))
 
 	return game, positions, nil
 }
 
-func (p *PGNParser) splitGames(pgnText string) []string {
-	lines := strings.Split(pgnText, "\n")
+// SplitGames splits PGN text into the text of each game. A game ends with its
+// termination marker (1-0, 0-1, 1/2-1/2 or *) outside comments and
+// variations, or, for a game missing the marker, where the next tag section
+// begins. Games may start with any tag or have no tags at all.
+func SplitGames(pgnText string) []string {
 	var games []string
-	var currentGame strings.Builder
-	inGame := false
+	start := 0
+	inMovetext, inBrace := false, false
+	depth := 0
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "[Event ") {
-			if inGame && currentGame.Len() > 0 {
-				games = append(games, currentGame.String())
-				currentGame.Reset()
+	flush := func(end int) {
+		if text := strings.TrimSpace(pgnText[start:end]); text != "" {
+			games = append(games, text+"\n")
+		}
+		start = end
+		inMovetext = false
+		depth = 0
+	}
+
+	for i := 0; i < len(pgnText); {
+		lineEnd := strings.IndexByte(pgnText[i:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(pgnText)
+		} else {
+			lineEnd += i
+		}
+		line := pgnText[i:lineEnd]
+
+		if !inBrace {
+			// A "%" in the first column escapes the rest of the line.
+			if strings.HasPrefix(line, "%") {
+				i = lineEnd + 1
+				continue
+			}
+			if _, ok := database.ParseTagPairs(line); ok {
+				if inMovetext {
+					flush(i)
+				}
+				i = lineEnd + 1
+				continue
 			}
-			inGame = true
 		}
-		if inGame {
-			currentGame.WriteString(line + "\n")
+
+		for j := i; j < lineEnd; {
+			c := pgnText[j]
+			if inBrace {
+				if c == '}' {
+					inBrace = false
+				}
+				j++
+				continue
+			}
+
+			switch c {
+			case ' ', '\t', '\r':
+				j++
+			case ';':
+				inMovetext = true
+				j = lineEnd
+			case '{':
+				inMovetext, inBrace = true, true
+				j++
+			case '(':
+				inMovetext = true
+				depth++
+				j++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+				j++
+			default:
+				k := j
+				for k < lineEnd && strings.IndexByte(" \t\r;{}()", pgnText[k]) < 0 {
+					k++
+				}
+				inMovetext = true
+				if depth == 0 && isTermination(pgnText[j:k]) {
+					flush(k)
+				}
+				j = k
+			}
 		}
-	}
 
-	if currentGame.Len() > 0 {
-		games = append(games, currentGame.String())
+		i = lineEnd + 1
 	}
+	flush(len(pgnText))
 
 	return games
 }
 
+func isTermination(token string) bool {
+	switch token {
+	case "1-0", "0-1", "1/2-1/2", "*":
+		return true
+	}
+	return false
+}
+
 func (p *PGNParser) parseGame(gameText string) (*models.Game, error) {
 	lines := strings.Split(gameText, "\n")
 	game := &models.Game{}
@@ -85,6 +156,9 @@ func (p *PGNParser) parseGame(gameText string) (*models.Game, error) {
 	headerSection := true
 
 	for _, line := range lines {
+		if strings.HasPrefix(line, "%") {
+			continue
+		}
 		line = strings.TrimSpace(line)
 		if line == "" {
 			if len(headers) > 0 {
@@ -93,15 +167,17 @@ func (p *PGNParser) parseGame(gameText string) (*models.Game, error) {
 			continue
 		}
 
-		if headerSection && strings.HasPrefix(line, "[") {
-			matches := headerRegex.FindStringSubmatch(line)
-			if len(matches) == 3 {
-				headers[matches[1]] = matches[2]
+		if headerSection {
+			if tags, ok := database.ParseTagPairs(line); ok {
+				for _, tag := range tags {
+					addTag(game, tag.Name, tag.Value)
+					headers[tag.Name] = tag.Value
+				}
+				continue
 			}
-		} else if !strings.HasPrefix(line, "[") {
-			headerSection = false
-			moveLines = append(moveLines, line)
 		}
+		headerSection = false
+		moveLines = append(moveLines, line)
 	}
 
 	game.Event = headers["Event"]
@@ -115,6 +191,7 @@ func (p *PGNParser) parseGame(gameText string) (*models.Game, error) {
 	game.Opening = headers["Opening"]
 	game.Variation = headers["Variation"]
 	game.FEN = headers["FEN"]
+	game.TimeControl = headers["TimeControl"]
 
 	if elo, err := strconv.Atoi(headers["WhiteElo"]); err == nil {
 		game.WhiteElo = elo
@@ -123,27 +200,55 @@ func (p *PGNParser) parseGame(gameText string) (*models.Game, error) {
 		game.BlackElo = elo
 	}
 
-	if game.White == "" || game.Black == "" || game.Result == "" {
-		return nil, fmt.Errorf("missing required fields")
+	movetext := strings.Join(moveLines, "\n")
+	moves := p.cleanMoves(movetext)
+	game.Moves = moves
+
+	if len(game.Tags) == 0 && len(p.parseMoveText(moves)) == 0 {
+		return nil, fmt.Errorf("empty game")
 	}
 
-	moves := strings.Join(moveLines, " ")
-	moves = p.cleanMoves(moves)
-	game.Moves = moves
+	// Missing roster tags take the PGN placeholders for unknown values, and a
+	// missing result the game's termination marker.
+	if game.White == "" {
+		game.White = "?"
+	}
+	if game.Black == "" {
+		game.Black = "?"
+	}
+	if game.Result == "" {
+		game.Result = "*"
+		if fields := strings.Fields(moves); len(fields) > 0 && isTermination(fields[len(fields)-1]) {
+			game.Result = fields[len(fields)-1]
+		}
+	}
 
 	var pgnBuilder strings.Builder
-	for key, value := range headers {
-		pgnBuilder.WriteString(fmt.Sprintf("[%s \"%s\"]\n", key, value))
+	for _, tag := range game.Tags {
+		pgnBuilder.WriteString(database.FormatTag(tag.Name, tag.Value) + "\n")
 	}
 	pgnBuilder.WriteString("\n")
-	pgnBuilder.WriteString(moves)
+	pgnBuilder.WriteString(movetext)
 	game.PGN = pgnBuilder.String()
 
 	return game, nil
 }
 
+// addTag records a header tag in the order it appears. A repeated tag keeps
+// its first position and takes the last value, as the header map does.
+func addTag(game *models.Game, name, value string) {
+	for i := range game.Tags {
+		if game.Tags[i].Name == name {
+			game.Tags[i].Value = value
+			return
+		}
+	}
+	game.Tags = append(game.Tags, models.Tag{Name: name, Value: value})
+}
+
 func (p *PGNParser) cleanMoves(moves string) string {
 	moves = regexp.MustCompile(`\{[^}]*\}`).ReplaceAllString(moves, "")
+	moves = regexp.MustCompile(`;[^\n]*`).ReplaceAllString(moves, "")
 	moves = regexp.MustCompile(`\([^)]*\)`).ReplaceAllString(moves, "")
 	moves = regexp.MustCompile(`\$\d+`).ReplaceAllString(moves, "")
 	moves = regexp.MustCompile(`\s+`).ReplaceAllString(moves, " ")
@@ -156,15 +261,18 @@ func (p *PGNParser) extractPositions(moveText string) ([]database.Position, erro
 	positions := make([]database.Position, 0, len(moves))
 
 	for i, moveStr := range moves {
+		before := game.Position()
 		if err := game.MoveStr(moveStr); err != nil {
 			continue
 		}
 		
+		played := game.Moves()
 		fen := game.FEN()
 		positions = append(positions, database.Position{
 			MoveNumber: i + 1,
 			FEN:        fen,
 			Hash:       database.HashPosition(fen),
+			Move:       database.DescribeMove(i+1, before, played[len(played)-1]),
 		})
 	}
 
@@ -0,0 +1,372 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+)
+
+// Import formats.
+const (
+	FormatPGN    = "pgn"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// DetectFormat picks the import format from a content type, falling back to
+// the file extension. Anything unrecognized is read as PGN.
+func DetectFormat(contentType, filename string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			return FormatNDJSON
+		case "application/json":
+			return FormatJSON
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".json":
+		return FormatJSON
+	}
+	return FormatPGN
+}
+
+// platformGame holds the fields of a game exported by the Lichess or
+// chess.com API that are needed to rebuild its PGN.
+type platformGame struct {
+	// Lichess
+	ID          string `json:"id"`
+	Rated       bool   `json:"rated"`
+	Variant     string `json:"variant"`
+	Speed       string `json:"speed"`
+	CreatedAt   int64  `json:"createdAt"`
+	Status      string `json:"status"`
+	Winner      string `json:"winner"`
+	Moves       string `json:"moves"`
+	InitialFEN  string `json:"initialFen"`
+	DaysPerTurn int    `json:"daysPerTurn"`
+	Clocks      []int  `json:"clocks"`
+	Players     struct {
+		White lichessPlayer `json:"white"`
+		Black lichessPlayer `json:"black"`
+	} `json:"players"`
+	Opening *struct {
+		ECO  string `json:"eco"`
+		Name string `json:"name"`
+	} `json:"opening"`
+	Clock *struct {
+		Initial   int `json:"initial"`
+		Increment int `json:"increment"`
+	} `json:"clock"`
+
+	// chess.com, and Lichess with pgnInJson
+	PGN          string `json:"pgn"`
+	Rules        string `json:"rules"`
+	InitialSetup string `json:"initial_setup"`
+}
+
+type lichessPlayer struct {
+	User *struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+	} `json:"user"`
+	Rating  int `json:"rating"`
+	AILevel int `json:"aiLevel"`
+}
+
+// name returns the player's name as Lichess writes it in its PGN.
+func (p lichessPlayer) name() string {
+	switch {
+	case p.User != nil:
+		return p.User.Name
+	case p.AILevel > 0:
+		return fmt.Sprintf("lichess AI level %d", p.AILevel)
+	}
+	return "Anonymous"
+}
+
+// PlatformGames converts games exported as JSON or NDJSON by Lichess or
+// chess.com to PGN. JSON may hold a single game, an array of games or a
+// chess.com archive with a "games" array; NDJSON holds one game per line, as
+// Lichess streams them. Games that cannot be converted are reported by index
+// and skipped, while an error is returned only if the input cannot be read.
+func PlatformGames(format string, data []byte) ([]string, []error, error) {
+	var raw []json.RawMessage
+
+	switch format {
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				raw = append(raw, json.RawMessage(append([]byte(nil), line...)))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, err
+		}
+
+	case FormatJSON:
+		data = bytes.TrimSpace(data)
+		if bytes.HasPrefix(data, []byte("[")) {
+			if err := json.Unmarshal(data, &raw); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+		var archive struct {
+			Games []json.RawMessage `json:"games"`
+		}
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return nil, nil, err
+		}
+		if archive.Games != nil {
+			raw = archive.Games
+		} else {
+			raw = []json.RawMessage{data}
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	var pgns []string
+	var errs []error
+	for i, msg := range raw {
+		var game platformGame
+		if err := json.Unmarshal(msg, &game); err != nil {
+			errs = append(errs, fmt.Errorf("game %d: %w", i+1, err))
+			continue
+		}
+		pgn, err := game.toPGN()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("game %d: %w", i+1, err))
+			continue
+		}
+		pgns = append(pgns, pgn)
+	}
+
+	return pgns, errs, nil
+}
+
+// platformFields are the fields of Lichess and chess.com game exports other
+// than "pgn", which both sites may include.
+var platformFields = map[string]bool{
+	// Lichess
+	"id": true, "rated": true, "variant": true, "speed": true, "perf": true,
+	"createdAt": true, "lastMoveAt": true, "status": true, "winner": true,
+	"moves": true, "initialFen": true, "daysPerTurn": true, "clocks": true,
+	"players": true, "opening": true, "clock": true,
+	// chess.com
+	"url": true, "time_control": true, "time_class": true, "end_time": true,
+	"rules": true, "initial_setup": true, "fen": true, "tcn": true, "uuid": true,
+	"white": true, "black": true,
+}
+
+// PGNRequest returns the PGN text of a {"pgn": "..."} import request. A JSON
+// object that also has fields of a Lichess or chess.com game is one of their
+// exported games, whose variant and set-up position must be checked, so it is
+// not taken as a request.
+func PGNRequest(data []byte) (string, bool) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return "", false
+	}
+
+	var pgn string
+	if json.Unmarshal(fields["pgn"], &pgn) != nil || strings.TrimSpace(pgn) == "" {
+		return "", false
+	}
+	for name := range fields {
+		if platformFields[name] {
+			return "", false
+		}
+	}
+	return pgn, true
+}
+
+// toPGN returns the game's PGN. chess.com games carry it already; Lichess
+// games are rebuilt from their moves, with the clock after each move as a
+// [%clk] comment. Positions are indexed from the standard starting position,
+// so games set up from another position are rejected like other variants.
+func (g *platformGame) toPGN() (string, error) {
+	if g.Rules != "" && g.Rules != "chess" {
+		return "", fmt.Errorf("unsupported variant %q", g.Rules)
+	}
+	if g.InitialSetup != "" && !isStartingPosition(g.InitialSetup) {
+		return "", fmt.Errorf("unsupported set-up position %q", g.InitialSetup)
+	}
+	if g.Variant != "" && g.Variant != "standard" && g.Variant != "fromPosition" {
+		return "", fmt.Errorf("unsupported variant %q", g.Variant)
+	}
+	if g.InitialFEN != "" && !isStartingPosition(g.InitialFEN) {
+		return "", fmt.Errorf("unsupported set-up position %q", g.InitialFEN)
+	}
+	if strings.TrimSpace(g.PGN) != "" {
+		return g.PGN, nil
+	}
+
+	if strings.TrimSpace(g.Moves) == "" {
+		return "", fmt.Errorf("game has no moves")
+	}
+
+	movetext, err := g.movetext()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	tag := func(name, value string) {
+		if value != "" {
+			sb.WriteString(database.FormatTag(name, value) + "\n")
+		}
+	}
+
+	created := time.UnixMilli(g.CreatedAt).UTC()
+	event := "Casual"
+	if g.Rated {
+		event = "Rated"
+	}
+	if g.Speed != "" {
+		event += " " + strings.ToUpper(g.Speed[:1]) + g.Speed[1:]
+	}
+
+	tag("Event", event+" game")
+	if g.ID != "" {
+		tag("Site", "https://lichess.org/"+g.ID)
+	}
+	if g.CreatedAt > 0 {
+		tag("Date", created.Format("2006.01.02"))
+	}
+	tag("White", g.Players.White.name())
+	tag("Black", g.Players.Black.name())
+	tag("Result", g.result())
+	if g.CreatedAt > 0 {
+		tag("UTCDate", created.Format("2006.01.02"))
+		tag("UTCTime", created.Format("15:04:05"))
+	}
+	if g.Players.White.Rating > 0 {
+		tag("WhiteElo", strconv.Itoa(g.Players.White.Rating))
+	}
+	if g.Players.Black.Rating > 0 {
+		tag("BlackElo", strconv.Itoa(g.Players.Black.Rating))
+	}
+	if g.Players.White.User != nil {
+		tag("WhiteTitle", g.Players.White.User.Title)
+	}
+	if g.Players.Black.User != nil {
+		tag("BlackTitle", g.Players.Black.User.Title)
+	}
+	switch {
+	case g.Clock != nil:
+		tag("TimeControl", fmt.Sprintf("%d+%d", g.Clock.Initial, g.Clock.Increment))
+	case g.DaysPerTurn > 0:
+		tag("TimeControl", fmt.Sprintf("1/%d", g.DaysPerTurn*24*60*60))
+	}
+	if g.Opening != nil {
+		tag("ECO", g.Opening.ECO)
+		tag("Opening", g.Opening.Name)
+	}
+	if g.Status != "" {
+		tag("Termination", g.Status)
+	}
+
+	sb.WriteString("\n" + movetext + "\n")
+	return sb.String(), nil
+}
+
+// result maps the winner and status of a Lichess game to a PGN result.
+func (g *platformGame) result() string {
+	switch g.Winner {
+	case "white":
+		return "1-0"
+	case "black":
+		return "0-1"
+	}
+	switch g.Status {
+	case "", "created", "started", "aborted", "noStart", "unknownFinish":
+		return "*"
+	}
+	return "1/2-1/2"
+}
+
+// movetext replays the moves, given in SAN or UCI, and writes them in SAN
+// with move numbers and clock comments. Lichess clocks are in centiseconds.
+func (g *platformGame) movetext() (string, error) {
+	game := chess.NewGame()
+
+	var tokens []string
+	for i, token := range strings.Fields(g.Moves) {
+		pos := game.Position()
+		move := findMove(pos, token)
+		if move == nil {
+			return "", fmt.Errorf("illegal move %q", token)
+		}
+
+		// Black's moves are numbered too after a clock comment.
+		if i == 0 || pos.Turn() == chess.White || i <= len(g.Clocks) {
+			number := fullMoveNumber(pos) + "."
+			if pos.Turn() == chess.Black {
+				number += ".."
+			}
+			tokens = append(tokens, number)
+		}
+		tokens = append(tokens, chess.AlgebraicNotation{}.Encode(pos, move))
+		if i < len(g.Clocks) {
+			tokens = append(tokens, "{ [%clk "+formatClock(g.Clocks[i])+"] }")
+		}
+
+		if err := game.Move(move); err != nil {
+			return "", err
+		}
+	}
+	tokens = append(tokens, g.result())
+
+	return strings.Join(tokens, " "), nil
+}
+
+// isStartingPosition reports whether a FEN is the standard starting position,
+// ignoring the move counters.
+func isStartingPosition(fen string) bool {
+	fields := strings.Fields(fen)
+	start := strings.Fields(chess.StartingPosition().String())
+	return len(fields) >= 4 && strings.Join(fields[:4], " ") == strings.Join(start[:4], " ")
+}
+
+// findMove returns the legal move written as token in SAN or UCI. Moves are
+// taken from the legal moves so they carry their check tags.
+func findMove(pos *chess.Position, token string) *chess.Move {
+	token = strings.TrimRight(token, "!?")
+	for _, m := range pos.ValidMoves() {
+		san := chess.AlgebraicNotation{}.Encode(pos, m)
+		if token == m.String() || token == san || token == strings.TrimRight(san, "+#") {
+			return m
+		}
+	}
+	return nil
+}
+
+// fullMoveNumber returns the move number field of the position's FEN.
+func fullMoveNumber(pos *chess.Position) string {
+	if fields := strings.Fields(pos.String()); len(fields) == 6 {
+		return fields[5]
+	}
+	return "1"
+}
+
+func formatClock(centiseconds int) string {
+	seconds := centiseconds / 100
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,173 @@
+package polyglot
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/chdb/chessdb/internal/outcome"
+	"github.com/notnil/chess"
+)
+
+// Book weightings.
+const (
+	// WeightCount weights a move by the number of games it was played in.
+	WeightCount = "count"
+	// WeightScore weights a move by the points it scored for the side that
+	// played it, two for a win and one for a draw, as Polyglot does.
+	WeightScore = "score"
+)
+
+// Options select the moves that go into a book.
+type Options struct {
+	// MinGames is the number of games a move must have been played in.
+	MinGames int
+	// MinElo is the rating the player of a move must have, if set.
+	MinElo int
+	// MaxPly is the last ply of a game that is added to the book.
+	MaxPly int
+	// Weight is WeightCount or WeightScore.
+	Weight string
+}
+
+// DefaultOptions are those of Polyglot's book maker, with the book limited
+// to the opening.
+func DefaultOptions() Options {
+	return Options{
+		MinGames: 3,
+		MaxPly:   30,
+		Weight:   WeightScore,
+	}
+}
+
+// Validate reports whether the options can build a book.
+func (o Options) Validate() error {
+	if o.Weight != WeightCount && o.Weight != WeightScore {
+		return fmt.Errorf("unknown weighting %q", o.Weight)
+	}
+	if o.MaxPly < 1 {
+		return fmt.Errorf("max ply must be positive")
+	}
+	return nil
+}
+
+type moveStats struct {
+	games  int
+	points int
+}
+
+// Builder collects the moves of games into a book.
+type Builder struct {
+	opts  Options
+	stats map[uint64]map[uint16]*moveStats
+	games int
+}
+
+func NewBuilder(opts Options) *Builder {
+	return &Builder{
+		opts:  opts,
+		stats: make(map[uint64]map[uint16]*moveStats),
+	}
+}
+
+// AddGame adds the first moves of a game, given in UCI notation, up to the
+// maximum ply or the first move that is not legal. Games without a final
+// result are skipped.
+func (b *Builder) AddGame(result string, whiteElo, blackElo int, moves []string) {
+	score, ok := outcome.Score(result, chess.White.String())
+	if !ok {
+		return
+	}
+	// Points are counted in half points, two for a win.
+	whitePoints := int(2 * score)
+
+	pos := chess.StartingPosition()
+	b.games++
+
+	for ply, uci := range moves {
+		if ply >= b.opts.MaxPly {
+			break
+		}
+
+		m := findMove(pos, uci)
+		if m == nil {
+			break
+		}
+
+		elo, points := whiteElo, whitePoints
+		if pos.Turn() == chess.Black {
+			elo, points = blackElo, 2-whitePoints
+		}
+
+		if b.opts.MinElo <= 0 || elo >= b.opts.MinElo {
+			key := Hash(pos)
+			byMove := b.stats[key]
+			if byMove == nil {
+				byMove = make(map[uint16]*moveStats)
+				b.stats[key] = byMove
+			}
+			move := EncodeMove(pos, m)
+			s := byMove[move]
+			if s == nil {
+				s = &moveStats{}
+				byMove[move] = s
+			}
+			s.games++
+			s.points += points
+		}
+
+		pos = pos.Update(m)
+	}
+}
+
+// Games returns the number of games added.
+func (b *Builder) Games() int {
+	return b.games
+}
+
+// Entries returns the book entries of the moves played in at least MinGames
+// games. Weights are scaled down within a position when they do not fit in
+// 16 bits.
+func (b *Builder) Entries() []Entry {
+	var entries []Entry
+
+	for key, byMove := range b.stats {
+		var kept []Entry
+		var weights []int
+		max := 0
+		for move, s := range byMove {
+			if s.games < b.opts.MinGames {
+				continue
+			}
+			weight := s.games
+			if b.opts.Weight == WeightScore {
+				weight = s.points
+			}
+			if weight > max {
+				max = weight
+			}
+			kept = append(kept, Entry{Key: key, Move: move})
+			weights = append(weights, weight)
+		}
+
+		for i, weight := range weights {
+			if max > math.MaxUint16 {
+				weight = int(int64(weight) * math.MaxUint16 / int64(max))
+			}
+			kept[i].Weight = uint16(weight)
+		}
+
+		entries = append(entries, kept...)
+	}
+
+	return entries
+}
+
+// findMove returns the legal move of pos with the given UCI notation.
+func findMove(pos *chess.Position, uci string) *chess.Move {
+	for _, m := range pos.ValidMoves() {
+		if m.String() == uci {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package polyglot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// The test positions of the format's documentation: the keys after some of
+// the plies of two games.
+var documentedKeys = []struct {
+	moves []string
+	keys  map[int]uint64
+}{
+	{
+		[]string{"e4", "d5", "e5", "f5"},
+		map[int]uint64{0: startKey, 1: 0x823c9b50fd114196, 2: 0x0756b94461c50fb0, 3: 0x662fafb965db29d4, 4: 0x22a48b5a8e47ff78},
+	},
+	{
+		[]string{"a4", "b5", "h4", "b4", "c4", "bxc3", "Ra3"},
+		map[int]uint64{0: startKey, 5: 0x3c8123ea7b067637, 7: 0x5c3f9b829b279560},
+	},
+}
+
+// TestHashDocumentedKeys checks the keys of the documented positions, which
+// cover the pawn, rook, castling, en passant and side to move entries.
+func TestHashDocumentedKeys(t *testing.T) {
+	for _, line := range documentedKeys {
+		game := chess.NewGame()
+		if key := Hash(game.Position()); key != startKey {
+			t.Fatalf("starting position key %016x, want %016x", key, startKey)
+		}
+		for i, move := range line.moves {
+			if err := game.MoveStr(move); err != nil {
+				t.Fatalf("%s: %v", move, err)
+			}
+			want, ok := line.keys[i+1]
+			if !ok {
+				continue
+			}
+			if key := Hash(game.Position()); key != want {
+				t.Errorf("after %s: key %016x, want %016x", strings.Join(line.moves[:i+1], " "), key, want)
+			}
+		}
+	}
+}
+
+func TestMoveRoundTrip(t *testing.T) {
+	tests := []struct {
+		fen  string
+		move string
+		want uint16
+	}{
+		// Castling is encoded as the king capturing its rook.
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O", encode(chess.E1, chess.H1, 0)},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O-O", encode(chess.E1, chess.A1, 0)},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "O-O", encode(chess.E8, chess.H8, 0)},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "O-O-O", encode(chess.E8, chess.A8, 0)},
+		{"1n5k/P7/8/8/8/8/8/K7 w - - 0 1", "a8=Q", encode(chess.A7, chess.A8, 4)},
+		{"1n5k/P7/8/8/8/8/8/K7 w - - 0 1", "a8=N", encode(chess.A7, chess.A8, 1)},
+		{"1n5k/P7/8/8/8/8/8/K7 w - - 0 1", "axb8=R", encode(chess.A7, chess.B8, 3)},
+		{"7k/8/8/8/8/8/p7/2N4K b - - 0 1", "a1=B", encode(chess.A2, chess.A1, 2)},
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "Nf3", encode(chess.G1, chess.F3, 0)},
+	}
+	for _, tt := range tests {
+		pos := position(t, tt.fen)
+		m, err := chess.AlgebraicNotation{}.Decode(pos, tt.move)
+		if err != nil {
+			t.Fatalf("%s in %s: %v", tt.move, tt.fen, err)
+		}
+		got := EncodeMove(pos, m)
+		if got != tt.want {
+			t.Errorf("EncodeMove(%s) = %04x, want %04x", tt.move, got, tt.want)
+		}
+		if back := DecodeMove(pos, got); back == nil || back.String() != m.String() || back.Promo() != m.Promo() {
+			t.Errorf("DecodeMove(%04x) = %v, want %s", got, back, tt.move)
+		}
+	}
+
+	// Castling written as the king's two-square move is accepted.
+	pos := position(t, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	if m := DecodeMove(pos, encode(chess.E1, chess.G1, 0)); m == nil || !m.HasTag(chess.KingSideCastle) {
+		t.Errorf("DecodeMove(e1g1) = %v, want O-O", m)
+	}
+	if m := DecodeMove(pos, encode(chess.E1, chess.C1, 0)); m == nil || !m.HasTag(chess.QueenSideCastle) {
+		t.Errorf("DecodeMove(e1c1) = %v, want O-O-O", m)
+	}
+}
+
+func encode(from, to chess.Square, promo int) uint16 {
+	return uint16(int(to.File()) | int(to.Rank())<<3 | int(from.File())<<6 | int(from.Rank())<<9 | promo<<12)
+}
+
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.NewGame(opt).Position()
+}
@@ -0,0 +1,385 @@
+// Package repertoire builds opening repertoires from annotated PGN and checks
+// played games against them.
+package repertoire
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/outcome"
+)
+
+// Deviation types reported by a check.
+const (
+	PlayerDeviation   = "player_deviation"
+	OpponentDeviation = "opponent_left_book"
+)
+
+// ParsePGN reads every game of a PGN file, such as the chapters of a study,
+// and merges their moves into one tree. The tree starts from the standard
+// starting position, so games set up from another position are skipped and
+// reported in the returned warnings.
+func ParsePGN(pgn string) ([]*models.RepertoireNode, []string, error) {
+	var roots []*models.RepertoireNode
+	var warnings []string
+	var tags, movetext []string
+	game := 0
+
+	flush := func() error {
+		if len(movetext) == 0 {
+			return nil
+		}
+		game++
+		defer func() { tags, movetext = nil, nil }()
+
+		if fen, ok := setUpPosition(tags); ok {
+			warnings = append(warnings, fmt.Sprintf("game %d: skipped, it starts from the set-up position %s", game, fen))
+			return nil
+		}
+
+		nodes, err := Parse(strings.Join(movetext, "\n"))
+		if err != nil {
+			return fmt.Errorf("game %d: %w", game, err)
+		}
+		roots = Merge(roots, nodes)
+		return nil
+	}
+
+	for _, line := range strings.Split(pgn, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, ok := database.ParseTagPairs(line); ok {
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+			tags = append(tags, line)
+			continue
+		}
+		movetext = append(movetext, line)
+	}
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	return roots, warnings, nil
+}
+
+// setUpPosition returns the FEN of a game whose tags set up a position other
+// than the standard starting position.
+func setUpPosition(lines []string) (string, bool) {
+	var fen, setUp string
+	for _, line := range lines {
+		pairs, _ := database.ParseTagPairs(line)
+		for _, tag := range pairs {
+			switch tag.Name {
+			case "FEN":
+				fen = strings.TrimSpace(tag.Value)
+			case "SetUp":
+				setUp = tag.Value
+			}
+		}
+	}
+
+	if fen == "" {
+		if setUp == "1" {
+			return "(missing FEN)", true
+		}
+		return "", false
+	}
+
+	start := strings.Fields(chess.StartingPosition().String())
+	fields := strings.Fields(fen)
+	if len(fields) >= 4 && strings.Join(fields[:4], " ") == strings.Join(start[:4], " ") {
+		return "", false
+	}
+	return fen, true
+}
+
+// Parse reads the moves of a PGN movetext as a tree, keeping every variation
+// and the comments that follow each move. The tree starts from the standard
+// starting position and its roots are the first moves.
+func Parse(movetext string) ([]*models.RepertoireNode, error) {
+	root := &models.RepertoireNode{FEN: chess.StartingPosition().String()}
+
+	// Each stack entry holds the node the next move follows and the node the
+	// last move followed, which a variation branches from.
+	type frame struct{ current, previous *models.RepertoireNode }
+	stack := []frame{{current: root}}
+
+	var token strings.Builder
+	endToken := func() error {
+		t := token.String()
+		token.Reset()
+		if t == "" || database.IsMoveNumber(t) || strings.HasPrefix(t, "$") {
+			return nil
+		}
+		if t == "1-0" || t == "0-1" || t == "1/2-1/2" || t == "*" {
+			return nil
+		}
+
+		top := &stack[len(stack)-1]
+		node, err := addMove(top.current, strings.TrimRight(t, "!?"))
+		if err != nil {
+			return err
+		}
+		top.previous, top.current = top.current, node
+		return nil
+	}
+
+	for i := 0; i < len(movetext); i++ {
+		c := movetext[i]
+		var err error
+		switch {
+		case c == '{' || c == ';':
+			err = endToken()
+			closing := byte('}')
+			if c == ';' {
+				closing = '\n'
+			}
+			end := strings.IndexByte(movetext[i+1:], closing)
+			if end < 0 {
+				end = len(movetext) - i - 1
+			}
+			addComment(stack[len(stack)-1].current, movetext[i+1:i+1+end])
+			i += end + 1
+		case c == '(':
+			err = endToken()
+			top := stack[len(stack)-1]
+			if top.previous == nil {
+				return nil, fmt.Errorf("variation without a preceding move")
+			}
+			stack = append(stack, frame{current: top.previous})
+		case c == ')':
+			err = endToken()
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unbalanced variation")
+			}
+			stack = stack[:len(stack)-1]
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			err = endToken()
+		default:
+			token.WriteByte(c)
+			if c == '.' {
+				err = endToken()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := endToken(); err != nil {
+		return nil, err
+	}
+
+	return root.Children, nil
+}
+
+// addMove plays a SAN move from the position of parent and returns the child
+// node for it, reusing an existing child for the same move.
+func addMove(parent *models.RepertoireNode, san string) (*models.RepertoireNode, error) {
+	pos := &chess.Position{}
+	if err := pos.UnmarshalText([]byte(parent.FEN)); err != nil {
+		return nil, err
+	}
+
+	m, err := chess.AlgebraicNotation{}.Decode(pos, san)
+	if err != nil {
+		return nil, fmt.Errorf("ply %d: invalid move %q", parent.Ply+1, san)
+	}
+
+	uci := chess.UCINotation{}.Encode(pos, m)
+	for _, child := range parent.Children {
+		if child.UCI == uci {
+			return child, nil
+		}
+	}
+
+	node := &models.RepertoireNode{
+		Ply: parent.Ply + 1,
+		UCI: uci,
+		SAN: chess.AlgebraicNotation{}.Encode(pos, m),
+		FEN: pos.Update(m).String(),
+	}
+	parent.Children = append(parent.Children, node)
+	return node, nil
+}
+
+func addComment(node *models.RepertoireNode, comment string) {
+	comment = strings.Join(strings.Fields(comment), " ")
+	if comment == "" || node.Ply == 0 {
+		return
+	}
+	if node.Comment != "" {
+		comment = node.Comment + " " + comment
+	}
+	node.Comment = comment
+}
+
+// Merge adds the moves of nodes to the tree of roots, joining identical moves,
+// and returns the merged roots.
+func Merge(roots, nodes []*models.RepertoireNode) []*models.RepertoireNode {
+	for _, node := range nodes {
+		var existing *models.RepertoireNode
+		for _, root := range roots {
+			if root.UCI == node.UCI {
+				existing = root
+				break
+			}
+		}
+
+		if existing == nil {
+			roots = append(roots, node)
+			continue
+		}
+		if existing.Comment == "" {
+			existing.Comment = node.Comment
+		}
+		existing.Children = Merge(existing.Children, node.Children)
+	}
+	return roots
+}
+
+// Count returns the number of moves in a tree.
+func Count(nodes []*models.RepertoireNode) int {
+	n := len(nodes)
+	for _, node := range nodes {
+		n += Count(node.Children)
+	}
+	return n
+}
+
+// Checker follows a player's games through a repertoire, recording where the
+// player or the opponent left it and the score reached in each branch.
+type Checker struct {
+	rep      *models.Repertoire
+	report   *models.RepertoireReport
+	branches map[*models.RepertoireNode]*models.RepertoireBranch
+}
+
+func NewChecker(rep *models.Repertoire, player string) *Checker {
+	return &Checker{
+		rep: rep,
+		report: &models.RepertoireReport{
+			RepertoireID: rep.ID,
+			Player:       player,
+			Color:        rep.Color,
+			Deviations:   []models.RepertoireDeviation{},
+		},
+		branches: make(map[*models.RepertoireNode]*models.RepertoireBranch),
+	}
+}
+
+// Add checks one game, given its moves, in which the player had the
+// repertoire's color.
+func (c *Checker) Add(game *models.Game, moves []database.Move) {
+	c.report.Games++
+	points, finished := outcome.Score(game.Result, c.rep.Color)
+
+	children := c.rep.Moves
+	var line []string
+	for _, move := range moves {
+		if len(children) == 0 {
+			c.report.EndOfBook++
+			return
+		}
+
+		var next *models.RepertoireNode
+		for _, child := range children {
+			if child.UCI == move.UCI {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			deviation := models.RepertoireDeviation{
+				GameID: game.ID,
+				White:  game.White,
+				Black:  game.Black,
+				Date:   game.Date,
+				Result: game.Result,
+				Type:   OpponentDeviation,
+				Ply:    move.Ply,
+				Line:   strings.Join(line, " "),
+				Played: move.SAN,
+			}
+			for _, child := range children {
+				deviation.Expected = append(deviation.Expected, child.SAN)
+			}
+
+			if move.Color == c.rep.Color {
+				deviation.Type = PlayerDeviation
+				c.report.PlayerDeviations++
+			} else {
+				c.report.OpponentDeviations++
+			}
+			c.report.Deviations = append(c.report.Deviations, deviation)
+			return
+		}
+
+		line = appendMove(line, next)
+		c.addBranch(next, strings.Join(line, " "), points, finished)
+		children = next.Children
+	}
+}
+
+// addBranch counts a game that reached a repertoire move. Unfinished games
+// are counted apart and left out of the score.
+func (c *Checker) addBranch(node *models.RepertoireNode, line string, points float64, finished bool) {
+	branch, ok := c.branches[node]
+	if !ok {
+		branch = &models.RepertoireBranch{Line: line, Ply: node.Ply}
+		c.branches[node] = branch
+	}
+
+	branch.Games++
+	switch {
+	case !finished:
+		branch.Unfinished++
+		return
+	case points == 1:
+		branch.Wins++
+	case points == 0.5:
+		branch.Draws++
+	default:
+		branch.Losses++
+	}
+	finishedGames := branch.Games - branch.Unfinished
+	branch.Score = (float64(branch.Wins) + 0.5*float64(branch.Draws)) / float64(finishedGames)
+}
+
+// Report returns the results of the check with the branches in repertoire
+// order.
+func (c *Checker) Report() *models.RepertoireReport {
+	c.report.Branches = []models.RepertoireBranch{}
+	var walk func(nodes []*models.RepertoireNode)
+	walk = func(nodes []*models.RepertoireNode) {
+		for _, node := range nodes {
+			if branch, ok := c.branches[node]; ok {
+				c.report.Branches = append(c.report.Branches, *branch)
+				walk(node.Children)
+			}
+		}
+	}
+	walk(c.rep.Moves)
+
+	return c.report
+}
+
+// appendMove adds a move to a line in PGN style, numbering White's moves and
+// a Black move that starts the line.
+func appendMove(line []string, node *models.RepertoireNode) []string {
+	number := (node.Ply + 1) / 2
+	switch {
+	case node.Ply%2 == 1:
+		return append(line, fmt.Sprintf("%d.", number), node.SAN)
+	case len(line) == 0:
+		return append(line, fmt.Sprintf("%d...", number), node.SAN)
+	}
+	return append(line, node.SAN)
+}
@@ -36,6 +36,27 @@ func (pm *PatternMatcher) SearchByPattern(pattern *models.Pattern, limit int) ([
 	return pm.executeQuery(query, patternHash, limit)
 }
 
+// SearchCollectionsByPattern returns the collection positions that match a
+// pattern. Collections are small, so every position is matched directly.
+func (pm *PatternMatcher) SearchCollectionsByPattern(pattern *models.Pattern, limit int) ([]*models.CollectionPosition, error) {
+	positions, err := pm.db.SearchCollectionPositions("", models.EPDOpcode{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*models.CollectionPosition
+	for _, pos := range positions {
+		if len(matches) >= limit {
+			break
+		}
+		if pm.MatchesPattern(pos.FEN, pattern) {
+			matches = append(matches, pos)
+		}
+	}
+
+	return matches, nil
+}
+
 func (pm *PatternMatcher) MatchesPattern(fen string, pattern *models.Pattern) bool {
 	board := pm.fenToBoard(fen)
 	
@@ -0,0 +1,442 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+var (
+	moveNumberRegex = regexp.MustCompile(`^(\d+)?(\.\.\.|\.)`)
+	sanRegex        = regexp.MustCompile(`^([KQRBN])?([a-h])?([1-8])?x?([a-h][1-8])(?:=?([QRBN]))?$`)
+	uciRegex        = regexp.MustCompile(`^([a-h][1-8])([a-h][1-8])([qrbn])?$`)
+	maneuverRegex   = regexp.MustCompile(`^([KQRBN])?([a-h][1-8])((?:-[a-h][1-8])+)$`)
+)
+
+const candidateBatchSize = 500
+
+type moveSpec struct {
+	Color     string
+	Piece     string
+	From      string
+	FromFile  string
+	FromRank  string
+	To        string
+	Promotion string
+	Castle    string
+}
+
+type SequenceMatcher struct {
+	db *database.DB
+}
+
+func NewSequenceMatcher(db *database.DB) *SequenceMatcher {
+	return &SequenceMatcher{db: db}
+}
+
+// SearchByMoves finds games containing the requested move sequence and
+// reports the ply ranges in which it was played. Moves may be given as SAN
+// ("Nf3", "...b5"), UCI ("g1f3") or as a maneuver of one piece ("Nf3-d2-f1-g3").
+func (sm *SequenceMatcher) SearchByMoves(query *models.MoveSequenceQuery) ([]*models.MoveSequenceMatch, error) {
+	specs, err := parseMoveSpecs(query.Moves)
+	if err != nil {
+		return nil, err
+	}
+
+	side, err := sideColor(query.Side)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, spec := range specs {
+		cond, condArgs := spec.sqlCondition()
+		conditions = append(conditions, "id IN (SELECT game_id FROM game_moves WHERE "+cond+")")
+		args = append(args, condArgs...)
+	}
+
+	anchorHash := ""
+	fromStart := false
+	if query.Position != "" {
+		anchorHash = database.HashPosition(query.Position)
+		fromStart = anchorHash == database.HashPosition(chess.StartingPosition().String())
+		if !fromStart {
+			conditions = append(conditions, "id IN (SELECT game_id FROM position_index WHERE position_hash = ?)")
+			args = append(args, anchorHash)
+		}
+	}
+
+	candidateQuery := "SELECT id FROM games WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY date DESC, id DESC LIMIT ? OFFSET ?"
+
+	var matches []*models.MoveSequenceMatch
+	for offset := 0; len(matches) < limit; offset += candidateBatchSize {
+		ids, err := sm.candidateIDs(candidateQuery, append(args, candidateBatchSize, offset)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			anchors := []int{0}
+			if anchorHash != "" && !fromStart {
+				anchors, err = sm.anchorPlies(id, anchorHash)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			moves, err := sm.db.GetGameMoves(id)
+			if err != nil {
+				return nil, err
+			}
+
+			ranges := matchSequence(moves, anchors, anchorHash != "", specs, side, query)
+			if len(ranges) == 0 {
+				continue
+			}
+
+			game, err := sm.db.GetGame(id)
+			if err != nil {
+				return nil, err
+			}
+			if game == nil {
+				continue
+			}
+
+			matches = append(matches, &models.MoveSequenceMatch{Game: game, Ranges: ranges})
+			if len(matches) >= limit {
+				break
+			}
+		}
+
+		if len(ids) < candidateBatchSize {
+			break
+		}
+	}
+
+	return matches, nil
+}
+
+func (sm *SequenceMatcher) candidateIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := sm.db.GetConn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (sm *SequenceMatcher) anchorPlies(gameID int64, hash string) ([]int, error) {
+	rows, err := sm.db.GetConn().Query(
+		"SELECT move_number FROM position_index WHERE game_id = ? AND position_hash = ? ORDER BY move_number",
+		gameID, hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plies []int
+	for rows.Next() {
+		var ply int
+		if err := rows.Scan(&ply); err != nil {
+			return nil, err
+		}
+		plies = append(plies, ply)
+	}
+
+	return plies, rows.Err()
+}
+
+func matchSequence(moves []database.Move, anchors []int, anchored bool, specs []moveSpec, side string, query *models.MoveSequenceQuery) []models.PlyRange {
+	var considered []database.Move
+	for _, m := range moves {
+		if side == "" || m.Color == side {
+			considered = append(considered, m)
+		}
+	}
+
+	maxGap := query.MaxGap
+	if query.Contiguous {
+		maxGap = 0
+	} else if maxGap == 0 {
+		maxGap = -1
+	}
+
+	seen := make(map[string]bool)
+	var ranges []models.PlyRange
+
+	for _, anchor := range anchors {
+		var window []database.Move
+		for _, m := range considered {
+			if m.Ply <= anchor {
+				continue
+			}
+			if anchored && query.Within > 0 && m.Ply > anchor+2*query.Within {
+				break
+			}
+			window = append(window, m)
+		}
+
+		for start := range window {
+			var picked []int
+			if query.AnyOrder {
+				picked = matchAnyOrder(window, start, specs, query.Within, anchored)
+			} else {
+				picked = matchInOrder(window, start, specs, maxGap, query.Within, anchored)
+			}
+			if picked == nil {
+				continue
+			}
+
+			plies := make([]int, len(picked))
+			for i, idx := range picked {
+				plies[i] = window[idx].Ply
+			}
+			r := models.PlyRange{StartPly: minInt(plies), EndPly: maxInt(plies), Plies: plies}
+
+			key := fmt.Sprint(r.Plies)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ranges = append(ranges, r)
+		}
+	}
+
+	return ranges
+}
+
+func matchInOrder(window []database.Move, start int, specs []moveSpec, maxGap, within int, anchored bool) []int {
+	if !specs[0].matches(window[start]) {
+		return nil
+	}
+
+	picked := []int{start}
+	var extend func(prev, next int) bool
+	extend = func(prev, next int) bool {
+		if next == len(specs) {
+			return true
+		}
+		for i := prev + 1; i < len(window); i++ {
+			if maxGap >= 0 && i-prev-1 > maxGap {
+				return false
+			}
+			if !anchored && within > 0 && window[i].Ply-window[start].Ply >= 2*within {
+				return false
+			}
+			if specs[next].matches(window[i]) {
+				picked = append(picked, i)
+				if extend(i, next+1) {
+					return true
+				}
+				picked = picked[:len(picked)-1]
+			}
+		}
+		return false
+	}
+
+	if !extend(start, 1) {
+		return nil
+	}
+	return picked
+}
+
+func matchAnyOrder(window []database.Move, start int, specs []moveSpec, within int, anchored bool) []int {
+	used := make(map[int]bool)
+	picked := make([]int, len(specs))
+
+	var assign func(next int) bool
+	assign = func(next int) bool {
+		if next == len(specs) {
+			return used[start]
+		}
+		for i := start; i < len(window); i++ {
+			if !anchored && within > 0 && window[i].Ply-window[start].Ply >= 2*within {
+				break
+			}
+			if used[i] || !specs[next].matches(window[i]) {
+				continue
+			}
+			used[i] = true
+			picked[next] = i
+			if assign(next + 1) {
+				return true
+			}
+			used[i] = false
+		}
+		return false
+	}
+
+	if !assign(0) {
+		return nil
+	}
+	return picked
+}
+
+func sideColor(side string) (string, error) {
+	switch strings.ToLower(side) {
+	case "", "both":
+		return "", nil
+	case "white", "w":
+		return "w", nil
+	case "black", "b":
+		return "b", nil
+	}
+	return "", fmt.Errorf("invalid side %q", side)
+}
+
+func parseMoveSpecs(tokens []string) ([]moveSpec, error) {
+	var specs []moveSpec
+	for _, token := range tokens {
+		for _, field := range strings.Fields(token) {
+			parsed, err := parseMoveSpec(field)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, parsed...)
+		}
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no moves given")
+	}
+	return specs, nil
+}
+
+func parseMoveSpec(token string) ([]moveSpec, error) {
+	color := ""
+	if m := moveNumberRegex.FindStringSubmatch(token); m != nil {
+		if m[2] == "..." {
+			color = "b"
+		} else if m[1] != "" {
+			color = "w"
+		}
+		token = token[len(m[0]):]
+	}
+	token = strings.TrimRight(token, "+#!?")
+
+	switch strings.ReplaceAll(token, "0", "O") {
+	case "O-O", "O-O-O":
+		return []moveSpec{{Color: color, Piece: "K", Castle: strings.ReplaceAll(token, "0", "O")}}, nil
+	}
+
+	if m := maneuverRegex.FindStringSubmatch(token); m != nil {
+		piece := m[1]
+		if piece == "" {
+			piece = "P"
+		}
+		squares := append([]string{m[2]}, strings.Split(strings.TrimPrefix(m[3], "-"), "-")...)
+		specs := make([]moveSpec, 0, len(squares)-1)
+		for i := 1; i < len(squares); i++ {
+			specs = append(specs, moveSpec{Color: color, Piece: piece, From: squares[i-1], To: squares[i]})
+		}
+		return specs, nil
+	}
+
+	if m := uciRegex.FindStringSubmatch(token); m != nil {
+		return []moveSpec{{Color: color, From: m[1], To: m[2], Promotion: strings.ToUpper(m[3])}}, nil
+	}
+
+	if m := sanRegex.FindStringSubmatch(token); m != nil {
+		piece := m[1]
+		if piece == "" {
+			piece = "P"
+		}
+		return []moveSpec{{Color: color, Piece: piece, FromFile: m[2], FromRank: m[3], To: m[4], Promotion: m[5]}}, nil
+	}
+
+	return nil, fmt.Errorf("invalid move %q", token)
+}
+
+func (s moveSpec) matches(m database.Move) bool {
+	if s.Color != "" && m.Color != s.Color {
+		return false
+	}
+	if s.Castle != "" {
+		return m.SAN == s.Castle
+	}
+	if s.Piece != "" && m.Piece != s.Piece {
+		return false
+	}
+	if s.From != "" && m.From != s.From {
+		return false
+	}
+	if s.FromFile != "" && m.From[:1] != s.FromFile {
+		return false
+	}
+	if s.FromRank != "" && m.From[1:] != s.FromRank {
+		return false
+	}
+	if s.Promotion != "" && m.Promotion != s.Promotion {
+		return false
+	}
+	return m.To == s.To
+}
+
+func (s moveSpec) sqlCondition() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if s.Color != "" {
+		conditions = append(conditions, "color = ?")
+		args = append(args, s.Color)
+	}
+	if s.Castle != "" {
+		conditions = append(conditions, "san = ?")
+		args = append(args, s.Castle)
+		return strings.Join(conditions, " AND "), args
+	}
+	if s.Piece != "" {
+		conditions = append(conditions, "piece = ?")
+		args = append(args, s.Piece)
+	}
+	if s.From != "" {
+		conditions = append(conditions, "from_square = ?")
+		args = append(args, s.From)
+	}
+	conditions = append(conditions, "to_square = ?")
+	args = append(args, s.To)
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func minInt(values []int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
+
+func maxInt(values []int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v > result {
+			result = v
+		}
+	}
+	return result
+}
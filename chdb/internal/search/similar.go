@@ -0,0 +1,267 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+var DefaultSimilarityWeights = models.SimilarityWeights{Pieces: 1, Pawns: 1, Material: 1}
+
+var featureValues = [12]int{1, 3, 3, 5, 9, 0, 1, 3, 3, 5, 9, 0}
+
+type SimilarityMatcher struct {
+	db *database.DB
+}
+
+func NewSimilarityMatcher(db *database.DB) *SimilarityMatcher {
+	return &SimilarityMatcher{db: db}
+}
+
+// SearchSimilar ranks indexed positions by their similarity to the query FEN.
+// Candidates share the material signature of the query, or lie within
+// MaxMaterialDiff points of it for each side, and only the best scoring
+// position of each game is reported. Every candidate is scored unless
+// MaxCandidates is set, in which case the candidates of the games imported
+// first are scored and the returned flag reports that some were left out.
+func (sm *SimilarityMatcher) SearchSimilar(query *models.SimilarPositionQuery) ([]*models.SimilarPosition, bool, error) {
+	if err := ValidateSimilarQuery(query); err != nil {
+		return nil, false, err
+	}
+
+	target := database.ExtractFeatures(query.FEN)
+	limit, maxCandidates, weights := similarOptions(query)
+
+	// The material_key index is ordered by game and ply, so the common
+	// identical-material query reads its candidates in order without sorting.
+	sqlQuery := "SELECT game_id, ply, bitboards FROM position_features WHERE "
+	var args []interface{}
+	if query.MaxMaterialDiff > 0 {
+		sqlQuery += "white_material BETWEEN ? AND ? AND black_material BETWEEN ? AND ?"
+		args = append(args,
+			target.WhiteMaterial-query.MaxMaterialDiff, target.WhiteMaterial+query.MaxMaterialDiff,
+			target.BlackMaterial-query.MaxMaterialDiff, target.BlackMaterial+query.MaxMaterialDiff,
+		)
+	} else {
+		sqlQuery += "material_key = ?"
+		args = append(args, target.MaterialKey)
+	}
+	if query.SameSideToMove {
+		sqlQuery += " AND side_to_move = ?"
+		args = append(args, target.SideToMove)
+	}
+	sqlQuery += " ORDER BY game_id, ply"
+	if maxCandidates > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, maxCandidates+1)
+	}
+
+	rows, err := sm.db.GetConn().Query(sqlQuery, args...)
+	if err != nil {
+		return nil, false, err
+	}
+
+	candidates := 0
+	truncated := false
+	best := make(map[int64]*models.SimilarPosition)
+	for rows.Next() {
+		if maxCandidates > 0 && candidates == maxCandidates {
+			truncated = true
+			break
+		}
+		candidates++
+
+		var gameID int64
+		var ply int
+		var data []byte
+		if err := rows.Scan(&gameID, &ply, &data); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+
+		var candidate database.PositionFeatures
+		candidate.UnmarshalBitboards(data)
+		score := Similarity(target, candidate, weights)
+		if score < query.MinScore {
+			continue
+		}
+
+		if current, ok := best[gameID]; !ok || score > current.Score {
+			best[gameID] = &models.SimilarPosition{Game: &models.Game{ID: gameID}, Ply: ply, Score: score}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	results := make([]*models.SimilarPosition, 0, len(best))
+	for _, result := range best {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Game.ID > results[j].Game.ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for _, result := range results {
+		game, err := sm.db.GetGame(result.Game.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		if game != nil {
+			result.Game = game
+		}
+
+		err = sm.db.GetConn().QueryRow(
+			"SELECT fen FROM position_index WHERE game_id = ? AND move_number = ?",
+			result.Game.ID, result.Ply,
+		).Scan(&result.FEN)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return results, truncated, nil
+}
+
+// SearchSimilarCollections ranks collection positions by their similarity to
+// the query FEN, choosing and limiting candidates like SearchSimilar in the
+// order of their collections and lines.
+func (sm *SimilarityMatcher) SearchSimilarCollections(query *models.SimilarPositionQuery) ([]*models.CollectionPosition, bool, error) {
+	if err := ValidateSimilarQuery(query); err != nil {
+		return nil, false, err
+	}
+
+	target := database.ExtractFeatures(query.FEN)
+	limit, maxCandidates, weights := similarOptions(query)
+
+	positions, err := sm.db.SearchCollectionPositions("", models.EPDOpcode{}, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var results []*models.CollectionPosition
+	candidates := 0
+	truncated := false
+	for _, pos := range positions {
+		candidate := database.ExtractFeatures(pos.FEN)
+		if query.MaxMaterialDiff > 0 {
+			if abs(candidate.WhiteMaterial-target.WhiteMaterial) > query.MaxMaterialDiff ||
+				abs(candidate.BlackMaterial-target.BlackMaterial) > query.MaxMaterialDiff {
+				continue
+			}
+		} else if candidate.MaterialKey != target.MaterialKey {
+			continue
+		}
+		if query.SameSideToMove && candidate.SideToMove != target.SideToMove {
+			continue
+		}
+
+		if maxCandidates > 0 && candidates == maxCandidates {
+			truncated = true
+			break
+		}
+		candidates++
+
+		pos.Score = Similarity(target, candidate, weights)
+		if pos.Score >= query.MinScore {
+			results = append(results, pos)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, truncated, nil
+}
+
+// ValidateSimilarQuery checks the FEN and weights of a similarity query, so
+// that callers can tell a bad query from a failed search.
+func ValidateSimilarQuery(query *models.SimilarPositionQuery) error {
+	if _, err := chess.FEN(query.FEN); err != nil {
+		return fmt.Errorf("invalid FEN: %v", err)
+	}
+	if query.Weights != nil && query.Weights.Pieces+query.Weights.Pawns+query.Weights.Material <= 0 {
+		return fmt.Errorf("similarity weights must not all be zero")
+	}
+	if query.MaxCandidates < 0 {
+		return fmt.Errorf("max_candidates must not be negative")
+	}
+	return nil
+}
+
+// similarOptions returns the limit, candidate limit and weights of a query
+// with their defaults. A candidate limit of 0 scores every candidate.
+func similarOptions(query *models.SimilarPositionQuery) (int, int, models.SimilarityWeights) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	maxCandidates := query.MaxCandidates
+	weights := DefaultSimilarityWeights
+	if query.Weights != nil {
+		weights = *query.Weights
+	}
+	return limit, maxCandidates, weights
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Similarity scores two positions between 0 and 1 as the weighted average of
+// their piece-square overlap, pawn structure overlap and material balance.
+func Similarity(a, b database.PositionFeatures, weights models.SimilarityWeights) float64 {
+	var shared, total, sharedPawns, totalPawns, materialDiff int
+	for i := range a.Bitboards {
+		common := bits.OnesCount64(a.Bitboards[i] & b.Bitboards[i])
+		all := bits.OnesCount64(a.Bitboards[i] | b.Bitboards[i])
+		shared += common
+		total += all
+		if i == 0 || i == 6 {
+			sharedPawns += common
+			totalPawns += all
+		}
+
+		countDiff := bits.OnesCount64(a.Bitboards[i]) - bits.OnesCount64(b.Bitboards[i])
+		if countDiff < 0 {
+			countDiff = -countDiff
+		}
+		materialDiff += countDiff * featureValues[i]
+	}
+
+	pieces := overlap(shared, total)
+	pawns := overlap(sharedPawns, totalPawns)
+	material := math.Max(0, 1-float64(materialDiff)/10)
+
+	sum := weights.Pieces + weights.Pawns + weights.Material
+	return (weights.Pieces*pieces + weights.Pawns*pawns + weights.Material*material) / sum
+}
+
+func overlap(shared, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(shared) / float64(total)
+}
@@ -0,0 +1,261 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+const (
+	Identity             = "identity"
+	ColorFlipped         = "color_flipped"
+	Mirrored             = "mirrored"
+	ColorFlippedMirrored = "color_flipped_mirrored"
+)
+
+// Transformations lists the board transformations to try for a query, always
+// starting with the query itself.
+func Transformations(flipColors, mirror bool) []string {
+	transforms := []string{Identity}
+	if flipColors {
+		transforms = append(transforms, ColorFlipped)
+	}
+	if mirror {
+		transforms = append(transforms, Mirrored)
+	}
+	if flipColors && mirror {
+		transforms = append(transforms, ColorFlippedMirrored)
+	}
+	return transforms
+}
+
+// SearchTransformed runs search once per transformation until limit games are
+// found, marking every game with the transformation it was first found under.
+func SearchTransformed(transforms []string, limit int, search func(transform string, limit int) ([]*models.Game, error)) ([]*models.Game, error) {
+	seen := make(map[int64]bool)
+	var games []*models.Game
+
+	for _, transform := range transforms {
+		found, err := search(transform, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, game := range found {
+			if seen[game.ID] || len(games) >= limit {
+				continue
+			}
+			seen[game.ID] = true
+			game.Transform = transform
+			games = append(games, game)
+		}
+	}
+
+	return games, nil
+}
+
+// SearchCollectionsTransformed works like SearchTransformed for collection
+// positions.
+func SearchCollectionsTransformed(transforms []string, limit int, search func(transform string, limit int) ([]*models.CollectionPosition, error)) ([]*models.CollectionPosition, error) {
+	seen := make(map[int64]bool)
+	var positions []*models.CollectionPosition
+
+	for _, transform := range transforms {
+		found, err := search(transform, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pos := range found {
+			if seen[pos.ID] || len(positions) >= limit {
+				continue
+			}
+			seen[pos.ID] = true
+			pos.Transform = transform
+			positions = append(positions, pos)
+		}
+	}
+
+	return positions, nil
+}
+
+func flipsColors(transform string) bool {
+	return transform == ColorFlipped || transform == ColorFlippedMirrored
+}
+
+func mirrorsFiles(transform string) bool {
+	return transform == Mirrored || transform == ColorFlippedMirrored
+}
+
+// TransformFEN applies a transformation to a FEN. Flipping colors swaps the
+// pieces and ranks, the side to move, castling rights and the en passant
+// rank; mirroring swaps the a and h files and drops castling rights, which
+// no longer describe a legal king and rook setup.
+func TransformFEN(fen, transform string) string {
+	if transform == Identity {
+		return fen
+	}
+
+	parts := strings.Fields(fen)
+	if len(parts) == 0 {
+		return fen
+	}
+
+	var board [8][8]byte
+	for rank, row := range strings.Split(parts[0], "/") {
+		if rank > 7 {
+			break
+		}
+		file := 0
+		for i := 0; i < len(row) && file < 8; i++ {
+			if row[i] >= '1' && row[i] <= '8' {
+				file += int(row[i] - '0')
+				continue
+			}
+			board[rank][file] = row[i]
+			file++
+		}
+	}
+
+	var out [8][8]byte
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			r, f, piece := rank, file, board[rank][file]
+			if flipsColors(transform) {
+				r = 7 - rank
+				piece = swapCase(piece)
+			}
+			if mirrorsFiles(transform) {
+				f = 7 - file
+			}
+			out[r][f] = piece
+		}
+	}
+
+	rows := make([]string, 8)
+	for rank := 0; rank < 8; rank++ {
+		var row strings.Builder
+		empty := 0
+		for file := 0; file < 8; file++ {
+			if out[rank][file] == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				row.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			row.WriteByte(out[rank][file])
+		}
+		if empty > 0 {
+			row.WriteByte(byte('0' + empty))
+		}
+		rows[rank] = row.String()
+	}
+	parts[0] = strings.Join(rows, "/")
+
+	if len(parts) > 1 && flipsColors(transform) {
+		if parts[1] == "w" {
+			parts[1] = "b"
+		} else {
+			parts[1] = "w"
+		}
+	}
+
+	if len(parts) > 2 {
+		if mirrorsFiles(transform) {
+			parts[2] = "-"
+		} else if flipsColors(transform) {
+			parts[2] = flipCastling(parts[2])
+		}
+	}
+
+	if len(parts) > 3 && parts[3] != "-" && len(parts[3]) == 2 {
+		file, rank := parts[3][0], parts[3][1]
+		if flipsColors(transform) {
+			rank = '1' + '8' - rank
+		}
+		if mirrorsFiles(transform) {
+			file = 'a' + 'h' - file
+		}
+		parts[3] = string([]byte{file, rank})
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func flipCastling(rights string) string {
+	if rights == "-" {
+		return rights
+	}
+	flipped := swapPieceCase(rights)
+	var result strings.Builder
+	for _, r := range "KQkq" {
+		if strings.ContainsRune(flipped, r) {
+			result.WriteRune(r)
+		}
+	}
+	if result.Len() == 0 {
+		return "-"
+	}
+	return result.String()
+}
+
+// TransformPattern applies the same transformation as TransformFEN to a
+// pattern board, which is indexed from rank 8 down to rank 1.
+func TransformPattern(pattern *models.Pattern, transform string) *models.Pattern {
+	if transform == Identity {
+		return pattern
+	}
+
+	result := &models.Pattern{SideToMove: pattern.SideToMove}
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			r, f := rank, file
+			square := pattern.Board[rank][file]
+			if flipsColors(transform) {
+				r = 7 - rank
+				if square.Pieces != nil {
+					pieces := make([]string, len(square.Pieces))
+					for i, piece := range square.Pieces {
+						pieces[i] = swapPieceCase(piece)
+					}
+					square.Pieces = pieces
+				}
+			}
+			if mirrorsFiles(transform) {
+				f = 7 - file
+			}
+			result.Board[r][f] = square
+		}
+	}
+
+	if flipsColors(transform) {
+		switch pattern.SideToMove {
+		case "white":
+			result.SideToMove = "black"
+		case "black":
+			result.SideToMove = "white"
+		}
+	}
+
+	return result
+}
+
+func swapCase(c byte) byte {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 'A'
+	case c >= 'A' && c <= 'Z':
+		return c - 'A' + 'a'
+	}
+	return c
+}
+
+func swapPieceCase(piece string) string {
+	b := []byte(piece)
+	for i := range b {
+		b[i] = swapCase(b[i])
+	}
+	return string(b)
+}
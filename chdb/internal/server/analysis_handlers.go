@@ -0,0 +1,240 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/analysis"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tablebase"
+)
+
+const maxMultiPV = 10
+
+type AnalysisRequest struct {
+	FEN        string `json:"fen"`
+	Depth      int    `json:"depth"`
+	MoveTimeMS int    `json:"movetime_ms"`
+	Nodes      int64  `json:"nodes"`
+	MultiPV    int    `json:"multipv"`
+	Refresh    bool   `json:"refresh"`
+}
+
+func (r *AnalysisRequest) limits() engine.Limits {
+	multiPV := r.MultiPV
+	if multiPV > maxMultiPV {
+		multiPV = maxMultiPV
+	}
+	return engine.Limits{
+		Depth:    r.Depth,
+		MoveTime: time.Duration(r.MoveTimeMS) * time.Millisecond,
+		Nodes:    r.Nodes,
+		MultiPV:  multiPV,
+	}
+}
+
+type AnnotateRequest struct {
+	AnalysisRequest
+	Thresholds   *models.AnnotationThresholds `json:"thresholds"`
+	AnnotatedPGN bool                         `json:"annotated_pgn"`
+}
+
+type PlyAnalysis struct {
+	Ply      int              `json:"ply"`
+	FEN      string           `json:"fen"`
+	Analysis *engine.Analysis `json:"analysis"`
+}
+
+type AnalysisHandler struct {
+	db       *database.DB
+	pool     *engine.Pool
+	tb       tablebase.Prober
+	analyzer *analysis.Analyzer
+}
+
+func NewAnalysisHandler(db *database.DB, pool *engine.Pool, tb tablebase.Prober) *AnalysisHandler {
+	return &AnalysisHandler{db: db, pool: pool, tb: tb, analyzer: analysis.New(db, pool, tb)}
+}
+
+func (ah *AnalysisHandler) AnalyzePosition(c *gin.Context) {
+	var req AnalysisRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := chess.FEN(req.FEN); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid FEN: " + err.Error()})
+		return
+	}
+
+	result, err := ah.analyzer.AnalyzePosition(c.Request.Context(), req.FEN, req.limits(), req.Refresh)
+	if err != nil {
+		analysisError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ah *AnalysisHandler) AnalyzeGame(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	var req AnalysisRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	game, err := ah.db.GetGame(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if game == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return
+	}
+
+	positions, err := ah.db.GetGamePositions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	plies := []PlyAnalysis{{Ply: 0, FEN: chess.StartingPosition().String()}}
+	for _, pos := range positions {
+		plies = append(plies, PlyAnalysis{Ply: pos.MoveNumber, FEN: pos.FEN})
+	}
+
+	fens := make([]string, len(plies))
+	for i, ply := range plies {
+		fens[i] = ply.FEN
+	}
+
+	startTime := time.Now()
+	results, err := ah.analyzer.AnalyzePositions(c.Request.Context(), fens, req.limits(), req.Refresh)
+	if err != nil {
+		analysisError(c, err)
+		return
+	}
+
+	for i := range plies {
+		plies[i].Analysis = results[i]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"game_id":         id,
+		"plies":           plies,
+		"processing_time": time.Since(startTime).Seconds(),
+	})
+}
+
+func (ah *AnalysisHandler) AnnotateGame(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	var req AnnotateRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	thresholds := analysis.DefaultThresholds
+	if req.Thresholds != nil {
+		thresholds = *req.Thresholds
+	}
+	if thresholds.Inaccuracy > thresholds.Mistake || thresholds.Mistake > thresholds.Blunder {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Thresholds must increase from inaccuracy to mistake to blunder"})
+		return
+	}
+
+	game, err := ah.db.GetGame(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if game == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return
+	}
+
+	result, err := ah.analyzer.AnnotateGame(c.Request.Context(), game, req.limits(), thresholds, req.Refresh, req.AnnotatedPGN)
+	if err != nil {
+		analysisError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ah *AnalysisHandler) GetGameAnnotations(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	result, err := ah.db.GetGameAnalysis(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game has not been annotated"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ah *AnalysisHandler) ProbeTablebase(c *gin.Context) {
+	if ah.tb == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No tablebases configured"})
+		return
+	}
+
+	fen := c.Query("fen")
+	if _, err := chess.FEN(fen); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid FEN: " + err.Error()})
+		return
+	}
+
+	result, err := ah.tb.Probe(fen)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, result)
+	case tablebase.ErrTooManyPieces, tablebase.ErrCastling, tablebase.ErrMissingTable:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// analysisError responds to a failed analysis. Positions with a stored
+// evaluation are served without an engine, so a missing engine is only
+// reported when one was needed.
+func analysisError(c *gin.Context, err error) {
+	if errors.Is(err, analysis.ErrNoEngine) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No engine configured"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -4,247 +4,451 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/charset"
 	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
 	"github.com/chdb/chessdb/internal/parser"
 )
 
-type ProgressResponse struct {
-	JobID          string    `json:"job_id"`
-	Status         string    `json:"status"`
-	TotalProcessed uint64    `json:"total_processed"`
-	Imported       uint64    `json:"imported"`
-	Failed         uint64    `json:"failed"`
-	CurrentGame    string    `json:"current_game,omitempty"`
-	StartTime      time.Time `json:"start_time"`
-	LastUpdate     time.Time `json:"last_update"`
-}
-
 type BatchHandler struct {
 	db       *database.DB
 	parser   *parser.ConcurrentParser
 	importer *database.BatchImporter
-	jobs     map[string]*ImportJob
-}
-
-type ImportJob struct {
-	ID           string
-	Status       string
-	Progress     *ProgressResponse
-	Context      context.Context
-	CancelFunc   context.CancelFunc
-	ProgressChan chan database.ImportProgress
+	jobs     *jobStore
 }
 
+// NewBatchHandler returns a handler of background imports and resumes the
+// jobs that were running when the server last stopped.
 func NewBatchHandler(db *database.DB) *BatchHandler {
-	return &BatchHandler{
+	bh := &BatchHandler{
 		db:       db,
 		parser:   parser.NewConcurrentParser(8),
 		importer: database.NewBatchImporter(db, 50, 4),
-		jobs:     make(map[string]*ImportJob),
+		jobs:     newJobStore(db),
 	}
+	bh.resumeJobs()
+	return bh
 }
 
+// ImportLargeFile starts a background import of one or more files, each sent
+// as a "file" part. Files that cannot be read are reported in the job and the
+// others are imported.
 func (bh *BatchHandler) ImportLargeFile(c *gin.Context) {
-	file, header, err := c.Request.FormFile("file")
+	form, err := c.MultipartForm()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file: " + err.Error()})
 		return
 	}
+	headers := form.File["file"]
+	if len(headers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file: no file part"})
+		return
+	}
+
+	now := time.Now()
+	job := &models.ImportJob{
+		ID:         generateJobID(),
+		Kind:       "file",
+		Status:     jobRunning,
+		StartTime:  now,
+		LastUpdate: now,
+	}
+
+	contents := make([]string, len(headers))
+	var failures []string
+	readable := false
+	for i, header := range headers {
+		var file models.ImportJobFile
+		var errors []string
+		contents[i], file, errors = readImportFile(header, c.Query("encoding"))
+		if file.Error == "" {
+			readable = true
+		}
+		job.TotalGames += file.Games + file.Skipped
+		job.Files = append(job.Files, file)
+		failures = append(failures, errors...)
+	}
+	job.Failed = uint64(len(failures))
+	job.TotalProcessed = job.Failed
+	if !readable {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file could be read", "files": job.Files})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := bh.jobs.add(job, contents, failures, cancel); err != nil {
+		cancel()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	go bh.runImport(ctx, job.ID, contents, nil, nil)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":      job.ID,
+		"files":       job.Files,
+		"total_games": job.TotalGames,
+		"status":      "started",
+		"message":     "Import started. Use GET /api/v1/games/import/progress/" + job.ID + " to check progress",
+	})
+}
+
+// readImportFile reads an uploaded file as PGN. Games exported by Lichess or
+// chess.com are converted to PGN up front and imported like any PGN file;
+// the errors of those that cannot be converted are returned.
+func readImportFile(header *multipart.FileHeader, encoding string) (string, models.ImportJobFile, []string) {
+	result := models.ImportJobFile{Filename: header.Filename}
+
+	file, err := header.Open()
+	if err != nil {
+		result.Error = "Failed to get file: " + err.Error()
+		return "", result, nil
+	}
 	defer file.Close()
 
 	content, err := io.ReadAll(file)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file: " + err.Error()})
-		return
+		result.Error = "Failed to read file: " + err.Error()
+		return "", result, nil
 	}
 
-	jobID := generateJobID()
-	ctx, cancel := context.WithCancel(context.Background())
-	progressChan := make(chan database.ImportProgress, 100)
+	text, encoding, err := charset.Decode(content, encoding)
+	if err != nil {
+		result.Error = err.Error()
+		return "", result, nil
+	}
+	result.Encoding = encoding
 
-	job := &ImportJob{
-		ID:           jobID,
-		Status:       "running",
-		Context:      ctx,
-		CancelFunc:   cancel,
-		ProgressChan: progressChan,
-		Progress: &ProgressResponse{
-			JobID:     jobID,
-			Status:    "running",
-			StartTime: time.Now(),
-		},
+	var failures []error
+	format := parser.DetectFormat(header.Header.Get("Content-Type"), header.Filename)
+	text, result.Format, failures, err = importPGN(format, []byte(text))
+	if err != nil {
+		result.Error = "Failed to parse games: " + err.Error()
+		return "", result, nil
 	}
 
-	bh.jobs[jobID] = job
+	result.Games = len(splitPGN(text))
+	result.Skipped = len(failures)
+	return text, result, failureMessages(header.Filename+": ", failures)
+}
 
-	go bh.processLargeImport(ctx, string(content), progressChan, job)
+// failureMessages returns the messages of the games that could not be
+// converted to PGN, each after prefix.
+func failureMessages(prefix string, failures []error) []string {
+	var messages []string
+	for _, err := range failures {
+		messages = append(messages, prefix+err.Error())
+	}
+	return messages
+}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"job_id":   jobID,
-		"filename": header.Filename,
-		"status":   "started",
-		"message":  "Import started. Use GET /api/v1/games/import/progress/" + jobID + " to check progress",
-	})
+// splitPGN splits PGN text into the text of each game.
+func splitPGN(text string) []string {
+	var pgns []string
+	for _, pgn := range parser.SplitGames(text) {
+		if strings.TrimSpace(pgn) != "" {
+			pgns = append(pgns, pgn)
+		}
+	}
+	return pgns
 }
 
-func (bh *BatchHandler) processLargeImport(ctx context.Context, pgnContent string, progressChan chan database.ImportProgress, job *ImportJob) {
-	defer func() {
-		job.Status = "completed"
-		job.Progress.Status = "completed"
-		job.Progress.LastUpdate = time.Now()
+// runImport imports the games of a job's files, numbered across the files in
+// order, skipping those in done. The job's progress is kept in the job store
+// and, if updates is not nil, sent on it until the job ends and it is closed.
+func (bh *BatchHandler) runImport(ctx context.Context, jobID string, contents []string, done map[int]bool, updates chan<- models.ImportJob) {
+	if updates != nil {
+		defer close(updates)
+	}
+
+	// The games already imported count as consumed input, so the estimate
+	// only covers what is left.
+	var pending []parser.ParseJob
+	var sizes []int
+	var totalBytes, doneBytes int64
+	for _, content := range contents {
+		for _, pgn := range splitPGN(content) {
+			index := len(sizes)
+			sizes = append(sizes, len(pgn))
+			totalBytes += int64(len(pgn))
+			if done[index] {
+				doneBytes += int64(len(pgn))
+			} else {
+				pending = append(pending, parser.ParseJob{PGN: pgn, Index: index})
+			}
+		}
+	}
+
+	base := bh.jobs.update(jobID, func(job *models.ImportJob) {
+		job.TotalBytes = totalBytes
+		job.BytesProcessed = doneBytes
+	})
+	started := time.Now()
+
+	parseJobs := make(chan parser.ParseJob, 100)
+	go func() {
+		defer close(parseJobs)
+		for _, job := range pending {
+			select {
+			case parseJobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
-	pgnTexts := strings.Split(pgnContent, "\n\n\n")
-	
-	pgnChannel := make(chan string, 100)
-	
+	results := bh.parser.StreamParseIndexed(parseJobs)
+
+	// Games that fail to parse are passed on with their error, so that the
+	// importer counts them. Once the import is cancelled the remaining
+	// results are drained so that the parser can finish.
+	games := make(chan database.IndexedGame, 100)
 	go func() {
-		defer close(pgnChannel)
-		for _, pgn := range pgnTexts {
-			if strings.TrimSpace(pgn) != "" {
-				select {
-				case pgnChannel <- pgn:
-				case <-ctx.Done():
-					return
-				}
+		defer close(games)
+		for result := range results {
+			select {
+			case games <- database.IndexedGame{Index: result.Index, Size: sizes[result.Index], Game: result.Game, Err: result.Error}:
+			case <-ctx.Done():
 			}
 		}
 	}()
 
-	gameChannel := bh.parser.StreamParsePGN(pgnChannel)
+	progressChan := make(chan database.ImportProgress, 100)
+	type outcome struct {
+		result *models.ImportResult
+		err    error
+	}
+	finished := make(chan outcome, 1)
+	go func() {
+		result, err := bh.importer.ImportJobGames(ctx, jobID, games, progressChan)
+		finished <- outcome{result, err}
+	}()
+
+	record := func(progress database.ImportProgress) models.ImportJob {
+		return bh.jobs.update(jobID, func(job *models.ImportJob) {
+			job.Imported = base.Imported + progress.Imported
+			job.Failed = base.Failed + progress.Failed
+			job.TotalProcessed = job.Imported + job.Failed
+			job.BytesProcessed = base.BytesProcessed + int64(progress.BytesProcessed)
+			job.CurrentGame = progress.CurrentGame
+
+			job.GamesPerSecond, job.ETASeconds = 0, 0
+			if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+				job.GamesPerSecond = float64(progress.TotalProcessed) / elapsed
+				if rate := float64(progress.BytesProcessed) / elapsed; rate > 0 {
+					job.ETASeconds = float64(job.TotalBytes-job.BytesProcessed) / rate
+				}
+			}
+		})
+	}
+
+	for progress := range progressChan {
+		job := record(progress)
+		if updates != nil {
+			select {
+			case updates <- job:
+			case <-ctx.Done():
+			}
+		}
+	}
 
-	if err := bh.importer.ImportWithChannels(ctx, gameChannel, progressChan); err != nil {
-		job.Status = "failed"
-		job.Progress.Status = "failed"
+	out := <-finished
+	switch {
+	case ctx.Err() != nil:
+		bh.jobs.finish(jobID, jobCancelled, "", out.result.Errors)
+	case out.err != nil:
+		bh.jobs.finish(jobID, jobFailed, out.err.Error(), out.result.Errors)
+	default:
+		bh.jobs.finish(jobID, jobCompleted, "", out.result.Errors)
 	}
+}
+
+// resumeJobs restarts the jobs that were running when the server last
+// stopped, skipping the games they already imported. Jobs whose input is no
+// longer kept are marked interrupted.
+func (bh *BatchHandler) resumeJobs() {
+	jobs, err := bh.db.RunningImportJobs()
+	if err != nil {
+		log.Printf("Failed to load import jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		contents, ok, err := bh.db.ImportJobContents(job.ID)
+		var done map[int]bool
+		if err == nil && ok {
+			done, err = bh.db.ImportedGameIndexes(job.ID)
+		}
+		if err != nil {
+			log.Printf("Failed to load import job %s: %v", job.ID, err)
+			continue
+		}
+
+		now := time.Now()
+		job.LastUpdate = now
+		if !ok {
+			job.Status = jobInterrupted
+			job.Error = "the server stopped during the import and its input is not kept"
+			job.FinishedAt = &now
+			if err := bh.db.FinishImportJob(job); err != nil {
+				log.Printf("Failed to save import job %s: %v", job.ID, err)
+			}
+			continue
+		}
+
+		// Games that failed are retried, so only the stored ones and those
+		// that could not be converted to PGN count.
+		errors, err := bh.db.ImportJobErrors(job.ID)
+		if err != nil {
+			log.Printf("Failed to load import job %s: %v", job.ID, err)
+			continue
+		}
+		job.Resumed++
+		job.Imported = uint64(len(done))
+		job.Failed = 0
+		for _, file := range job.Files {
+			job.Failed += uint64(file.Skipped)
+		}
+		job.TotalProcessed = job.Imported + job.Failed
 
-	imported, failed := bh.importer.GetStats()
-	job.Progress.Imported = imported
-	job.Progress.Failed = failed
-	job.Progress.TotalProcessed = imported + failed
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := bh.jobs.resume(job, errors, cancel); err != nil {
+			cancel()
+			log.Printf("Failed to resume import job %s: %v", job.ID, err)
+			continue
+		}
+
+		log.Printf("Resuming import job %s, %d of %d games already imported", job.ID, job.Imported, job.TotalGames)
+		go bh.runImport(ctx, job.ID, contents, done, nil)
+	}
 }
 
 func (bh *BatchHandler) GetImportProgress(c *gin.Context) {
-	jobID := c.Param("jobId")
-	
-	job, exists := bh.jobs[jobID]
-	if !exists {
+	job, err := bh.jobs.get(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if job == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 		return
 	}
 
-	select {
-	case progress := <-job.ProgressChan:
-		job.Progress.TotalProcessed = progress.TotalProcessed
-		job.Progress.Imported = progress.Imported
-		job.Progress.Failed = progress.Failed
-		job.Progress.CurrentGame = progress.CurrentGame
-		job.Progress.LastUpdate = progress.Timestamp
-	default:
+	c.JSON(http.StatusOK, job)
+}
+
+// ListImportJobs returns the most recent import jobs, running or finished.
+func (bh *BatchHandler) ListImportJobs(c *gin.Context) {
+	limit := 50
+	if val := c.Query("limit"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	jobs, err := bh.jobs.list(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, job.Progress)
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  jobs,
+		"count": len(jobs),
+	})
 }
 
 func (bh *BatchHandler) CancelImport(c *gin.Context) {
-	jobID := c.Param("jobId")
-	
-	job, exists := bh.jobs[jobID]
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	job, err := bh.jobs.cancel(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	if job.Status == "running" {
-		job.CancelFunc()
-		job.Status = "cancelled"
-		job.Progress.Status = "cancelled"
-		job.Progress.LastUpdate = time.Now()
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"job_id": jobID,
-		"status": "cancelled",
+		"job_id": job.ID,
+		"status": job.Status,
 	})
 }
 
+// StreamImport imports PGN sent in the request and streams the job's progress
+// as server-sent events. The job is recorded like any other, but its PGN is
+// not kept, so it cannot be resumed after a restart.
 func (bh *BatchHandler) StreamImport(c *gin.Context) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 
-	jobID := generateJobID()
 	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
 
-	var req struct {
-		PGN string `json:"pgn" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
+	body, err := c.GetRawData()
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	pgnTexts := strings.Split(req.PGN, "\n\n\n")
-	progressChan := make(chan database.ImportProgress, 10)
+	format := parser.FormatJSON
+	if parser.DetectFormat(c.ContentType(), "") == parser.FormatNDJSON {
+		format = parser.FormatNDJSON
+	}
+	text, format, failures, err := importPGN(format, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse games: " + err.Error()})
+		return
+	}
+	file := models.ImportJobFile{Format: format, Games: len(splitPGN(text)), Skipped: len(failures)}
+	if file.Games == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No games to import", "skipped": file.Skipped, "errors": failureMessages("", failures)})
+		return
+	}
 
-	pgnChannel := make(chan string, 50)
-	go func() {
-		defer close(pgnChannel)
-		for _, pgn := range pgnTexts {
-			if strings.TrimSpace(pgn) != "" {
-				select {
-				case pgnChannel <- pgn:
-				case <-ctx.Done():
-					return
-				}
-			}
-		}
-	}()
+	now := time.Now()
+	job := &models.ImportJob{
+		ID:             generateJobID(),
+		Kind:           "stream",
+		Status:         jobRunning,
+		TotalGames:     file.Games + file.Skipped,
+		TotalProcessed: uint64(file.Skipped),
+		Failed:         uint64(file.Skipped),
+		Files:          []models.ImportJobFile{file},
+		StartTime:      now,
+		LastUpdate:     now,
+	}
 
-	gameChannel := bh.parser.StreamParsePGN(pgnChannel)
+	if err := bh.jobs.add(job, nil, failureMessages("", failures), cancel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	go func() {
-		bh.importer.ImportWithChannels(ctx, gameChannel, progressChan)
-		close(progressChan)
-	}()
+	updates := make(chan models.ImportJob, 10)
+	go bh.runImport(ctx, job.ID, []string{text}, nil, updates)
 
 	c.Stream(func(w io.Writer) bool {
 		select {
-		case progress, ok := <-progressChan:
+		case progress, ok := <-updates:
 			if !ok {
-				imported, failed := bh.importer.GetStats()
-				finalProgress := map[string]interface{}{
-					"job_id":          jobID,
-					"status":          "completed",
-					"total_processed": imported + failed,
-					"imported":        imported,
-					"failed":          failed,
-					"timestamp":       time.Now(),
+				if final, err := bh.jobs.get(job.ID); err == nil && final != nil {
+					data, _ := json.Marshal(final)
+					c.SSEvent("progress", string(data))
 				}
-				data, _ := json.Marshal(finalProgress)
-				c.SSEvent("progress", string(data))
 				return false
 			}
 
-			progressData := map[string]interface{}{
-				"job_id":          jobID,
-				"status":          "running",
-				"total_processed": progress.TotalProcessed,
-				"imported":        progress.Imported,
-				"failed":          progress.Failed,
-				"current_game":    progress.CurrentGame,
-				"timestamp":       progress.Timestamp,
-			}
-			data, _ := json.Marshal(progressData)
+			data, _ := json.Marshal(progress)
 			c.SSEvent("progress", string(data))
 			return true
 
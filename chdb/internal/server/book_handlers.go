@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/polyglot"
+)
+
+type BookHandler struct {
+	db *database.DB
+}
+
+func NewBookHandler(db *database.DB) *BookHandler {
+	return &BookHandler{db: db}
+}
+
+// ExportBook builds a Polyglot book from the games matching the search
+// filters. min_elo also limits the book to the moves of players rated at
+// least that much, and min_games, max_ply and weight (count or score) choose
+// which moves go in and how they are weighted.
+func (bh *BookHandler) ExportBook(c *gin.Context) {
+	params, ok := searchParams(c, 0)
+	if !ok {
+		return
+	}
+
+	opts := polyglot.DefaultOptions()
+	opts.MinElo = params.MinElo
+	opts.Weight = c.DefaultQuery("weight", opts.Weight)
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"min_games", &opts.MinGames},
+		{"max_ply", &opts.MaxPly},
+	} {
+		if val := c.Query(param.name); val != "" {
+			parsed, err := strconv.Atoi(val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param.name + ": " + val})
+				return
+			}
+			*param.value = parsed
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	builder := polyglot.NewBuilder(opts)
+	err := bh.db.EachGameMoves(params, opts.MaxPly, func(game *models.Game, moves []string) error {
+		builder.AddGame(game.Result, game.WhiteElo, game.BlackElo, moves)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The book is small next to the games it comes from, so it is built
+	// before responding and a failure can still be reported.
+	var buf bytes.Buffer
+	if err := polyglot.WriteBook(&buf, builder.Entries()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Built opening book of %d entries from %d games", buf.Len()/polyglot.EntrySize, builder.Games())
+	c.Header("Content-Disposition", `attachment; filename="book.bin"`)
+	c.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+}
+
+// ImportBook stores a Polyglot .bin book uploaded as file, named after the
+// name form field or the file.
+func (bh *BookHandler) ImportBook(c *gin.Context) {
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	entries, err := polyglot.ReadBook(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read book: " + err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Book contains no entries"})
+		return
+	}
+
+	name := c.PostForm("name")
+	if name == "" {
+		name = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	}
+
+	book := &models.OpeningBook{Name: name}
+	if err := bh.db.SaveBook(book, entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if book, err = bh.db.GetBook(book.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, book)
+}
+
+func (bh *BookHandler) ListBooks(c *gin.Context) {
+	books, err := bh.db.ListBooks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"books": books,
+		"count": len(books),
+	})
+}
+
+func (bh *BookHandler) DeleteBook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	if err := bh.db.DeleteBook(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Book deleted successfully"})
+}
+
+// ComparePosition lists the moves of a position, given by fen and the
+// starting position by default, with their weight in the book next to how
+// often and how well they were played in the database games.
+func (bh *BookHandler) ComparePosition(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	book, err := bh.db.GetBook(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if book == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+
+	fenStr := c.DefaultQuery("fen", chess.StartingPosition().String())
+	fen, err := chess.FEN(fenStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid FEN: " + err.Error()})
+		return
+	}
+	pos := chess.NewGame(fen).Position()
+	key := polyglot.Hash(pos)
+
+	entries, err := bh.db.BookEntries(id, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := bh.db.PositionMoveStats(fenStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	comparison := &models.BookComparison{
+		BookID: id,
+		FEN:    pos.String(),
+		Key:    fmt.Sprintf("%016x", key),
+		Moves:  []models.BookMove{},
+	}
+
+	totalWeight := 0
+	for _, e := range entries {
+		totalWeight += int(e.Weight)
+	}
+	for _, s := range stats {
+		comparison.Games += s.Games
+	}
+
+	byMove := make(map[string]int)
+	for _, e := range entries {
+		m := polyglot.DecodeMove(pos, e.Move)
+		if m == nil {
+			continue
+		}
+		move := models.BookMove{
+			UCI:    m.String(),
+			SAN:    strings.TrimRight(chess.AlgebraicNotation{}.Encode(pos, m), "+#"),
+			InBook: true,
+			Weight: int(e.Weight),
+		}
+		if totalWeight > 0 {
+			move.BookPercent = 100 * float64(e.Weight) / float64(totalWeight)
+		}
+		byMove[move.UCI] = len(comparison.Moves)
+		comparison.Moves = append(comparison.Moves, move)
+	}
+
+	for _, s := range stats {
+		i, ok := byMove[s.UCI]
+		if !ok {
+			i = len(comparison.Moves)
+			comparison.Moves = append(comparison.Moves, models.BookMove{UCI: s.UCI, SAN: s.SAN})
+		}
+		move := &comparison.Moves[i]
+		move.Games = s.Games
+		move.PlayedPercent = 100 * float64(s.Games) / float64(comparison.Games)
+		move.WhiteWins = s.WhiteWins
+		move.Draws = s.Draws
+		move.BlackWins = s.BlackWins
+		move.Score = s.Score
+	}
+
+	sort.SliceStable(comparison.Moves, func(i, j int) bool {
+		if comparison.Moves[i].Weight != comparison.Moves[j].Weight {
+			return comparison.Moves[i].Weight > comparison.Moves[j].Weight
+		}
+		return comparison.Moves[i].Games > comparison.Moves[j].Games
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"book":       book,
+		"comparison": comparison,
+	})
+}
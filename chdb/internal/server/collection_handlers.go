@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/epd"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+type CollectionHandler struct {
+	db *database.DB
+}
+
+func NewCollectionHandler(db *database.DB) *CollectionHandler {
+	return &CollectionHandler{db: db}
+}
+
+// CreateCollection imports a position collection from EPD or a list of FENs,
+// one position per line. Lines that cannot be read are reported and skipped.
+func (ch *CollectionHandler) CreateCollection(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+		EPD  string `json:"epd" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	positions, errs := epd.Parse(req.EPD)
+	var skipped []string
+	for _, err := range errs {
+		skipped = append(skipped, err.Error())
+	}
+	if len(positions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "EPD contains no positions", "errors": skipped})
+		return
+	}
+
+	col := &models.PositionCollection{
+		Name:      req.Name,
+		Positions: positions,
+	}
+	if err := ch.db.SaveCollection(col); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Read the collection back for the game counts of its positions.
+	col, err := ch.db.GetCollection(col.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"collection": col,
+		"errors":     skipped,
+	})
+}
+
+func (ch *CollectionHandler) ListCollections(c *gin.Context) {
+	collections, err := ch.db.ListCollections()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"collections": collections,
+		"count":       len(collections),
+	})
+}
+
+// GetCollection returns a collection's positions with their opcodes and the
+// number of database games that reached each of them.
+func (ch *CollectionHandler) GetCollection(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid collection ID"})
+		return
+	}
+
+	col, err := ch.db.GetCollection(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if col == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, col)
+}
+
+func (ch *CollectionHandler) DeleteCollection(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid collection ID"})
+		return
+	}
+
+	if err := ch.db.DeleteCollection(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Collection deleted successfully"})
+}
+
+// GetPositionGames returns the database games that reached a collection
+// position, narrowed by any of the game search filters.
+func (ch *CollectionHandler) GetPositionGames(c *gin.Context) {
+	collectionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid collection ID"})
+		return
+	}
+	positionID, err := strconv.ParseInt(c.Param("positionId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid position ID"})
+		return
+	}
+
+	pos, err := ch.db.GetCollectionPosition(collectionID, positionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if pos == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
+		return
+	}
+
+	params, ok := searchParams(c, 100)
+	if !ok {
+		return
+	}
+	params.Position = pos.FEN
+
+	games, err := ch.db.SearchGames(params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"position": pos,
+		"games":    games,
+		"count":    len(games),
+	})
+}
+
+// SearchPositions finds collection positions by FEN, matched like the game
+// position search, and by opcode, such as opcode=id&operand=WAC.001.
+func (ch *CollectionHandler) SearchPositions(c *gin.Context) {
+	fen := c.Query("position")
+	op := models.EPDOpcode{
+		Opcode:  c.Query("opcode"),
+		Operand: c.Query("operand"),
+	}
+	if fen == "" && op.Opcode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position or opcode is required"})
+		return
+	}
+
+	limit := 100
+	if val := c.Query("limit"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	positions, err := ch.db.SearchCollectionPositions(fen, op, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"positions": positions,
+		"count":     len(positions),
+	})
+}
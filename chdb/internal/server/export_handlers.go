@@ -0,0 +1,90 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tabular"
+)
+
+// ExportGames streams the games matching the search filters. Unlike a
+// search, an export has no default limit, so without filters it covers the
+// whole database.
+func (h *Handler) ExportGames(c *gin.Context) {
+	format := c.DefaultQuery("format", "pgn")
+	if format != "pgn" && !tabular.IsFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported export format: " + format})
+		return
+	}
+
+	params, ok := searchParams(c, 0)
+	if !ok {
+		return
+	}
+
+	if format != "pgn" {
+		h.exportTable(c, format, params)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-chess-pgn")
+	c.Header("Content-Disposition", `attachment; filename="games.pgn"`)
+	c.Status(http.StatusOK)
+
+	// Once streaming has started the status can no longer change, so a
+	// failure can only cut the export short.
+	count, err := h.db.ExportPGN(c.Writer, params)
+	if err != nil {
+		log.Printf("PGN export stopped after %d games: %v", count, err)
+	}
+}
+
+// exportTable streams the games, or with rows=plies their moves, as a table
+// of the columns listed in columns, all of them by default.
+func (h *Handler) exportTable(c *gin.Context, format string, params *models.SearchParams) {
+	rows := c.DefaultQuery("rows", tabular.RowsGames)
+	var names []string
+	if val := c.Query("columns"); val != "" {
+		names = strings.Split(val, ",")
+	}
+
+	columns, err := tabular.SelectColumns(rows, names)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", tabular.ContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="`+rows+"."+format+`"`)
+	c.Status(http.StatusOK)
+
+	w, err := tabular.NewWriter(format, c.Writer, columns)
+	if err != nil {
+		log.Printf("Table export failed: %v", err)
+		return
+	}
+
+	count, err := h.db.ExportTable(w, params, rows == tabular.RowsPlies)
+	if err != nil {
+		log.Printf("Table export stopped after %d rows: %v", count, err)
+	}
+}
+
+// GetExportSchema lists the columns of a table export, for rows=games or
+// rows=plies.
+func (h *Handler) GetExportSchema(c *gin.Context) {
+	rows := c.DefaultQuery("rows", tabular.RowsGames)
+	columns, err := tabular.SelectColumns(rows, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rows":    rows,
+		"columns": columns,
+	})
+}
@@ -5,48 +5,69 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/analysis"
+	"github.com/chdb/chessdb/internal/charset"
 	"github.com/chdb/chessdb/internal/database"
 	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/motif"
 	"github.com/chdb/chessdb/internal/parser"
 	"github.com/chdb/chessdb/internal/search"
+	"github.com/chdb/chessdb/internal/timecontrol"
 )
 
 type Handler struct {
-	db      *database.DB
-	parser  *parser.PGNParser
-	matcher *search.PatternMatcher
+	db       *database.DB
+	parser   *parser.PGNParser
+	matcher  *search.PatternMatcher
+	sequence *search.SequenceMatcher
+	similar  *search.SimilarityMatcher
 }
 
 func NewHandler(db *database.DB) *Handler {
 	return &Handler{
-		db:      db,
-		parser:  parser.New(),
-		matcher: search.NewPatternMatcher(db),
+		db:       db,
+		parser:   parser.New(),
+		matcher:  search.NewPatternMatcher(db),
+		sequence: search.NewSequenceMatcher(db),
+		similar:  search.NewSimilarityMatcher(db),
 	}
 }
 
+// ImportGames imports PGN sent as {"pgn": "..."}, or games exported by
+// Lichess or chess.com: JSON, or NDJSON with an application/x-ndjson body.
 func (h *Handler) ImportGames(c *gin.Context) {
-	var req struct {
-		PGN string `json:"pgn" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
+	body, err := c.GetRawData()
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	format := parser.FormatJSON
+	if parser.DetectFormat(c.ContentType(), "") == parser.FormatNDJSON {
+		format = parser.FormatNDJSON
+	}
+
 	startTime := time.Now()
-	games, err := h.parser.ParsePGN(req.PGN)
+	text, _, failures, err := importPGN(format, body)
+	var games []*models.Game
+	if err == nil {
+		games, err = h.parser.ParsePGN(text)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse PGN: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse games: " + err.Error()})
 		return
 	}
 
 	result := &models.ImportResult{
-		TotalGames: len(games),
+		TotalGames:  len(games) + len(failures),
+		FailedGames: len(failures),
+	}
+	for _, err := range failures {
+		result.Errors = append(result.Errors, err.Error())
 	}
 
 	for _, game := range games {
@@ -89,15 +110,31 @@ func (h *Handler) ImportFile(c *gin.Context) {
 		return
 	}
 
+	text, encoding, err := charset.Decode(content, c.Query("encoding"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := parser.DetectFormat(header.Header.Get("Content-Type"), header.Filename)
+
 	startTime := time.Now()
-	games, err := h.parser.ParsePGN(string(content))
+	text, _, failures, err := importPGN(format, []byte(text))
+	var games []*models.Game
+	if err == nil {
+		games, err = h.parser.ParsePGN(text)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse PGN: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse games: " + err.Error()})
 		return
 	}
 
 	result := &models.ImportResult{
-		TotalGames: len(games),
+		TotalGames:  len(games) + len(failures),
+		FailedGames: len(failures),
+	}
+	for _, err := range failures {
+		result.Errors = append(result.Errors, err.Error())
 	}
 
 	for _, game := range games {
@@ -120,25 +157,153 @@ func (h *Handler) ImportFile(c *gin.Context) {
 	result.ProcessingTime = time.Since(startTime).Seconds()
 	c.JSON(http.StatusOK, gin.H{
 		"filename": header.Filename,
+		"format":   format,
+		"encoding": encoding,
 		"result":   result,
 	})
 }
 
+// importPGN converts an import in the given format to PGN text and returns
+// the format it was read as. JSON holding a {"pgn": "..."} request is PGN;
+// other JSON and NDJSON are games exported by Lichess or chess.com, and those
+// that cannot be imported are returned as failures.
+func importPGN(format string, content []byte) (string, string, []error, error) {
+	if format == parser.FormatJSON {
+		if pgn, ok := parser.PGNRequest(content); ok {
+			format, content = parser.FormatPGN, []byte(pgn)
+		}
+	}
+	if format == parser.FormatPGN {
+		return charset.Normalize(string(content)), format, nil, nil
+	}
+
+	pgns, failures, err := parser.PlatformGames(format, content)
+	if err != nil {
+		return "", format, nil, err
+	}
+	return charset.Normalize(strings.Join(pgns, "\n\n")), format, failures, nil
+}
+
 func (h *Handler) SearchGames(c *gin.Context) {
+	params, ok := searchParams(c, 100)
+	if !ok {
+		return
+	}
+
+	params.IncludeMoves = c.Query("include_moves") == "true"
+
+	if params.Position == "" {
+		games, err := h.db.SearchGames(params)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"games": games,
+			"count": len(games),
+		})
+		return
+	}
+
+	// A position is also looked up in the position collections.
+	transforms := search.Transformations(params.FlipColors, params.Mirror)
+	games, err := search.SearchTransformed(transforms, params.Limit, func(transform string, limit int) ([]*models.Game, error) {
+		return h.db.SearchByPosition(search.TransformFEN(params.Position, transform), limit)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	positions, err := search.SearchCollectionsTransformed(transforms, params.Limit, func(transform string, limit int) ([]*models.CollectionPosition, error) {
+		return h.db.SearchCollectionPositions(search.TransformFEN(params.Position, transform), models.EPDOpcode{}, limit)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"games":                games,
+		"count":                len(games),
+		"collection_positions": positions,
+	})
+}
+
+// searchParams reads the game search filters from the query string. It
+// writes a 400 response and returns false when a filter is invalid.
+func searchParams(c *gin.Context, limit int) (*models.SearchParams, bool) {
 	params := &models.SearchParams{
-		Limit:  100,
+		Limit:  limit,
 		Offset: 0,
 	}
 
-	params.White = c.Query("white")
-	params.Black = c.Query("black")
-	params.Either = c.Query("either")
+	if ids := c.Query("ids"); ids != "" {
+		for _, field := range strings.Split(ids, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID: " + field})
+				return nil, false
+			}
+			params.IDs = append(params.IDs, id)
+		}
+	}
+
+	// Tag filters are given as tag=Name:Value, or tag=Name for games that
+	// have the tag with any value.
+	for _, filter := range c.QueryArray("tag") {
+		name, value, _ := strings.Cut(filter, ":")
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag filter: " + filter})
+			return nil, false
+		}
+		params.Tags = append(params.Tags, models.Tag{Name: name, Value: charset.Normalize(value)})
+	}
+
+	// Names are compared in NFC, the form imported games are stored in.
+	params.White = charset.Normalize(c.Query("white"))
+	params.Black = charset.Normalize(c.Query("black"))
+	params.Either = charset.Normalize(c.Query("either"))
 	params.ECO = c.Query("eco")
-	params.Opening = c.Query("opening")
+	params.ComputedECO = c.Query("computed_eco")
+	params.TimeClass = c.Query("time_class")
+	params.Opening = charset.Normalize(c.Query("opening"))
 	params.Result = c.Query("result")
 	params.DateFrom = c.Query("date_from")
 	params.DateTo = c.Query("date_to")
 	params.Position = c.Query("position")
+	params.FlipColors = c.Query("flip_colors") == "true"
+	params.Mirror = c.Query("mirror") == "true"
+	params.Motif = c.Query("motif")
+	params.MotifPiece = c.Query("motif_piece")
+	params.MotifTargets = c.Query("motif_targets")
+	params.MotifWon = c.Query("motif_won") == "true"
+
+	params.Annotation = c.Query("annotation")
+	params.AnnotationPlayer = c.Query("annotation_player")
+	params.AnnotationPhase = c.Query("annotation_phase")
+	params.TablebaseChanged = c.Query("tablebase_changed") == "true"
+
+	if params.Motif != "" && !motif.IsMotif(params.Motif) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown motif: " + params.Motif})
+		return nil, false
+	}
+
+	if params.TimeClass != "" && !timecontrol.IsClass(params.TimeClass) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time class: " + params.TimeClass})
+		return nil, false
+	}
+
+	if params.Annotation != "" && !analysis.IsClassification(params.Annotation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown annotation: " + params.Annotation})
+		return nil, false
+	}
+
+	if params.AnnotationPhase != "" && !analysis.IsPhase(params.AnnotationPhase) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown game phase: " + params.AnnotationPhase})
+		return nil, false
+	}
 
 	if minElo := c.Query("min_elo"); minElo != "" {
 		if val, err := strconv.Atoi(minElo); err == nil {
@@ -164,51 +329,108 @@ func (h *Handler) SearchGames(c *gin.Context) {
 		}
 	}
 
-	params.IncludeMoves = c.Query("include_moves") == "true"
+	return params, true
+}
 
-	var games []*models.Game
-	var err error
+func (h *Handler) SearchByPattern(c *gin.Context) {
+	var pattern models.Pattern
+	if err := c.ShouldBindJSON(&pattern); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if params.Position != "" {
-		games, err = h.db.SearchByPosition(params.Position, params.Limit)
-	} else {
-		games, err = h.db.SearchGames(params)
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil {
+			limit = val
+		}
+	}
+
+	transforms := search.Transformations(c.Query("flip_colors") == "true", c.Query("mirror") == "true")
+	games, err := search.SearchTransformed(transforms, limit, func(transform string, limit int) ([]*models.Game, error) {
+		return h.matcher.SearchByPattern(search.TransformPattern(&pattern, transform), limit)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
+	positions, err := search.SearchCollectionsTransformed(transforms, limit, func(transform string, limit int) ([]*models.CollectionPosition, error) {
+		return h.matcher.SearchCollectionsByPattern(search.TransformPattern(&pattern, transform), limit)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"games": games,
-		"count": len(games),
+		"games":                games,
+		"count":                len(games),
+		"collection_positions": positions,
 	})
 }
 
-func (h *Handler) SearchByPattern(c *gin.Context) {
-	var pattern models.Pattern
-	if err := c.ShouldBindJSON(&pattern); err != nil {
+func (h *Handler) SearchByMoves(c *gin.Context) {
+	var query models.MoveSequenceQuery
+	if err := c.ShouldBindJSON(&query); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	limit := 100
 	if l := c.Query("limit"); l != "" {
 		if val, err := strconv.Atoi(l); err == nil {
-			limit = val
+			query.Limit = val
+		}
+	}
+
+	matches, err := h.sequence.SearchByMoves(&query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches": matches,
+		"count":   len(matches),
+	})
+}
+
+func (h *Handler) SearchSimilar(c *gin.Context) {
+	var query models.SimilarPositionQuery
+	if err := c.ShouldBindJSON(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if l := c.Query("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil {
+			query.Limit = val
 		}
 	}
 
-	games, err := h.matcher.SearchByPattern(&pattern, limit)
+	if err := search.ValidateSimilarQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	positions, truncated, err := h.similar.SearchSimilar(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	collectionPositions, collectionsTruncated, err := h.similar.SearchSimilarCollections(&query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"games": games,
-		"count": len(games),
+		"positions":             positions,
+		"count":                 len(positions),
+		"truncated":             truncated,
+		"collection_positions":  collectionPositions,
+		"collections_truncated": collectionsTruncated,
 	})
 }
 
@@ -230,9 +452,97 @@ func (h *Handler) GetGame(c *gin.Context) {
 		return
 	}
 
+	game.Evaluations, err = h.db.GetGameEvaluations(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	game.Comments, err = h.db.GetGameComments(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	game.Tags, err = h.db.GetGameTags(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, game)
 }
 
+// GetGamePlies returns the positions of a game in order for replay, or the
+// single position after the ply given by ?ply=N.
+func (h *Handler) GetGamePlies(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	game, err := h.db.GetGame(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if game == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+		return
+	}
+
+	plies, err := h.db.GetGamePlies(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if val := c.Query("ply"); val != "" {
+		ply, err := strconv.Atoi(val)
+		if err != nil || ply < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ply"})
+			return
+		}
+		for _, p := range plies {
+			if p.Ply == ply {
+				c.JSON(http.StatusOK, p)
+				return
+			}
+		}
+
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Game has only %d plies", plies[len(plies)-1].Ply)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"game_id": id,
+		"result":  game.Result,
+		"plies":   plies,
+		"count":   len(plies) - 1,
+	})
+}
+
+func (h *Handler) GetGameMotifs(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid game ID"})
+		return
+	}
+
+	motifs, err := h.db.GetGameMotifs(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"game_id": id,
+		"motifs":  motifs,
+		"count":   len(motifs),
+	})
+}
+
 func (h *Handler) DeleteGame(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -258,6 +568,38 @@ func (h *Handler) GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+func (h *Handler) GetTimeTroubleStats(c *gin.Context) {
+	player := c.Query("player")
+	if player == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "player is required"})
+		return
+	}
+
+	threshold := 60
+	if val := c.Query("threshold"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+			return
+		}
+		threshold = parsed
+	}
+
+	timeClass := c.Query("time_class")
+	if timeClass != "" && !timecontrol.IsClass(timeClass) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time class: " + timeClass})
+		return
+	}
+
+	stats, err := h.db.GetTimeTroubleStats(player, threshold, timeClass)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
 func (h *Handler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "healthy",
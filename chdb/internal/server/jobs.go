@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// Import job statuses. A job is interrupted when the server stopped while it
+// was running and it cannot be resumed.
+const (
+	jobRunning     = "running"
+	jobCompleted   = "completed"
+	jobFailed      = "failed"
+	jobCancelled   = "cancelled"
+	jobInterrupted = "interrupted"
+)
+
+// The progress of a running job is saved to the database at most this often.
+const jobSaveInterval = time.Second
+
+// errors of a running job are those of the games that could not be converted
+// to PGN, which come first in its result.
+type runningJob struct {
+	job    models.ImportJob
+	errors []string
+	cancel context.CancelFunc
+	saved  time.Time
+}
+
+// jobStore keeps the running import jobs, which are updated by their import
+// goroutines and read by requests. Every job is also kept in the database,
+// where it remains once finished.
+type jobStore struct {
+	mu   sync.Mutex
+	db   *database.DB
+	jobs map[string]*runningJob
+}
+
+func newJobStore(db *database.DB) *jobStore {
+	return &jobStore{
+		db:   db,
+		jobs: make(map[string]*runningJob),
+	}
+}
+
+// add stores a new running job with the PGN of its files and the errors of
+// the games that could not be converted to PGN.
+func (s *jobStore) add(job *models.ImportJob, contents []string, errors []string, cancel context.CancelFunc) error {
+	if err := s.db.CreateImportJob(job, contents, errors); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = &runningJob{job: *job, errors: errors, cancel: cancel, saved: time.Now()}
+	return nil
+}
+
+// resume stores a job that was running when the server last stopped, with
+// the errors stored when it was added.
+func (s *jobStore) resume(job *models.ImportJob, errors []string, cancel context.CancelFunc) error {
+	if err := s.db.UpdateImportJob(job); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = &runningJob{job: *job, errors: errors, cancel: cancel, saved: time.Now()}
+	return nil
+}
+
+// update applies fn to a running job and returns the updated job. The job is
+// saved when its status changed or its last save is old enough.
+func (s *jobStore) update(id string, fn func(*models.ImportJob)) models.ImportJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rj, ok := s.jobs[id]
+	if !ok {
+		return models.ImportJob{}
+	}
+
+	status := rj.job.Status
+	fn(&rj.job)
+	rj.job.LastUpdate = time.Now()
+	if rj.job.Status != status || rj.job.LastUpdate.Sub(rj.saved) >= jobSaveInterval {
+		s.save(rj)
+	}
+
+	return rj.job
+}
+
+func (s *jobStore) save(rj *runningJob) {
+	rj.saved = time.Now()
+	if err := s.db.UpdateImportJob(&rj.job); err != nil {
+		log.Printf("Failed to save import job %s: %v", rj.job.ID, err)
+	}
+}
+
+// finish ends a running job with the given status, unless it was cancelled,
+// sets its result with the errors of its games after those found when it was
+// added and removes it from the running jobs.
+func (s *jobStore) finish(id, status, errMsg string, errors []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rj, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	delete(s.jobs, id)
+
+	now := time.Now()
+	if rj.job.Status != jobCancelled {
+		rj.job.Status = status
+	}
+	rj.job.Error = errMsg
+	rj.job.CurrentGame = ""
+	rj.job.ETASeconds = 0
+	rj.job.LastUpdate = now
+	rj.job.FinishedAt = &now
+	rj.job.Result = &models.ImportResult{
+		TotalGames:     rj.job.TotalGames,
+		ImportedGames:  int(rj.job.Imported),
+		FailedGames:    int(rj.job.Failed),
+		Errors:         append(rj.errors, errors...),
+		ProcessingTime: now.Sub(rj.job.StartTime).Seconds(),
+	}
+
+	if err := s.db.FinishImportJob(&rj.job); err != nil {
+		log.Printf("Failed to save import job %s: %v", id, err)
+	}
+}
+
+// cancel stops a running job and returns it, or the finished job as it is.
+// It returns nil if the job does not exist.
+func (s *jobStore) cancel(id string) (*models.ImportJob, error) {
+	s.mu.Lock()
+	if rj, ok := s.jobs[id]; ok {
+		defer s.mu.Unlock()
+		rj.cancel()
+		rj.job.Status = jobCancelled
+		rj.job.LastUpdate = time.Now()
+		s.save(rj)
+		job := rj.job
+		return &job, nil
+	}
+	s.mu.Unlock()
+
+	return s.db.GetImportJob(id)
+}
+
+// get returns a job, or nil if it does not exist.
+func (s *jobStore) get(id string) (*models.ImportJob, error) {
+	s.mu.Lock()
+	if rj, ok := s.jobs[id]; ok {
+		job := rj.job
+		s.mu.Unlock()
+		return &job, nil
+	}
+	s.mu.Unlock()
+
+	return s.db.GetImportJob(id)
+}
+
+// list returns the most recent jobs, newest first, with the current progress
+// of those that are running.
+func (s *jobStore) list(limit int) ([]*models.ImportJob, error) {
+	jobs, err := s.db.ListImportJobs(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, job := range jobs {
+		if rj, ok := s.jobs[job.ID]; ok {
+			running := rj.job
+			jobs[i] = &running
+		}
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/analysis"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/tablebase"
+)
+
+type ExtractPuzzlesRequest struct {
+	Depth      int     `json:"depth"`
+	MoveTimeMS int     `json:"movetime_ms"`
+	Nodes      int64   `json:"nodes"`
+	GameIDs    []int64 `json:"game_ids"`
+	Player     string  `json:"player"`
+}
+
+type PuzzleJobProgress struct {
+	JobID          string    `json:"job_id"`
+	Status         string    `json:"status"`
+	TotalGames     int       `json:"total_games"`
+	GamesProcessed int       `json:"games_processed"`
+	PuzzlesFound   int       `json:"puzzles_found"`
+	Error          string    `json:"error,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	LastUpdate     time.Time `json:"last_update"`
+}
+
+type puzzleJob struct {
+	progress   PuzzleJobProgress
+	cancelFunc context.CancelFunc
+}
+
+type PuzzleHandler struct {
+	db       *database.DB
+	analyzer *analysis.Analyzer
+
+	mu   sync.Mutex
+	jobs map[string]*puzzleJob
+}
+
+func NewPuzzleHandler(db *database.DB, pool *engine.Pool, tb tablebase.Prober) *PuzzleHandler {
+	return &PuzzleHandler{
+		db:       db,
+		analyzer: analysis.New(db, pool, tb),
+		jobs:     make(map[string]*puzzleJob),
+	}
+}
+
+func (ph *PuzzleHandler) StartExtraction(c *gin.Context) {
+	var req ExtractPuzzlesRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	gameIDs := req.GameIDs
+	if len(gameIDs) == 0 {
+		ids, err := ph.db.GameIDs(req.Player)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		gameIDs = ids
+	}
+
+	limits := engine.Limits{
+		Depth:    req.Depth,
+		MoveTime: time.Duration(req.MoveTimeMS) * time.Millisecond,
+		Nodes:    req.Nodes,
+	}
+
+	jobID := generateJobID()
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+
+	job := &puzzleJob{
+		progress: PuzzleJobProgress{
+			JobID:      jobID,
+			Status:     "running",
+			TotalGames: len(gameIDs),
+			StartTime:  now,
+			LastUpdate: now,
+		},
+		cancelFunc: cancel,
+	}
+
+	ph.mu.Lock()
+	ph.jobs[jobID] = job
+	ph.mu.Unlock()
+
+	go ph.extract(ctx, job, gameIDs, limits)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id":      jobID,
+		"total_games": len(gameIDs),
+		"status":      "started",
+		"message":     "Extraction started. Use GET /api/v1/puzzles/extract/" + jobID + " to check progress",
+	})
+}
+
+func (ph *PuzzleHandler) extract(ctx context.Context, job *puzzleJob, gameIDs []int64, limits engine.Limits) {
+	defer job.cancelFunc()
+
+	for _, id := range gameIDs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		puzzles, err := ph.analyzer.ExtractPuzzles(ctx, id, limits)
+
+		ph.mu.Lock()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Puzzle extraction failed for game %d: %v", id, err)
+			job.progress.Error = err.Error()
+		}
+		job.progress.GamesProcessed++
+		job.progress.PuzzlesFound += len(puzzles)
+		job.progress.LastUpdate = time.Now()
+		ph.mu.Unlock()
+	}
+
+	ph.mu.Lock()
+	if job.progress.Status == "running" {
+		job.progress.Status = "completed"
+	}
+	ph.mu.Unlock()
+}
+
+func (ph *PuzzleHandler) GetExtractionProgress(c *gin.Context) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	job, exists := ph.jobs[c.Param("jobId")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job.progress)
+}
+
+func (ph *PuzzleHandler) CancelExtraction(c *gin.Context) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	jobID := c.Param("jobId")
+	job, exists := ph.jobs[jobID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if job.progress.Status == "running" {
+		job.cancelFunc()
+		job.progress.Status = "cancelled"
+		job.progress.LastUpdate = time.Now()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id": jobID,
+		"status": job.progress.Status,
+	})
+}
+
+func (ph *PuzzleHandler) SearchPuzzles(c *gin.Context) {
+	query := &models.PuzzleQuery{
+		Theme:  c.Query("theme"),
+		Player: c.Query("player"),
+		Limit:  50,
+	}
+
+	if minRating := c.Query("min_rating"); minRating != "" {
+		if val, err := strconv.Atoi(minRating); err == nil {
+			query.MinRating = val
+		}
+	}
+
+	if maxRating := c.Query("max_rating"); maxRating != "" {
+		if val, err := strconv.Atoi(maxRating); err == nil {
+			query.MaxRating = val
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if val, err := strconv.Atoi(limit); err == nil {
+			query.Limit = val
+		}
+	}
+
+	if offset := c.Query("offset"); offset != "" {
+		if val, err := strconv.Atoi(offset); err == nil {
+			query.Offset = val
+		}
+	}
+
+	puzzles, err := ph.db.SearchPuzzles(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"puzzles": puzzles,
+		"count":   len(puzzles),
+		"limit":   query.Limit,
+		"offset":  query.Offset,
+	})
+}
+
+func (ph *PuzzleHandler) GetPuzzle(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid puzzle ID"})
+		return
+	}
+
+	puzzle, err := ph.db.GetPuzzle(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if puzzle == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Puzzle not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, puzzle)
+}
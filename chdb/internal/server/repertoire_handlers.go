@@ -0,0 +1,180 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/repertoire"
+	"github.com/chdb/chessdb/internal/timecontrol"
+)
+
+type RepertoireHandler struct {
+	db *database.DB
+}
+
+func NewRepertoireHandler(db *database.DB) *RepertoireHandler {
+	return &RepertoireHandler{db: db}
+}
+
+func (rh *RepertoireHandler) CreateRepertoire(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name" binding:"required"`
+		Color string `json:"color" binding:"required"`
+		PGN   string `json:"pgn" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	color := parseColor(req.Color)
+	if color == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "color must be white or black"})
+		return
+	}
+
+	moves, warnings, err := repertoire.ParsePGN(req.PGN)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse PGN: " + err.Error()})
+		return
+	}
+	if len(moves) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PGN contains no moves", "warnings": warnings})
+		return
+	}
+
+	rep := &models.Repertoire{
+		Name:      req.Name,
+		Color:     color,
+		Moves:     moves,
+		MoveCount: repertoire.Count(moves),
+		Warnings:  warnings,
+	}
+	if err := rh.db.SaveRepertoire(rep); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rep)
+}
+
+func (rh *RepertoireHandler) ListRepertoires(c *gin.Context) {
+	repertoires, err := rh.db.ListRepertoires()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repertoires": repertoires,
+		"count":       len(repertoires),
+	})
+}
+
+func (rh *RepertoireHandler) GetRepertoire(c *gin.Context) {
+	rep, ok := rh.repertoire(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, rep)
+}
+
+func (rh *RepertoireHandler) DeleteRepertoire(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repertoire ID"})
+		return
+	}
+
+	if err := rh.db.DeleteRepertoire(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Repertoire deleted successfully"})
+}
+
+// CheckRepertoire follows the player's games with the repertoire's color
+// through the repertoire.
+func (rh *RepertoireHandler) CheckRepertoire(c *gin.Context) {
+	player := c.Query("player")
+	if player == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "player is required"})
+		return
+	}
+
+	timeClass := c.Query("time_class")
+	if timeClass != "" && !timecontrol.IsClass(timeClass) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time class: " + timeClass})
+		return
+	}
+
+	rep, ok := rh.repertoire(c)
+	if !ok {
+		return
+	}
+
+	params := &models.SearchParams{
+		TimeClass: timeClass,
+		DateFrom:  c.Query("date_from"),
+		DateTo:    c.Query("date_to"),
+	}
+	if rep.Color == "w" {
+		params.White = player
+	} else {
+		params.Black = player
+	}
+
+	games, err := rh.db.SearchGames(params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	checker := repertoire.NewChecker(rep, player)
+	for _, game := range games {
+		moves, err := rh.db.GetGameMoves(game.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		checker.Add(game, moves)
+	}
+
+	c.JSON(http.StatusOK, checker.Report())
+}
+
+func (rh *RepertoireHandler) repertoire(c *gin.Context) (*models.Repertoire, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repertoire ID"})
+		return nil, false
+	}
+
+	rep, err := rh.db.GetRepertoire(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if rep == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repertoire not found"})
+		return nil, false
+	}
+
+	return rep, true
+}
+
+func parseColor(color string) string {
+	switch color {
+	case "w", "white", "White":
+		return "w"
+	case "b", "black", "Black":
+		return "b"
+	}
+	return ""
+}
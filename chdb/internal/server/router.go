@@ -3,12 +3,20 @@ package server
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/engine"
+	"github.com/chdb/chessdb/internal/tablebase"
 )
 
-func SetupRouter(db *database.DB) *gin.Engine {
+func SetupRouter(db *database.DB, pool *engine.Pool, tb tablebase.Prober) *gin.Engine {
 	router := gin.Default()
 	handler := NewHandler(db)
 	batchHandler := NewBatchHandler(db)
+	analysisHandler := NewAnalysisHandler(db, pool, tb)
+	puzzleHandler := NewPuzzleHandler(db, pool, tb)
+	repertoireHandler := NewRepertoireHandler(db)
+	trainingHandler := NewTrainingHandler(db)
+	collectionHandler := NewCollectionHandler(db)
+	bookHandler := NewBookHandler(db)
 
 	router.Use(gin.Recovery())
 	router.Use(corsMiddleware())
@@ -17,6 +25,7 @@ func SetupRouter(db *database.DB) *gin.Engine {
 	{
 		api.GET("/health", handler.HealthCheck)
 		api.GET("/stats", handler.GetStats)
+		api.GET("/stats/time-trouble", handler.GetTimeTroubleStats)
 
 		games := api.Group("/games")
 		{
@@ -25,12 +34,70 @@ func SetupRouter(db *database.DB) *gin.Engine {
 			games.POST("/import/large", batchHandler.ImportLargeFile)
 			games.POST("/import/stream", batchHandler.StreamImport)
 			games.GET("/import/progress/:jobId", batchHandler.GetImportProgress)
+			games.GET("/import/jobs", batchHandler.ListImportJobs)
 			games.DELETE("/import/cancel/:jobId", batchHandler.CancelImport)
 			games.GET("/search", handler.SearchGames)
+			games.GET("/export", handler.ExportGames)
+			games.GET("/export/schema", handler.GetExportSchema)
 			games.POST("/search/pattern", handler.SearchByPattern)
+			games.POST("/search/moves", handler.SearchByMoves)
+			games.POST("/search/similar", handler.SearchSimilar)
 			games.GET("/:id", handler.GetGame)
+			games.GET("/:id/plies", handler.GetGamePlies)
+			games.GET("/:id/motifs", handler.GetGameMotifs)
+			games.POST("/:id/analysis", analysisHandler.AnalyzeGame)
+			games.POST("/:id/annotate", analysisHandler.AnnotateGame)
+			games.GET("/:id/annotations", analysisHandler.GetGameAnnotations)
 			games.DELETE("/:id", handler.DeleteGame)
 		}
+
+		api.POST("/analysis/position", analysisHandler.AnalyzePosition)
+		api.GET("/tablebase", analysisHandler.ProbeTablebase)
+
+		puzzles := api.Group("/puzzles")
+		{
+			puzzles.GET("", puzzleHandler.SearchPuzzles)
+			puzzles.POST("/extract", puzzleHandler.StartExtraction)
+			puzzles.GET("/extract/:jobId", puzzleHandler.GetExtractionProgress)
+			puzzles.DELETE("/extract/:jobId", puzzleHandler.CancelExtraction)
+			puzzles.GET("/:id", puzzleHandler.GetPuzzle)
+		}
+
+		repertoires := api.Group("/repertoires")
+		{
+			repertoires.POST("", repertoireHandler.CreateRepertoire)
+			repertoires.GET("", repertoireHandler.ListRepertoires)
+			repertoires.GET("/:id", repertoireHandler.GetRepertoire)
+			repertoires.GET("/:id/check", repertoireHandler.CheckRepertoire)
+			repertoires.DELETE("/:id", repertoireHandler.DeleteRepertoire)
+		}
+
+		training := api.Group("/training/:user")
+		{
+			training.POST("/cards", trainingHandler.AddCards)
+			training.GET("/due", trainingHandler.GetDueCards)
+			training.POST("/cards/:id/answer", trainingHandler.SubmitAnswer)
+			training.GET("/history", trainingHandler.GetHistory)
+		}
+
+		collections := api.Group("/collections")
+		{
+			collections.POST("", collectionHandler.CreateCollection)
+			collections.GET("", collectionHandler.ListCollections)
+			collections.GET("/positions", collectionHandler.SearchPositions)
+			collections.GET("/:id", collectionHandler.GetCollection)
+			collections.GET("/:id/positions/:positionId/games", collectionHandler.GetPositionGames)
+			collections.DELETE("/:id", collectionHandler.DeleteCollection)
+		}
+
+		books := api.Group("/books")
+		{
+			books.POST("", bookHandler.ImportBook)
+			books.GET("", bookHandler.ListBooks)
+			books.GET("/export", bookHandler.ExportBook)
+			books.GET("/:id/position", bookHandler.ComparePosition)
+			books.DELETE("/:id", bookHandler.DeleteBook)
+		}
 	}
 
 	return router
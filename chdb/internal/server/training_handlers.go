@@ -0,0 +1,299 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/chdb/chessdb/internal/database"
+	"github.com/chdb/chessdb/internal/models"
+	"github.com/chdb/chessdb/internal/training"
+)
+
+type TrainingHandler struct {
+	db *database.DB
+}
+
+func NewTrainingHandler(db *database.DB) *TrainingHandler {
+	return &TrainingHandler{db: db}
+}
+
+type AddCardsRequest struct {
+	RepertoireID int64   `json:"repertoire_id"`
+	PuzzleIDs    []int64 `json:"puzzle_ids"`
+	PuzzleTheme  string  `json:"puzzle_theme"`
+	MinRating    int     `json:"min_rating"`
+	MaxRating    int     `json:"max_rating"`
+	Limit        int     `json:"limit"`
+}
+
+type AnswerRequest struct {
+	Move    string `json:"move" binding:"required"`
+	Quality *int   `json:"quality"`
+}
+
+// AddCards adds the positions of a repertoire where the user is to move, or a
+// selection of puzzles, to the user's training cards.
+func (th *TrainingHandler) AddCards(c *gin.Context) {
+	user := c.Param("user")
+
+	var req AddCardsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rep *models.Repertoire
+	if req.RepertoireID > 0 {
+		var err error
+		rep, err = th.db.GetRepertoire(req.RepertoireID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if rep == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repertoire not found"})
+			return
+		}
+	}
+
+	var puzzles []*models.Puzzle
+	for _, id := range req.PuzzleIDs {
+		puzzle, err := th.db.GetPuzzle(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if puzzle == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Puzzle not found: " + strconv.FormatInt(id, 10)})
+			return
+		}
+		puzzles = append(puzzles, puzzle)
+	}
+
+	if req.PuzzleTheme != "" || req.MinRating > 0 || req.MaxRating > 0 {
+		limit := req.Limit
+		if limit <= 0 {
+			limit = 50
+		}
+		found, err := th.db.SearchPuzzles(&models.PuzzleQuery{
+			Theme:     req.PuzzleTheme,
+			MinRating: req.MinRating,
+			MaxRating: req.MaxRating,
+			Limit:     limit,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		puzzles = append(puzzles, found...)
+	}
+
+	added := 0
+	if rep != nil {
+		n, err := th.db.AddTrainingCards(user, training.SourceRepertoire, rep.ID, training.RepertoirePositions(rep), training.InitialEase)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		added += n
+	}
+
+	for _, puzzle := range puzzles {
+		n, err := th.db.AddTrainingCards(user, training.SourcePuzzle, puzzle.ID, []string{puzzle.FEN}, training.InitialEase)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		added += n
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"added": added,
+	})
+}
+
+func (th *TrainingHandler) GetDueCards(c *gin.Context) {
+	user := c.Param("user")
+
+	limit := 20
+	if val := c.Query("limit"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	now := time.Now()
+	cards, err := th.db.DueTrainingCards(user, now, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total, due, err := th.db.CountTrainingCards(user, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"cards": cards,
+		"due":   due,
+		"total": total,
+	})
+}
+
+// SubmitAnswer grades a move played in a card's position, reschedules the
+// card and returns the correct line.
+func (th *TrainingHandler) SubmitAnswer(c *gin.Context) {
+	user := c.Param("user")
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card ID"})
+		return
+	}
+
+	var req AnswerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quality != nil && (*req.Quality < 0 || *req.Quality > training.MaxQuality) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quality must be between 0 and 5"})
+		return
+	}
+
+	card, err := th.db.GetTrainingCard(user, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if card == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card not found"})
+		return
+	}
+
+	move, err := training.ResolveMove(card.FEN, req.Move)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := th.grade(card, move)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if result == nil {
+		// The card can never be answered again, so it is dropped rather than
+		// left due.
+		if err := th.db.DeleteTrainingCard(user, card.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "The card's " + card.Source + " no longer exists"})
+		return
+	}
+
+	quality := training.DefaultQuality(result.Correct)
+	if req.Quality != nil {
+		quality = *req.Quality
+	}
+
+	now := time.Now().UTC()
+	training.Schedule(card, result.Correct, quality, now)
+
+	review := &models.TrainingReview{
+		CardID:     card.ID,
+		Move:       move,
+		Correct:    result.Correct,
+		Quality:    quality,
+		ReviewedAt: now,
+	}
+	if err := th.db.SaveTrainingReview(card, review); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result.Card = card
+	c.JSON(http.StatusOK, result)
+}
+
+// grade compares a move with the answers of the card's repertoire or puzzle.
+// It returns nil if the source has been deleted.
+func (th *TrainingHandler) grade(card *models.TrainingCard, move string) (*models.TrainingResult, error) {
+	result := &models.TrainingResult{Move: move}
+
+	switch card.Source {
+	case training.SourceRepertoire:
+		rep, err := th.db.GetRepertoire(card.SourceID)
+		if err != nil || rep == nil {
+			return nil, err
+		}
+
+		answers := training.RepertoireAnswers(rep, card.FEN)
+		if len(answers) == 0 {
+			return nil, nil
+		}
+
+		chosen := answers[0]
+		for _, answer := range answers {
+			result.Expected = append(result.Expected, answer.SAN)
+			if answer.UCI == move {
+				result.Correct = true
+				chosen = answer
+			}
+		}
+		result.Line = training.MainLine(chosen)
+		result.Comment = chosen.Comment
+
+	case training.SourcePuzzle:
+		puzzle, err := th.db.GetPuzzle(card.SourceID)
+		if err != nil || puzzle == nil {
+			return nil, err
+		}
+
+		result.Correct = len(puzzle.Solution) > 0 && puzzle.Solution[0] == move
+		if len(puzzle.SolutionSAN) > 0 {
+			result.Expected = puzzle.SolutionSAN[:1]
+		}
+		result.Line = puzzle.SolutionSAN
+
+	default:
+		return nil, nil
+	}
+
+	return result, nil
+}
+
+func (th *TrainingHandler) GetHistory(c *gin.Context) {
+	user := c.Param("user")
+
+	limit, offset := 50, 0
+	if val := c.Query("limit"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if val := c.Query("offset"); val != "" {
+		if parsed, err := strconv.Atoi(val); err == nil && parsed >= 0 {
+			offset = parsed
+		}
+	}
+
+	reviews, err := th.db.TrainingHistory(user, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":    user,
+		"reviews": reviews,
+		"count":   len(reviews),
+	})
+}
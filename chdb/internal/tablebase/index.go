@@ -0,0 +1,253 @@
+package tablebase
+
+// Squares are numbered a1 = 0, b1 = 1, ..., h8 = 63 and pieces are coded as
+// in the table files: pawn 1 to king 6 for white, plus 8 for black.
+const (
+	pawn = 1
+	king = 6
+
+	blackFlag = 8
+
+	// maxTablePieces is the largest number of pieces in a Syzygy table.
+	maxTablePieces = 7
+)
+
+// Lookup tables of the Syzygy position encoding, computed once in init.
+var (
+	binomial      [maxTablePieces][64]uint64 // [k][n]: ways to choose k of n squares
+	mapPawns      [64]int                    // a2-h7 to 0..47, edge files and low ranks last
+	mapB1H1H7     [64]int                    // squares below the a1-h8 diagonal to 0..27
+	mapA1D1D4     [64]int                    // the a1-d1-d4 triangle to 0..9, diagonal last
+	mapKK         [10][64]int                // the 462 placements of two kings
+	leadPawnIdx   [6][64]uint64              // [lead pawns][square of the leading pawn]
+	leadPawnsSize [6][4]uint64               // [lead pawns][file a..d]
+)
+
+func fileOf(sq int) int { return sq & 7 }
+func rankOf(sq int) int { return sq >> 3 }
+
+// offDiagonal is positive above the a1-h8 diagonal, negative below it and
+// zero on it.
+func offDiagonal(sq int) int { return rankOf(sq) - fileOf(sq) }
+
+func kingsAdjacent(a, b int) bool {
+	df, dr := fileOf(a)-fileOf(b), rankOf(a)-rankOf(b)
+	return df >= -1 && df <= 1 && dr >= -1 && dr <= 1
+}
+
+func init() {
+	code := 0
+	for sq := 0; sq < 64; sq++ {
+		if offDiagonal(sq) < 0 {
+			mapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	var diagonal []int
+	code = 0
+	for sq := 0; sq <= 27; sq++ {
+		if fileOf(sq) > 3 {
+			continue
+		}
+		if offDiagonal(sq) < 0 {
+			mapA1D1D4[sq] = code
+			code++
+		} else if offDiagonal(sq) == 0 {
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		mapA1D1D4[sq] = code
+		code++
+	}
+
+	// The first king is in the a1-d1-d4 triangle. When it is on the
+	// diagonal the second one is not above it, and placements with both
+	// kings on the diagonal come last.
+	type placement struct{ idx, sq int }
+	var bothOnDiagonal []placement
+	code = 0
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 <= 27; s1++ {
+			if fileOf(s1) > 3 || mapA1D1D4[s1] != idx || (idx == 0 && s1 != 1) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				switch {
+				case kingsAdjacent(s1, s2):
+				case offDiagonal(s1) == 0 && offDiagonal(s2) > 0:
+				case offDiagonal(s1) == 0 && offDiagonal(s2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, placement{idx, s2})
+				default:
+					mapKK[idx][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, p := range bothOnDiagonal {
+		mapKK[p.idx][p.sq] = code
+		code++
+	}
+
+	binomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < maxTablePieces && k <= n; k++ {
+			if k > 0 {
+				binomial[k][n] += binomial[k-1][n-1]
+			}
+			if k < n {
+				binomial[k][n] += binomial[k][n-1]
+			}
+		}
+	}
+
+	// The leading pawn is the one with the highest mapPawns value: the one
+	// nearest the edge and, on the same file, the one with the lowest rank.
+	available := 47
+	for leadPawns := 1; leadPawns <= 5; leadPawns++ {
+		for file := 0; file < 4; file++ {
+			var idx uint64
+			for rank := 1; rank <= 6; rank++ {
+				sq := rank*8 + file
+				if leadPawns == 1 {
+					mapPawns[sq] = available
+					available--
+					mapPawns[sq^7] = available
+					available--
+				}
+				leadPawnIdx[leadPawns][sq] = idx
+				idx += binomial[leadPawns-1][mapPawns[sq]]
+			}
+			leadPawnsSize[leadPawns][file] = idx
+		}
+	}
+}
+
+// encode computes the index of a position in the table part d. squares and
+// pieces list every piece on the board from the point of view of the
+// table's stronger side, with the lead pawns first when the table has pawns.
+func (t *table) encode(d *pairsData, squares, pieces []int, leadPawns int) uint64 {
+	size := len(squares)
+
+	// Order the pieces as the table stores them.
+	for i := leadPawns; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror so that the leading piece is on files a to d.
+	if fileOf(squares[0]) > 3 {
+		for i := range squares {
+			squares[i] ^= 7
+		}
+	}
+
+	var idx uint64
+	if t.hasPawns {
+		idx = leadPawnIdx[leadPawns][squares[0]]
+		sortBy(squares[1:leadPawns], func(sq int) int { return mapPawns[sq] })
+		for i := 1; i < leadPawns; i++ {
+			idx += binomial[i][mapPawns[squares[i]]]
+		}
+	} else {
+		// Mirror so that the leading piece is on ranks 1 to 4, then flip
+		// along the a1-h8 diagonal so that the first piece of the leading
+		// group that is off the diagonal is below it.
+		if rankOf(squares[0]) > 3 {
+			for i := range squares {
+				squares[i] ^= 56
+			}
+		}
+		for i := 0; i < d.groupLen[0]; i++ {
+			if offDiagonal(squares[i]) == 0 {
+				continue
+			}
+			if offDiagonal(squares[i]) > 0 {
+				for j := i; j < size; j++ {
+					squares[j] = ((squares[j] >> 3) | (squares[j] << 3)) & 63
+				}
+			}
+			break
+		}
+
+		if t.hasUniquePieces {
+			idx = encodeUniquePieces(squares)
+		} else {
+			idx = uint64(mapKK[mapA1D1D4[squares[0]]][squares[1]])
+		}
+	}
+
+	idx *= d.groupIdx[0]
+
+	// The remaining groups are encoded by their squares in ascending order,
+	// skipping the squares taken by the groups before them. The other side's
+	// pawns, when both sides have pawns, cannot be on the first rank.
+	remainingPawns := t.hasPawns && t.pawnCount[1] > 0
+	start := d.groupLen[0]
+	for next := 1; d.groupLen[next] > 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		sortBy(group, func(sq int) int { return sq })
+
+		var n uint64
+		for i, sq := range group {
+			adjust := 0
+			for _, taken := range squares[:start] {
+				if sq > taken {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += binomial[i+1][sq-adjust]
+		}
+
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+
+	return idx
+}
+
+// encodeUniquePieces encodes the leading group of three distinct pieces of a
+// table without pawns, the first of them in the a1-d1-d4 triangle.
+func encodeUniquePieces(squares []int) uint64 {
+	adjust1 := 0
+	if squares[1] > squares[0] {
+		adjust1 = 1
+	}
+	adjust2 := 0
+	if squares[2] > squares[0] {
+		adjust2++
+	}
+	if squares[2] > squares[1] {
+		adjust2++
+	}
+
+	switch {
+	case offDiagonal(squares[0]) != 0:
+		return uint64((mapA1D1D4[squares[0]]*63+squares[1]-adjust1)*62 + squares[2] - adjust2)
+	case offDiagonal(squares[1]) != 0:
+		return uint64((6*63+rankOf(squares[0])*28+mapB1H1H7[squares[1]])*62 + squares[2] - adjust2)
+	case offDiagonal(squares[2]) != 0:
+		return uint64(6*63*62 + 4*28*62 + rankOf(squares[0])*7*28 + (rankOf(squares[1])-adjust1)*28 + mapB1H1H7[squares[2]])
+	}
+	return uint64(6*63*62 + 4*28*62 + 4*7*28 + rankOf(squares[0])*7*6 + (rankOf(squares[1])-adjust1)*6 + rankOf(squares[2]) - adjust2)
+}
+
+// sortBy sorts squares by key, keeping the order of equal keys.
+func sortBy(squares []int, key func(int) int) {
+	for i := 1; i < len(squares); i++ {
+		for j := i; j > 0 && key(squares[j]) < key(squares[j-1]); j-- {
+			squares[j], squares[j-1] = squares[j-1], squares[j]
+		}
+	}
+}
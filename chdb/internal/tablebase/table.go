@@ -0,0 +1,561 @@
+package tablebase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Flags of a table part. All but singleValue only apply to DTZ tables.
+const (
+	flagSTM         = 1
+	flagMapped      = 2
+	flagWinPlies    = 4
+	flagLossPlies   = 8
+	flagWide        = 16
+	flagSingleValue = 128
+)
+
+var (
+	wdlMagic = [4]byte{0x71, 0xE8, 0x23, 0x5D}
+	dtzMagic = [4]byte{0xD7, 0x66, 0x0C, 0xA5}
+)
+
+// pairsData describes one compressed part of a table: one per side to move
+// and, for tables with pawns, per file a to d of the leading pawn. Offsets
+// are positions in the table file.
+type pairsData struct {
+	flags     byte
+	minSymLen int
+
+	blockSize       uint64
+	span            uint64
+	numBlocks       uint32
+	blockLengthSize uint32
+	sparseIndexSize uint64
+
+	sparseIndex int64
+	blockLength int64
+	data        int64
+
+	lowestSym []uint16
+	base64    []uint64
+	symlen    []uint8
+	btree     []byte // three bytes per symbol: left and right symbol of 12 bits
+
+	pieces   [maxTablePieces]int
+	groupIdx [maxTablePieces + 1]uint64
+	groupLen [maxTablePieces + 1]int
+	mapIdx   [4]int // DTZ value maps of win, loss, cursed win and blessed loss
+}
+
+// table is a WDL or DTZ file of one material signature, such as KRvK. Its
+// file is opened and its header read on first use.
+type table struct {
+	name            string
+	path            string
+	dtz             bool
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool
+	symmetric       bool
+	pawnCount       [2]int // lead color, other color
+
+	once   sync.Once
+	err    error
+	file   *os.File
+	parts  [2][4]pairsData // [side to move][file of the leading pawn]
+	dtzMap []byte
+}
+
+func newTable(name, path string, dtz bool) *table {
+	t := &table{name: name, path: path, dtz: dtz}
+
+	sides := strings.Split(name, "v")
+	t.pieceCount = len(sides[0]) + len(sides[1])
+	t.symmetric = sides[0] == sides[1]
+	t.hasPawns = strings.Contains(name, "P")
+
+	for _, side := range sides {
+		for _, piece := range "QRBNP" {
+			if strings.Count(side, string(piece)) == 1 {
+				t.hasUniquePieces = true
+			}
+		}
+	}
+
+	// The lead color is the side with fewer pawns, provided it has any,
+	// because this compresses better.
+	white, black := strings.Count(sides[0], "P"), strings.Count(sides[1], "P")
+	if black == 0 || (white > 0 && black >= white) {
+		t.pawnCount = [2]int{white, black}
+	} else {
+		t.pawnCount = [2]int{black, white}
+	}
+
+	return t
+}
+
+func (t *table) part(stm, file int) *pairsData {
+	if t.dtz {
+		stm = 0
+	}
+	if !t.hasPawns {
+		file = 0
+	}
+	return &t.parts[stm][file]
+}
+
+func (t *table) open() error {
+	t.once.Do(func() {
+		t.err = t.load()
+		if t.err != nil {
+			t.err = fmt.Errorf("%w: %s: %v", ErrCorruptTable, t.path, t.err)
+		}
+	})
+	return t.err
+}
+
+func (t *table) load() error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size()%64 != 16 {
+		file.Close()
+		return fmt.Errorf("unexpected file size %d", info.Size())
+	}
+
+	c := &cursor{r: file}
+	var magic [4]byte
+	copy(magic[:], c.bytes(4))
+	want := wdlMagic
+	if t.dtz {
+		want = dtzMagic
+	}
+	if c.err == nil && magic != want {
+		file.Close()
+		return fmt.Errorf("bad magic %x", magic)
+	}
+
+	if err := t.readHeader(c); err != nil {
+		file.Close()
+		return err
+	}
+
+	t.file = file
+	return nil
+}
+
+// readHeader reads the layout of the table parts, following the order in
+// which the Syzygy generator writes them.
+func (t *table) readHeader(c *cursor) error {
+	const (
+		split    = 1
+		hasPawns = 2
+	)
+
+	flags := c.byte()
+	if (flags&hasPawns != 0) != t.hasPawns || (flags&split != 0) == t.symmetric {
+		return fmt.Errorf("header flags %#x do not match the material", flags)
+	}
+
+	sides := 1
+	if !t.dtz && !t.symmetric {
+		sides = 2
+	}
+	files := 1
+	if t.hasPawns {
+		files = 4
+	}
+	bothPawns := t.hasPawns && t.pawnCount[1] > 0
+
+	for f := 0; f < files; f++ {
+		order := c.byte()
+		order2 := byte(0xFF)
+		if bothPawns {
+			order2 = c.byte()
+		}
+		orders := [2][2]int{
+			{int(order & 0xF), int(order2 & 0xF)},
+			{int(order >> 4), int(order2 >> 4)},
+		}
+
+		for k := 0; k < t.pieceCount; k++ {
+			b := c.byte()
+			for i := 0; i < sides; i++ {
+				piece := b & 0xF
+				if i == 1 {
+					piece = b >> 4
+				}
+				t.part(i, f).pieces[k] = int(piece)
+			}
+		}
+
+		for i := 0; i < sides; i++ {
+			t.setGroups(t.part(i, f), orders[i], f)
+		}
+	}
+	c.align(2)
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			t.setSizes(t.part(i, f), c)
+		}
+	}
+
+	if t.dtz {
+		t.setDTZMap(c, files)
+	}
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.part(i, f)
+			d.sparseIndex = c.off
+			c.off += int64(d.sparseIndexSize) * 6
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.part(i, f)
+			d.blockLength = c.off
+			c.off += int64(d.blockLengthSize) * 2
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := t.part(i, f)
+			c.align(64)
+			d.data = c.off
+			c.off += int64(d.numBlocks) * int64(d.blockSize)
+		}
+	}
+
+	return c.err
+}
+
+// setGroups splits the pieces of a table part into the groups that are
+// encoded together and computes the factor of each group in the index. A
+// group holds pieces of one kind, except the leading group: the lead pawns,
+// three distinct pieces, or the two kings when no other piece is unique.
+func (t *table) setGroups(d *pairsData, order [2]int, file int) {
+	n := 0
+	firstLen := 2
+	if t.hasPawns {
+		firstLen = 0
+	} else if t.hasUniquePieces {
+		firstLen = 3
+	}
+
+	d.groupLen[n] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	// The groups are multiplied in the order stored in the file: order[0]
+	// is the turn of the leading group and order[1] that of the other
+	// side's pawns when both sides have pawns.
+	bothPawns := t.hasPawns && t.pawnCount[1] > 0
+	next := 1
+	if bothPawns {
+		next = 2
+	}
+	freeSquares := 64 - d.groupLen[0]
+	if bothPawns {
+		freeSquares -= d.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch {
+		case k == order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= leadPawnsSize[d.groupLen[0]][file]
+			case t.hasUniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case k == order[1]:
+			d.groupIdx[1] = idx
+			idx *= binomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= binomial[d.groupLen[next]][freeSquares]
+			freeSquares -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+// setSizes reads the block layout and the Huffman code of a table part.
+func (t *table) setSizes(d *pairsData, c *cursor) {
+	d.flags = c.byte()
+	if d.flags&flagSingleValue != 0 {
+		d.minSymLen = int(c.byte())
+		return
+	}
+
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	tableSize := d.groupIdx[n]
+
+	d.blockSize = 1 << c.byte()
+	d.span = 1 << c.byte()
+	d.sparseIndexSize = (tableSize + d.span - 1) / d.span
+	padding := uint32(c.byte())
+	d.numBlocks = c.uint32()
+	d.blockLengthSize = d.numBlocks + padding
+
+	maxSymLen := int(c.byte())
+	d.minSymLen = int(c.byte())
+	if maxSymLen < d.minSymLen || maxSymLen > 32 {
+		c.fail(fmt.Errorf("symbol lengths %d..%d", d.minSymLen, maxSymLen))
+		return
+	}
+
+	// The canonical code gives longer symbols lower values, so base64[i] is
+	// the lowest code of length minSymLen+i padded to 64 bits, and a code
+	// of that length lies between base64[i] and base64[i-1].
+	count := maxSymLen - d.minSymLen + 1
+	d.lowestSym = make([]uint16, count)
+	for i := range d.lowestSym {
+		d.lowestSym[i] = c.uint16()
+	}
+	d.base64 = make([]uint64, count)
+	for i := count - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + uint64(d.lowestSym[i]) - uint64(d.lowestSym[i+1])) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= uint(64 - i - d.minSymLen)
+	}
+
+	// Symbols stand for pairs of symbols (recursive pairing); symlen is
+	// the number of values a symbol expands to, minus one.
+	symbols := int(c.uint16())
+	d.btree = c.bytes(symbols * 3)
+	if symbols&1 != 0 {
+		c.off++
+	}
+	if c.err != nil {
+		return
+	}
+
+	d.symlen = make([]uint8, symbols)
+	visited := make([]bool, symbols)
+	for sym := 0; sym < symbols; sym++ {
+		if !visited[sym] {
+			d.symlen[sym] = d.setSymlen(sym, visited)
+		}
+	}
+}
+
+func (d *pairsData) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xF)<<8 | int(d.btree[3*sym])
+}
+
+func (d *pairsData) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+func (d *pairsData) setSymlen(sym int, visited []bool) uint8 {
+	visited[sym] = true
+	right := d.right(sym)
+	if right == 0xFFF {
+		return 0
+	}
+	left := d.left(sym)
+	if left >= len(d.symlen) || right >= len(d.symlen) {
+		return 0
+	}
+
+	if !visited[left] {
+		d.symlen[left] = d.setSymlen(left, visited)
+	}
+	if !visited[right] {
+		d.symlen[right] = d.setSymlen(right, visited)
+	}
+	return d.symlen[left] + d.symlen[right] + 1
+}
+
+// setDTZMap reads the maps from stored DTZ values to distances, which are
+// kept for each of the four non-draw results of every part.
+func (t *table) setDTZMap(c *cursor, files int) {
+	start := c.off
+
+	for f := 0; f < files; f++ {
+		d := t.part(0, f)
+		if d.flags&flagMapped == 0 {
+			continue
+		}
+		if d.flags&flagWide != 0 {
+			c.align(2)
+			for i := 0; i < 4; i++ {
+				d.mapIdx[i] = int(c.off-start)/2 + 1
+				c.off += 2 * int64(c.uint16())
+			}
+		} else {
+			for i := 0; i < 4; i++ {
+				d.mapIdx[i] = int(c.off-start) + 1
+				c.off += int64(c.byte())
+			}
+		}
+	}
+	c.align(2)
+
+	end := c.off
+	c.off = start
+	t.dtzMap = c.bytes(int(end - start))
+	c.off = end
+}
+
+// value returns the value stored at idx in a table part.
+func (t *table) value(d *pairsData, idx uint64) (int, error) {
+	if d.flags&flagSingleValue != 0 {
+		return d.minSymLen, nil
+	}
+	if d.span == 0 || idx/d.span >= d.sparseIndexSize {
+		return 0, fmt.Errorf("%w: %s: index %d out of range", ErrCorruptTable, t.path, idx)
+	}
+
+	// The sparse index gives the block and offset of every span-th value,
+	// counted from the middle of the span. Blocks hold blockLength+1 values.
+	c := &cursor{r: t.file, off: d.sparseIndex + int64(idx/d.span)*6}
+	block := int64(c.uint32())
+	offset := int64(c.uint16())
+	offset += int64(idx%d.span) - int64(d.span/2)
+
+	blockLength := func(b int64) int64 {
+		c.off = d.blockLength + 2*b
+		return int64(c.uint16())
+	}
+	for offset < 0 && c.err == nil {
+		block--
+		offset += blockLength(block) + 1
+	}
+	for c.err == nil {
+		length := blockLength(block)
+		if offset <= length {
+			break
+		}
+		offset -= length + 1
+		block++
+	}
+
+	buf := make([]byte, d.blockSize+8)
+	c.off = d.data + block*int64(d.blockSize)
+	copy(buf, c.bytes(int(d.blockSize)))
+	if c.err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", ErrCorruptTable, t.path, c.err)
+	}
+
+	// Decode the Huffman symbols of the block until the one that covers
+	// the offset, then expand it down to the stored value.
+	buf64 := binary.BigEndian.Uint64(buf)
+	pos := 8
+	bits := 64
+	var sym int
+	for {
+		l := 0
+		for l < len(d.base64)-1 && buf64 < d.base64[l] {
+			l++
+		}
+		sym = int((buf64-d.base64[l])>>uint(64-l-d.minSymLen)) + int(d.lowestSym[l])
+		if sym >= len(d.symlen) {
+			return 0, fmt.Errorf("%w: %s: symbol %d out of range", ErrCorruptTable, t.path, sym)
+		}
+		if offset < int64(d.symlen[sym])+1 {
+			break
+		}
+		offset -= int64(d.symlen[sym]) + 1
+		l += d.minSymLen
+		buf64 <<= uint(l)
+		bits -= l
+		if bits <= 32 {
+			if pos+4 > len(buf) {
+				return 0, fmt.Errorf("%w: %s: block overrun", ErrCorruptTable, t.path)
+			}
+			bits += 32
+			buf64 |= uint64(binary.BigEndian.Uint32(buf[pos:])) << uint(64-bits)
+			pos += 4
+		}
+	}
+
+	for d.symlen[sym] != 0 {
+		left := d.left(sym)
+		if offset < int64(d.symlen[left])+1 {
+			sym = left
+		} else {
+			offset -= int64(d.symlen[left]) + 1
+			sym = d.right(sym)
+		}
+	}
+
+	return d.left(sym), nil
+}
+
+// cursor reads little-endian numbers from a file at a moving offset. The
+// first error is kept and later reads return zeros.
+type cursor struct {
+	r   io.ReaderAt
+	off int64
+	err error
+}
+
+func (c *cursor) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *cursor) bytes(n int) []byte {
+	buf := make([]byte, n)
+	if c.err != nil {
+		return buf
+	}
+	if _, err := c.r.ReadAt(buf, c.off); err != nil {
+		c.fail(err)
+	}
+	c.off += int64(n)
+	return buf
+}
+
+func (c *cursor) byte() byte {
+	return c.bytes(1)[0]
+}
+
+func (c *cursor) uint16() uint16 {
+	return binary.LittleEndian.Uint16(c.bytes(2))
+}
+
+func (c *cursor) uint32() uint32 {
+	return binary.LittleEndian.Uint32(c.bytes(4))
+}
+
+func (c *cursor) align(n int64) {
+	if rem := c.off % n; rem != 0 {
+		c.off += n - rem
+	}
+}
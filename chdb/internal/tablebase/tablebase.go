@@ -0,0 +1,575 @@
+// Package tablebase looks up endgame positions in local Syzygy tablebases.
+package tablebase
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// WDL values are from the side to move's point of view. Cursed wins and
+// blessed losses are wins and losses that the fifty-move rule turns into draws.
+const (
+	Loss        = -2
+	BlessedLoss = -1
+	Draw        = 0
+	CursedWin   = 1
+	Win         = 2
+)
+
+var (
+	ErrTooManyPieces = errors.New("position has more pieces than the available tablebases")
+	ErrCastling      = errors.New("tablebases do not cover positions with castling rights")
+	ErrMissingTable  = errors.New("no tablebase file for this material")
+	ErrCorruptTable  = errors.New("tablebase file is corrupt")
+)
+
+// Result is the tablebase value of a position. DTZ is the distance in plies
+// to the next capture or pawn move of a won or lost position, negative when
+// the side to move loses, and nil when no DTZ table is available.
+type Result struct {
+	WDL      int    `json:"wdl"`
+	Category string `json:"category"`
+	DTZ      *int   `json:"dtz,omitempty"`
+	Table    string `json:"table,omitempty"`
+}
+
+type Prober interface {
+	MaxPieces() int
+	Probe(fen string) (*Result, error)
+}
+
+// Syzygy probes the .rtbw (WDL) and .rtbz (DTZ) files found in one or more
+// directories, separated like PATH entries as UCI engines expect for
+// SyzygyPath. Files are opened on first use and stay open.
+//
+// The tables only store what the generator could not cheaply derive, so
+// probes also search captures and, for DTZ, pawn moves, as described by
+// the format's author for engines.
+type Syzygy struct {
+	wdl       map[string]*table
+	dtz       map[string]*table
+	maxPieces int
+}
+
+func Open(path string) (*Syzygy, error) {
+	s := &Syzygy{wdl: make(map[string]*table), dtz: make(map[string]*table)}
+
+	for _, dir := range filepath.SplitList(path) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			ext := filepath.Ext(name)
+			table := strings.TrimSuffix(name, ext)
+			if entry.IsDir() || !validTableName(table) {
+				continue
+			}
+
+			switch ext {
+			case ".rtbw":
+				s.wdl[table] = newTable(table, filepath.Join(dir, name), false)
+			case ".rtbz":
+				s.dtz[table] = newTable(table, filepath.Join(dir, name), true)
+			default:
+				continue
+			}
+
+			if pieces := len(table) - 1; pieces > s.maxPieces {
+				s.maxPieces = pieces
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Close closes the table files opened by probes.
+func (s *Syzygy) Close() error {
+	var firstErr error
+	for _, tables := range []map[string]*table{s.wdl, s.dtz} {
+		for _, t := range tables {
+			if t.file != nil {
+				if err := t.file.Close(); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// MaxPieces returns the largest number of pieces, kings included, covered by
+// the discovered tables.
+func (s *Syzygy) MaxPieces() int {
+	return s.maxPieces
+}
+
+// Tables lists the material signatures with a WDL table, such as "KRvK".
+func (s *Syzygy) Tables() []string {
+	tables := make([]string, 0, len(s.wdl))
+	for table := range s.wdl {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (s *Syzygy) Probe(fen string) (*Result, error) {
+	pos := &chess.Position{}
+	if err := pos.UnmarshalText([]byte(fen)); err != nil {
+		return nil, err
+	}
+
+	if fields := strings.Fields(fen); len(fields) > 2 && fields[2] != "-" {
+		return nil, ErrCastling
+	}
+
+	switch pos.Status() {
+	case chess.Checkmate:
+		return newResult(Loss, 0), nil
+	case chess.Stalemate:
+		return newResult(Draw, 0), nil
+	}
+
+	pieces := pos.Board().SquareMap()
+	if insufficientMaterial(pieces) {
+		return newResult(Draw, 0), nil
+	}
+
+	if len(pieces) > s.maxPieces {
+		return nil, ErrTooManyPieces
+	}
+
+	table := s.findTable(pieces)
+	if table == "" {
+		return nil, ErrMissingTable
+	}
+
+	wdl, _, err := s.search(pos, false)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{WDL: wdl, Category: Category(wdl), Table: table}
+
+	if _, ok := s.dtz[table]; ok {
+		dtz, err := s.probeDTZ(pos)
+		switch {
+		case err == nil:
+			result.DTZ = &dtz
+		case !errors.Is(err, ErrMissingTable):
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// probeState tells how a table value must be read.
+type probeState int
+
+const (
+	stateOK probeState = iota
+	// stateZeroing: the best move is a capture or pawn move, so the DTZ
+	// table does not hold the value of the position.
+	stateZeroing
+	// stateChangeSTM: the DTZ table only holds the other side to move.
+	stateChangeSTM
+)
+
+// search returns the WDL value of a position. Tables may store any value
+// for positions where a capture wins (or, with zeroing, a pawn move wins),
+// and a loss where a capture draws, so captures are searched and the best
+// result is kept.
+func (s *Syzygy) search(pos *chess.Position, zeroing bool) (int, probeState, error) {
+	bestValue := Loss
+	moves := pos.ValidMoves()
+	searched := 0
+
+	for _, m := range moves {
+		if !isCapture(m) && (!zeroing || !isPawnMove(pos, m)) {
+			continue
+		}
+		searched++
+
+		v, _, err := s.search(pos.Update(m), false)
+		if err != nil {
+			return 0, stateOK, err
+		}
+		if value := -v; value > bestValue {
+			bestValue = value
+			if value >= Win {
+				return value, stateZeroing, nil
+			}
+		}
+	}
+
+	// When every legal move was searched the table is not needed, and may
+	// even be wrong, as it does not know about en passant captures.
+	allSearched := searched > 0 && searched == len(moves)
+
+	value := bestValue
+	if !allSearched {
+		var err error
+		value, _, err = s.probeTable(pos, s.wdl, 0)
+		if err != nil {
+			return 0, stateOK, err
+		}
+	}
+
+	if bestValue >= value {
+		if bestValue > Draw || allSearched {
+			return bestValue, stateZeroing, nil
+		}
+		return bestValue, stateOK, nil
+	}
+	return value, stateOK, nil
+}
+
+// probeDTZ returns the DTZ value of a position: plies to the next zeroing
+// move of the winning line, counted from the side to move's point of view
+// and increased by 100 for cursed wins and blessed losses.
+func (s *Syzygy) probeDTZ(pos *chess.Position) (int, error) {
+	wdl, state, err := s.search(pos, true)
+	if err != nil || wdl == Draw {
+		return 0, err
+	}
+	if state == stateZeroing {
+		return dtzBeforeZeroing(wdl), nil
+	}
+
+	dtz, state, err := s.probeTable(pos, s.dtz, wdl)
+	if err != nil {
+		return 0, err
+	}
+	if state != stateChangeSTM {
+		if wdl == BlessedLoss || wdl == CursedWin {
+			dtz += 100
+		}
+		return dtz * sign(wdl), nil
+	}
+
+	// The table holds the other side to move, so take the best move by the
+	// DTZ of the positions it leads to.
+	minDTZ := 0xFFFF
+	for _, m := range pos.ValidMoves() {
+		next := pos.Update(m)
+		zeroingMove := isCapture(m) || isPawnMove(pos, m)
+
+		if zeroingMove {
+			v, _, err := s.search(next, false)
+			if err != nil {
+				return 0, err
+			}
+			dtz = -dtzBeforeZeroing(v)
+		} else {
+			v, err := s.probeDTZ(next)
+			if err != nil {
+				return 0, err
+			}
+			dtz = -v
+		}
+
+		if dtz == 1 && next.Status() == chess.Checkmate {
+			minDTZ = 1
+		}
+		if !zeroingMove {
+			dtz += sign(dtz)
+		}
+		if dtz < minDTZ && sign(dtz) == sign(wdl) {
+			minDTZ = dtz
+		}
+	}
+
+	if minDTZ == 0xFFFF {
+		return -1, nil
+	}
+	return minDTZ, nil
+}
+
+// probeTable looks a position up in the WDL or DTZ table of its material.
+func (s *Syzygy) probeTable(pos *chess.Position, tables map[string]*table, wdl int) (int, probeState, error) {
+	pieces := pos.Board().SquareMap()
+	if len(pieces) == 2 {
+		return Draw, stateOK, nil
+	}
+
+	white, black := materialSide(pieces, chess.White), materialSide(pieces, chess.Black)
+	t, blackStronger := tables[white+"v"+black], false
+	if t == nil {
+		t, blackStronger = tables[black+"v"+white], true
+	}
+	if t == nil {
+		return 0, stateOK, ErrMissingTable
+	}
+	if err := t.open(); err != nil {
+		return 0, stateOK, err
+	}
+
+	return t.probe(pos, blackStronger, wdl)
+}
+
+// probe reads the value of a position from the table. Tables are stored
+// with their stronger side as white, and symmetric tables only with white
+// to move, so other positions are looked up with colors swapped and the
+// board mirrored vertically.
+func (t *table) probe(pos *chess.Position, blackStronger bool, wdl int) (int, probeState, error) {
+	flip := blackStronger || (t.symmetric && pos.Turn() == chess.Black)
+	flipColor, flipSquares, stm := 0, 0, 0
+	if flip {
+		flipColor, flipSquares = blackFlag, 56
+	}
+	if (pos.Turn() == chess.Black) != flip {
+		stm = 1
+	}
+
+	var board [64]int
+	for sq, piece := range pos.Board().SquareMap() {
+		board[int(sq)^flipSquares] = pieceCode(piece) ^ flipColor
+	}
+	squares, pieces, leadPawns, file := t.arrange(&board)
+	if len(squares) != t.pieceCount {
+		return 0, stateOK, ErrMissingTable
+	}
+
+	if t.dtz && int(t.part(stm, file).flags&flagSTM) != stm && !(t.symmetric && !t.hasPawns) {
+		return 0, stateChangeSTM, nil
+	}
+
+	d := t.part(stm, file)
+	value, err := t.value(d, t.encode(d, squares, pieces, leadPawns))
+	if err != nil {
+		return 0, stateOK, err
+	}
+
+	if !t.dtz {
+		return value - 2, stateOK, nil
+	}
+	value, err = t.mapDTZ(file, value, wdl)
+	return value, stateOK, err
+}
+
+// arrange lists the pieces of a board seen from the table's stronger side,
+// the lead pawns first with the leading one at index 0. With pawns the table
+// is split by the file of the leading pawn, the pawn of the lead color
+// nearest the edge and the first rank, which is returned mirrored to a..d.
+func (t *table) arrange(board *[64]int) (squares, pieces []int, leadPawns, file int) {
+	leadPiece := -1
+	if t.hasPawns {
+		leadPiece = t.part(0, 0).pieces[0]
+		for sq, code := range board {
+			if code == leadPiece {
+				squares = append(squares, sq)
+				pieces = append(pieces, code)
+			}
+		}
+		leadPawns = len(squares)
+
+		lead := 0
+		for i := 1; i < leadPawns; i++ {
+			if mapPawns[squares[i]] > mapPawns[squares[lead]] {
+				lead = i
+			}
+		}
+		squares[0], squares[lead] = squares[lead], squares[0]
+
+		file = fileOf(squares[0])
+		if file > 3 {
+			file = 7 - file
+		}
+	}
+
+	for sq, code := range board {
+		if code != 0 && code != leadPiece {
+			squares = append(squares, sq)
+			pieces = append(pieces, code)
+		}
+	}
+	return squares, pieces, leadPawns, file
+}
+
+// mapDTZ turns a stored DTZ value into plies. Stored values may be
+// renumbered by frequency, and distances in moves rather than plies.
+func (t *table) mapDTZ(file, value, wdl int) (int, error) {
+	d := t.part(0, file)
+
+	if d.flags&flagMapped != 0 {
+		// The maps are stored for win, loss, cursed win and blessed loss.
+		wdlMap := [5]int{1, 3, 0, 2, 0}
+		i := d.mapIdx[wdlMap[wdl+2]] + value
+		if d.flags&flagWide != 0 {
+			if 2*i+2 > len(t.dtzMap) {
+				return 0, ErrCorruptTable
+			}
+			value = int(binary.LittleEndian.Uint16(t.dtzMap[2*i:]))
+		} else {
+			if i >= len(t.dtzMap) {
+				return 0, ErrCorruptTable
+			}
+			value = int(t.dtzMap[i])
+		}
+	}
+
+	if (wdl == Win && d.flags&flagWinPlies == 0) ||
+		(wdl == Loss && d.flags&flagLossPlies == 0) ||
+		wdl == CursedWin || wdl == BlessedLoss {
+		value *= 2
+	}
+	return value + 1, nil
+}
+
+// dtzBeforeZeroing is the DTZ of a position whose best move is a capture or
+// pawn move with the given result.
+func dtzBeforeZeroing(wdl int) int {
+	switch wdl {
+	case Win:
+		return 1
+	case CursedWin:
+		return 101
+	case BlessedLoss:
+		return -101
+	case Loss:
+		return -1
+	}
+	return 0
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+func isCapture(m *chess.Move) bool {
+	return m.HasTag(chess.Capture) || m.HasTag(chess.EnPassant)
+}
+
+func isPawnMove(pos *chess.Position, m *chess.Move) bool {
+	return pos.Board().Piece(m.S1()).Type() == chess.Pawn
+}
+
+// pieceCode returns the code of a piece in the table files: pawn 1 to king
+// 6, plus 8 for black, or 0 for no piece.
+func pieceCode(p chess.Piece) int {
+	var code int
+	switch p.Type() {
+	case chess.Pawn:
+		code = pawn
+	case chess.Knight:
+		code = 2
+	case chess.Bishop:
+		code = 3
+	case chess.Rook:
+		code = 4
+	case chess.Queen:
+		code = 5
+	case chess.King:
+		code = king
+	default:
+		return 0
+	}
+	if p.Color() == chess.Black {
+		code |= blackFlag
+	}
+	return code
+}
+
+// findTable returns the signature of the WDL table for the material on the
+// board. Tables are named with the stronger side first, so both orders are
+// tried.
+func (s *Syzygy) findTable(pieces map[chess.Square]chess.Piece) string {
+	white, black := materialSide(pieces, chess.White), materialSide(pieces, chess.Black)
+	for _, table := range []string{white + "v" + black, black + "v" + white} {
+		if _, ok := s.wdl[table]; ok {
+			return table
+		}
+	}
+	return ""
+}
+
+func materialSide(pieces map[chess.Square]chess.Piece, color chess.Color) string {
+	var sb strings.Builder
+	for _, t := range []chess.PieceType{chess.King, chess.Queen, chess.Rook, chess.Bishop, chess.Knight, chess.Pawn} {
+		for _, piece := range pieces {
+			if piece.Color() == color && piece.Type() == t {
+				sb.WriteString(strings.ToUpper(t.String()))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// insufficientMaterial reports positions where neither side can mate: bare
+// kings, a single minor piece, or only bishops on squares of one color.
+func insufficientMaterial(pieces map[chess.Square]chess.Piece) bool {
+	minors := 0
+	bishopColors := make(map[int]bool)
+
+	for sq, piece := range pieces {
+		switch piece.Type() {
+		case chess.King:
+		case chess.Knight:
+			minors++
+		case chess.Bishop:
+			minors++
+			bishopColors[(int(sq.File())+int(sq.Rank()))%2] = true
+		default:
+			return false
+		}
+	}
+
+	return minors <= 1 || (len(bishopColors) == 1 && minors == countType(pieces, chess.Bishop))
+}
+
+func countType(pieces map[chess.Square]chess.Piece, t chess.PieceType) int {
+	n := 0
+	for _, piece := range pieces {
+		if piece.Type() == t {
+			n++
+		}
+	}
+	return n
+}
+
+func validTableName(name string) bool {
+	sides := strings.Split(name, "v")
+	if len(sides) != 2 {
+		return false
+	}
+	for _, side := range sides {
+		if side == "" || side[0] != 'K' || strings.Trim(side[1:], "QRBNP") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func newResult(wdl, dtz int) *Result {
+	return &Result{WDL: wdl, Category: Category(wdl), DTZ: &dtz}
+}
+
+func Category(wdl int) string {
+	switch wdl {
+	case Win:
+		return "win"
+	case CursedWin:
+		return "cursed_win"
+	case BlessedLoss:
+		return "blessed_loss"
+	case Loss:
+		return "loss"
+	}
+	return "draw"
+}
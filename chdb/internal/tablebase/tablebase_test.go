@@ -0,0 +1,570 @@
+package tablebase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexTables(t *testing.T) {
+	seen := make(map[int]bool)
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 <= 27; s1++ {
+			if fileOf(s1) > 3 || mapA1D1D4[s1] != idx || (idx == 0 && s1 != 1) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				if kingsAdjacent(s1, s2) || (offDiagonal(s1) == 0 && offDiagonal(s2) > 0) {
+					continue
+				}
+				code := mapKK[idx][s2]
+				if seen[code] {
+					t.Fatalf("mapKK code %d used twice", code)
+				}
+				seen[code] = true
+			}
+		}
+	}
+	if len(seen) != 462 {
+		t.Errorf("mapKK has %d codes, want 462", len(seen))
+	}
+
+	if got := binomial[2][48]; got != 1128 {
+		t.Errorf("binomial[2][48] = %d, want 1128", got)
+	}
+	var total uint64
+	for file := 0; file < 4; file++ {
+		total += leadPawnsSize[1][file]
+	}
+	if total != 24 {
+		t.Errorf("one lead pawn has %d placements, want 24", total)
+	}
+}
+
+// symmetries returns the board transformations that a table may use to
+// store a position: file mirroring with pawns, all eight without.
+func symmetries(hasPawns bool) []func(int) int {
+	mirror := func(sq int) int { return sq ^ 7 }
+	if hasPawns {
+		return []func(int) int{func(sq int) int { return sq }, mirror}
+	}
+	var all []func(int) int
+	for _, f := range []bool{false, true} {
+		for _, r := range []bool{false, true} {
+			for _, d := range []bool{false, true} {
+				f, r, d := f, r, d
+				all = append(all, func(sq int) int {
+					if f {
+						sq ^= 7
+					}
+					if r {
+						sq ^= 56
+					}
+					if d {
+						sq = (sq>>3 | sq<<3) & 63
+					}
+					return sq
+				})
+			}
+		}
+	}
+	return all
+}
+
+// canonical packs the smallest image of a placement under the symmetries,
+// so that two placements are equivalent when their canonical forms are equal.
+func canonical(squares, codes []int, syms []func(int) int) uint64 {
+	var best uint64
+	var image [maxTablePieces]uint64
+	for i, sym := range syms {
+		for j, sq := range squares {
+			v := uint64(sym(sq))<<4 | uint64(codes[j])
+			k := j
+			for ; k > 0 && image[k-1] > v; k-- {
+				image[k] = image[k-1]
+			}
+			image[k] = v
+		}
+		var packed uint64
+		for _, v := range image[:len(squares)] {
+			packed = packed<<10 | v
+		}
+		if i == 0 || packed < best {
+			best = packed
+		}
+	}
+	return best
+}
+
+// TestEncodeSeparatesPositions checks that the index of every placement is
+// within the table and that two placements only share an index when the
+// table's symmetries map one onto the other.
+func TestEncodeSeparatesPositions(t *testing.T) {
+	tests := []struct {
+		name   string
+		pieces []int // in the order stored in the table, lead pawns first
+	}{
+		{"KRvK", []int{king, 4, king | blackFlag}},
+		{"KvKN", []int{king, king | blackFlag, 2 | blackFlag}},
+		{"KPvK", []int{pawn, king, king | blackFlag}},
+		{"KRRvK", []int{king, king | blackFlag, 4, 4}},
+		{"KPPvK", []int{pawn, pawn, king, king | blackFlag}},
+		{"KPvKP", []int{pawn, pawn | blackFlag, king, king | blackFlag}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.pieces) > 3 && testing.Short() {
+				t.Skip("four-piece placements in short mode")
+			}
+			tb := newTable(tt.name, "", false)
+			files := 1
+			if tb.hasPawns {
+				files = 4
+			}
+			for f := 0; f < files; f++ {
+				d := tb.part(0, f)
+				copy(d.pieces[:], tt.pieces)
+				order := [2]int{0, 0xF}
+				if tb.pawnCount[1] > 0 {
+					order[1] = 1
+				}
+				tb.setGroups(d, order, f)
+			}
+
+			syms := symmetries(tb.hasPawns)
+			var owners [4][]uint64
+			for f := 0; f < files; f++ {
+				d := tb.part(0, f)
+				owners[f] = make([]uint64, d.groupIdx[groupCount(d)])
+			}
+			placements := 0
+			var board [64]int
+			squares := make([]int, len(tt.pieces))
+			var place func(i int)
+			place = func(i int) {
+				if i == len(tt.pieces) {
+					placements++
+					checkPlacement(t, tb, &board, squares, tt.pieces, syms, &owners)
+					return
+				}
+				code := tt.pieces[i]
+				for sq := 0; sq < 64; sq++ {
+					if board[sq] != 0 || (code&7 == pawn && (rankOf(sq) == 0 || rankOf(sq) == 7)) {
+						continue
+					}
+					// Identical pieces are placed in ascending order.
+					if i > 0 && tt.pieces[i-1] == code && !placedBefore(&board, code, sq) {
+						continue
+					}
+					board[sq] = code
+					squares[i] = sq
+					place(i + 1)
+					board[sq] = 0
+				}
+			}
+			place(0)
+
+			if placements == 0 {
+				t.Fatal("no placements")
+			}
+		})
+	}
+}
+
+func placedBefore(board *[64]int, code, sq int) bool {
+	for s := 0; s < sq; s++ {
+		if board[s] == code {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPlacement(t *testing.T, tb *table, board *[64]int, squares, codes []int, syms []func(int) int, owners *[4][]uint64) {
+	kings := make([]int, 0, 2)
+	for i, code := range codes {
+		if code&7 == king {
+			kings = append(kings, squares[i])
+		}
+	}
+	if kingsAdjacent(kings[0], kings[1]) {
+		return
+	}
+
+	b := *board
+	squares, pieces, leadPawns, file := tb.arrange(&b)
+	d := tb.part(0, file)
+	idx := tb.encode(d, squares, pieces, leadPawns)
+
+	if size := uint64(len(owners[file])); idx >= size {
+		t.Fatalf("index %d of %v is not below the table size %d", idx, board, size)
+	}
+
+	form := canonical(squares, codes, syms)
+	if owner := owners[file][idx]; owner != 0 && owner != form {
+		t.Fatalf("index %d is shared by %x and %x", idx, owner, form)
+	}
+	owners[file][idx] = form
+}
+
+func groupCount(d *pairsData) int {
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	return n
+}
+
+// writer builds a table file with values compressed by a small fixed code:
+// five leaf symbols for the values 0 to 4 and three symbols for the pairs
+// 0 0, 2 2 and 4 4. Symbols 0 to 3 have 4-bit codes and 4 to 7 3-bit codes.
+type writer struct {
+	blockSize int
+	span      int
+}
+
+type part struct {
+	sparse  []byte
+	lengths []byte
+	data    []byte
+	blocks  int
+}
+
+var pairs = map[int]int{0: 5, 2: 6, 4: 7}
+
+func code(sym int) (uint64, int) {
+	if sym < 4 {
+		return uint64(sym), 4
+	}
+	return uint64(sym - 2), 3
+}
+
+func (w writer) compress(values []int) part {
+	var p part
+	var blockValues []int // number of values in each block
+	var block []byte
+	var bits uint
+	var acc uint64
+	var count int
+
+	flushBlock := func() {
+		for bits > 0 {
+			block = append(block, byte(acc>>56))
+			acc <<= 8
+			if bits < 8 {
+				bits = 0
+			} else {
+				bits -= 8
+			}
+		}
+		block = append(block, make([]byte, w.blockSize-len(block))...)
+		p.data = append(p.data, block...)
+		blockValues = append(blockValues, count)
+		block, bits, acc, count = nil, 0, 0, 0
+	}
+
+	for i := 0; i < len(values); {
+		sym, n := values[i], 1
+		if pair, ok := pairs[values[i]]; ok && i+1 < len(values) && values[i+1] == values[i] {
+			sym, n = pair, 2
+		}
+		c, l := code(sym)
+		if len(block)*8+int(bits)+l > w.blockSize*8 {
+			flushBlock()
+		}
+		acc |= c << (64 - uint(l) - bits)
+		bits += uint(l)
+		for bits >= 8 {
+			block = append(block, byte(acc>>56))
+			acc <<= 8
+			bits -= 8
+		}
+		count += n
+		i += n
+	}
+	flushBlock()
+
+	p.blocks = len(blockValues)
+	for _, n := range blockValues {
+		p.lengths = binary.LittleEndian.AppendUint16(p.lengths, uint16(n-1))
+	}
+
+	// The sparse index points at the middle of every span.
+	for start := 0; start < len(values); start += w.span {
+		idx := start + w.span/2
+		b, first := 0, 0
+		for first+blockValues[b] <= idx && b < len(blockValues)-1 {
+			first += blockValues[b]
+			b++
+		}
+		p.sparse = binary.LittleEndian.AppendUint32(p.sparse, uint32(b))
+		p.sparse = binary.LittleEndian.AppendUint16(p.sparse, uint16(idx-first))
+	}
+	return p
+}
+
+func (w writer) sizes(p part) []byte {
+	log2 := func(n int) byte {
+		var l byte
+		for 1<<l < n {
+			l++
+		}
+		return l
+	}
+
+	b := []byte{0, log2(w.blockSize), log2(w.span), 0}
+	b = binary.LittleEndian.AppendUint32(b, uint32(p.blocks))
+	b = append(b, 4, 3)                        // max and min symbol length
+	b = binary.LittleEndian.AppendUint16(b, 4) // lowest symbol of length 3
+	b = binary.LittleEndian.AppendUint16(b, 0) // lowest symbol of length 4
+	b = binary.LittleEndian.AppendUint16(b, 8) // symbols
+	for sym := 0; sym < 8; sym++ {
+		left, right := sym, 0xFFF
+		if sym > 4 {
+			left = 2 * (sym - 5)
+			right = left
+		}
+		b = append(b, byte(left), byte(left>>8)|byte(right<<4), byte(right>>4))
+	}
+	return b
+}
+
+// writeKRvK writes a KRvK WDL table whose value at each index of each side
+// to move is given by the functions.
+func writeKRvK(t *testing.T, dir string, values [2]func(idx int) int) {
+	t.Helper()
+
+	w := writer{blockSize: 32, span: 64}
+	var parts [2]part
+	for stm := range parts {
+		v := make([]int, 31332)
+		for idx := range v {
+			v[idx] = values[stm](idx)
+		}
+		parts[stm] = w.compress(v)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(wdlMagic[:])
+	buf.Write([]byte{1, 0x00, 0x66, 0x44, 0xEE}) // split, orders, K R k
+	buf.WriteByte(0)                             // alignment
+	for _, p := range parts {
+		buf.Write(w.sizes(p))
+	}
+	for _, p := range parts {
+		buf.Write(p.sparse)
+	}
+	for _, p := range parts {
+		buf.Write(p.lengths)
+	}
+	for _, p := range parts {
+		buf.Write(make([]byte, (64-buf.Len()%64)%64))
+		buf.Write(p.data)
+	}
+	buf.Write(make([]byte, (64-buf.Len()%64)%64+16))
+
+	if err := os.WriteFile(filepath.Join(dir, "KRvK.rtbw"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var syntheticValues = [2]func(int) int{
+	func(idx int) int { return [3]int{0, 2, 4}[(idx/7)%3] },
+	func(idx int) int { return [4]int{4, 1, 2, 3}[(idx/5)%4] },
+}
+
+func TestValueDecodesBlocks(t *testing.T) {
+	dir := t.TempDir()
+	writeKRvK(t, dir, syntheticValues)
+
+	tb := newTable("KRvK", filepath.Join(dir, "KRvK.rtbw"), false)
+	if err := tb.open(); err != nil {
+		t.Fatal(err)
+	}
+	defer tb.file.Close()
+
+	for stm := 0; stm < 2; stm++ {
+		d := tb.part(stm, 0)
+		if d.numBlocks < 2 {
+			t.Fatalf("side %d has %d blocks, want several", stm, d.numBlocks)
+		}
+		for idx := 0; idx < 31332; idx++ {
+			got, err := tb.value(d, uint64(idx))
+			if err != nil {
+				t.Fatalf("side %d index %d: %v", stm, idx, err)
+			}
+			if want := syntheticValues[stm](idx); got != want {
+				t.Fatalf("side %d index %d = %d, want %d", stm, idx, got, want)
+			}
+		}
+	}
+}
+
+func TestOpenRejectsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "KRvK.rtbw")
+	if err := os.WriteFile(path, make([]byte, 80), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tb := newTable("KRvK", path, false)
+	if err := tb.open(); err == nil {
+		t.Error("open accepted a file without the WDL magic")
+	}
+}
+
+// TestProbeColorSymmetry probes positions of the synthetic table and the
+// same positions with colors swapped, which are looked up in the same
+// table entry.
+func TestProbeColorSymmetry(t *testing.T) {
+	dir := t.TempDir()
+	writeKRvK(t, dir, syntheticValues)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct{ fen, swapped string }{
+		{"8/8/8/3k4/8/8/1R6/4K3 w - - 0 1", "4k3/1r6/8/8/3K4/8/8/8 b - - 0 1"},
+		{"8/8/8/3k4/8/8/1R6/4K3 b - - 0 1", "4k3/1r6/8/8/3K4/8/8/8 w - - 0 1"},
+		{"k7/8/2K5/8/8/8/8/6R1 w - - 0 1", "6r1/8/8/8/8/2k5/8/K7 b - - 0 1"},
+		{"7k/8/8/8/8/8/5K2/R7 b - - 0 1", "r7/5k2/8/8/8/8/8/7K w - - 0 1"},
+	}
+	for _, tt := range tests {
+		got, err := s.Probe(tt.fen)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fen, err)
+		}
+		swapped, err := s.Probe(tt.swapped)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.swapped, err)
+		}
+		if got.WDL != swapped.WDL || got.Table != "KRvK" || swapped.Table != "KRvK" {
+			t.Errorf("%s = %+v, swapped %+v", tt.fen, got, swapped)
+		}
+		if got.DTZ != nil {
+			t.Errorf("%s: DTZ without a DTZ table", tt.fen)
+		}
+	}
+
+	if _, err := s.Probe("8/8/8/3k4/8/8/1Q6/4K3 w - - 0 1"); err != ErrMissingTable {
+		t.Errorf("probe of KQvK = %v, want ErrMissingTable", err)
+	}
+}
+
+func TestMapDTZ(t *testing.T) {
+	tb := &table{dtz: true, dtzMap: []byte{
+		// win: 2 values, loss: 1, cursed win: 1, blessed loss: 1
+		2, 7, 9, 1, 12, 1, 30, 1, 40,
+	}}
+	d := tb.part(0, 0)
+	d.flags = flagMapped | flagWinPlies
+	d.mapIdx = [4]int{1, 4, 6, 8}
+
+	tests := []struct{ value, wdl, want int }{
+		{0, Win, 8},          // plies as stored
+		{1, Win, 10},         // second entry of the win map
+		{0, Loss, 25},        // moves, doubled
+		{0, CursedWin, 61},   // always moves
+		{0, BlessedLoss, 81}, // always moves
+	}
+	for _, tt := range tests {
+		got, err := tb.mapDTZ(0, tt.value, tt.wdl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("mapDTZ(%d, %d) = %d, want %d", tt.value, tt.wdl, got, tt.want)
+		}
+	}
+}
+
+func TestProbeWithoutTables(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fen  string
+		want int
+		err  error
+	}{
+		{"7k/6Q1/6K1/8/8/8/8/8 b - - 0 1", Loss, nil}, // checkmate
+		{"7k/5Q2/5K2/8/8/8/8/8 b - - 0 1", Draw, nil}, // stalemate
+		{"8/8/4k3/8/8/3NK3/8/8 w - - 0 1", Draw, nil}, // insufficient material
+		{"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1", 0, ErrCastling},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 0 1", 0, ErrTooManyPieces},
+	}
+	for _, tt := range tests {
+		got, err := s.Probe(tt.fen)
+		if err != tt.err {
+			t.Errorf("%s: error %v, want %v", tt.fen, err, tt.err)
+			continue
+		}
+		if err == nil && got.WDL != tt.want {
+			t.Errorf("%s = %d, want %d", tt.fen, got.WDL, tt.want)
+		}
+	}
+}
+
+// TestProbeSyzygy checks known values against real tables, read from the
+// directories of SYZYGY_PATH or else from testdata/syzygy. Positions whose
+// table is not there are skipped.
+func TestProbeSyzygy(t *testing.T) {
+	path := os.Getenv("SYZYGY_PATH")
+	if path == "" {
+		path = filepath.Join("testdata", "syzygy")
+		if _, err := os.Stat(path); err != nil {
+			t.Skip("SYZYGY_PATH is not set and testdata/syzygy holds no tables")
+		}
+	}
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	dtz := func(n int) *int { return &n }
+	tests := []struct {
+		fen string
+		wdl int
+		dtz *int
+	}{
+		{"4k3/8/8/8/8/8/8/4K2Q w - - 0 1", Win, nil},
+		{"4k3/8/8/8/8/8/8/4K2Q b - - 0 1", Loss, nil},
+		{"k7/8/1K6/8/8/8/8/7Q w - - 0 1", Win, dtz(1)},
+		{"8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", Draw, dtz(0)},
+		{"4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", Win, nil},
+		{"8/8/8/4k3/8/8/8/2BNK3 w - - 0 1", Win, nil},
+	}
+	probed := 0
+	for _, tt := range tests {
+		got, err := s.Probe(tt.fen)
+		if errors.Is(err, ErrMissingTable) || errors.Is(err, ErrTooManyPieces) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fen, err)
+		}
+		probed++
+		if got.WDL != tt.wdl {
+			t.Errorf("%s: WDL %d, want %d", tt.fen, got.WDL, tt.wdl)
+		}
+		switch {
+		case tt.dtz == nil:
+		case got.DTZ == nil:
+			t.Errorf("%s: no DTZ, want %d", tt.fen, *tt.dtz)
+		case *got.DTZ != *tt.dtz:
+			t.Errorf("%s: DTZ %d, want %d", tt.fen, *got.DTZ, *tt.dtz)
+		}
+	}
+	if probed == 0 {
+		t.Skipf("no table of the test positions in %s", path)
+	}
+}
@@ -0,0 +1,345 @@
+package tabular
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Parquet files are written uncompressed with PLAIN encoded data pages, one
+// page per column chunk. Rows are buffered into row groups, so a file is
+// streamed with bounded memory and its metadata follows the last row group.
+
+const parquetRowGroupSize = 10000
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types, repetitions, encodings and page types.
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetConvertedUTF8 = 0
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage = 0
+)
+
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetColumnChunk
+	rows   int64
+}
+
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []Column
+	values    [][]interface{}
+	rows      int
+	rowGroups []parquetRowGroup
+	started   bool
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	return &parquetWriter{
+		w:       w,
+		columns: columns,
+		values:  make([][]interface{}, len(columns)),
+	}
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) Write(row Row) error {
+	for i, c := range pw.columns {
+		pw.values[i] = append(pw.values[i], c.Value(row))
+	}
+	pw.rows++
+
+	if pw.rows == parquetRowGroupSize {
+		return pw.flushRowGroup()
+	}
+	return nil
+}
+
+func (pw *parquetWriter) start() error {
+	if pw.started {
+		return nil
+	}
+	pw.started = true
+	return pw.write([]byte(parquetMagic))
+}
+
+func (pw *parquetWriter) flushRowGroup() error {
+	if err := pw.start(); err != nil {
+		return err
+	}
+	if pw.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rows: int64(pw.rows)}
+	for i, c := range pw.columns {
+		page := encodePage(c, pw.values[i])
+
+		var header thriftWriter
+		header.beginStruct()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(pw.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetColumnChunk{offset: pw.offset, size: int64(header.buf.Len() + len(page))}
+		if err := pw.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+
+		pw.values[i] = pw.values[i][:0]
+	}
+
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows = 0
+	return nil
+}
+
+// encodePage returns the body of a data page: the definition levels of a
+// nullable column, then the PLAIN encoded values that are not null.
+func encodePage(c Column, values []interface{}) []byte {
+	var page bytes.Buffer
+
+	if c.Nullable {
+		levels := encodeDefinitionLevels(values)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case int64:
+			binary.Write(&page, binary.LittleEndian, v)
+		case string:
+			binary.Write(&page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		}
+	}
+
+	return page.Bytes()
+}
+
+// encodeDefinitionLevels encodes 1 for present values and 0 for nulls with
+// the RLE/bit-packing hybrid, as runs of equal levels.
+func encodeDefinitionLevels(values []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(values); {
+		level := byte(1)
+		if values[i] == nil {
+			level = 0
+		}
+		run := 1
+		for i+run < len(values) && (values[i+run] == nil) == (level == 0) {
+			run++
+		}
+		writeUvarint(&buf, uint64(run)<<1)
+		buf.WriteByte(level)
+		i += run
+	}
+	return buf.Bytes()
+}
+
+func (pw *parquetWriter) Close() error {
+	if err := pw.flushRowGroup(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.beginStruct()
+	meta.i32(1, 1)
+
+	meta.listField(2, thriftStruct, len(pw.columns)+1)
+	meta.beginStruct()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(pw.columns)))
+	meta.endStruct()
+	for _, c := range pw.columns {
+		meta.beginStruct()
+		if c.Type == String {
+			meta.i32(1, parquetByteArray)
+		} else {
+			meta.i32(1, parquetInt64)
+		}
+		if c.Nullable {
+			meta.i32(3, parquetOptional)
+		} else {
+			meta.i32(3, parquetRequired)
+		}
+		meta.binary(4, c.Name)
+		if c.Type == String {
+			meta.i32(6, parquetConvertedUTF8)
+		}
+		meta.endStruct()
+	}
+
+	var rows int64
+	for _, group := range pw.rowGroups {
+		rows += group.rows
+	}
+	meta.i64(3, rows)
+
+	meta.listField(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		var size int64
+		meta.beginStruct()
+		meta.listField(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			c := pw.columns[i]
+			size += chunk.size
+
+			meta.beginStruct()
+			meta.i64(2, chunk.offset)
+			meta.structField(3)
+			if c.Type == String {
+				meta.i32(1, parquetByteArray)
+			} else {
+				meta.i32(1, parquetInt64)
+			}
+			meta.listField(2, thriftI32, 2)
+			meta.listI32(parquetPlain)
+			meta.listI32(parquetRLE)
+			meta.listField(3, thriftBinary, 1)
+			meta.listBinary(c.Name)
+			meta.i32(4, 0)
+			meta.i64(5, group.rows)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, size)
+		meta.i64(3, group.rows)
+		meta.endStruct()
+	}
+
+	meta.binary(6, "chessdb")
+	meta.endStruct()
+
+	if err := pw.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(meta.buf.Len()))
+	if err := pw.write(footer[:]); err != nil {
+		return err
+	}
+	return pw.write([]byte(parquetMagic))
+}
+
+// Thrift compact protocol types used by the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol. Field IDs are
+// written as deltas from the previous field of the same struct.
+type thriftWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int16
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.lastIDs = append(t.lastIDs, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.lastIDs = t.lastIDs[:len(t.lastIDs)-1]
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.lastIDs[len(t.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+// structField starts a struct valued field, ended with endStruct.
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.beginStruct()
+}
+
+// listField starts a list valued field of n elements, which follow it.
+func (t *thriftWriter) listField(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		writeUvarint(&t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) listBinary(s string) {
+	writeUvarint(&t.buf, uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
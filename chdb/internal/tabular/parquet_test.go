@@ -0,0 +1,365 @@
+package tabular
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// The golden file was read back with an independent Parquet reader; rewrite it
+// with -update only after checking the new file the same way.
+func TestParquetGolden(t *testing.T) {
+	buf := writeTestParquet(t)
+
+	golden := filepath.Join("testdata", "plies.parquet")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Parquet file differs from %s (%d bytes, want %d)", golden, buf.Len(), len(want))
+	}
+}
+
+// TestParquetDecode reads the file back from its footer: the schema, then the
+// page of every column chunk with its definition levels and PLAIN values.
+func TestParquetDecode(t *testing.T) {
+	data := writeTestParquet(t).Bytes()
+
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatalf("missing %s magic", parquetMagic)
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	start := len(data) - 8 - size
+	if start < 4 {
+		t.Fatalf("footer length %d exceeds the file", size)
+	}
+	r := &thriftReader{buf: data[start : len(data)-8]}
+	meta, err := r.readStruct()
+	if err != nil {
+		t.Fatalf("footer: %v", err)
+	}
+	if r.pos != len(r.buf) {
+		t.Errorf("footer has %d trailing bytes", len(r.buf)-r.pos)
+	}
+	if meta[3] != int64(3) {
+		t.Errorf("num_rows = %v, want 3", meta[3])
+	}
+
+	type schemaElement struct {
+		Name       string
+		Type       int64
+		Repetition int64
+		UTF8       bool
+	}
+	want := []schemaElement{
+		{"game_id", parquetInt64, parquetRequired, false},
+		{"white", parquetByteArray, parquetRequired, true},
+		{"white_elo", parquetInt64, parquetOptional, false},
+		{"ply", parquetInt64, parquetRequired, false},
+		{"san", parquetByteArray, parquetRequired, true},
+		{"eval_cp", parquetInt64, parquetOptional, false},
+		{"clock_ms", parquetInt64, parquetOptional, false},
+	}
+	schema, _ := meta[2].([]interface{})
+	if len(schema) != len(want)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(want)+1)
+	}
+	root := schema[0].(map[int16]interface{})
+	if root[4] != "schema" || root[5] != int64(len(want)) {
+		t.Errorf("root schema element = %v", root)
+	}
+	for i, w := range want {
+		e := schema[i+1].(map[int16]interface{})
+		_, utf8 := e[6]
+		got := schemaElement{Name: fmt.Sprint(e[4]), Type: e[1].(int64), Repetition: e[3].(int64), UTF8: utf8}
+		if got != w {
+			t.Errorf("schema element %d = %+v, want %+v", i+1, got, w)
+		}
+	}
+
+	groups, _ := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("%d row groups, want 1", len(groups))
+	}
+	chunks, _ := groups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != len(want) {
+		t.Fatalf("%d column chunks, want %d", len(chunks), len(want))
+	}
+
+	values := [][]interface{}{
+		{int64(7), int64(7), int64(8)},
+		{"Carlsen, Magnus", "Carlsen, Magnus", "Anonymous"},
+		{int64(2830), int64(2830), nil},
+		{int64(1), int64(2), int64(1)},
+		{"e4", "c5", "d4"},
+		{int64(31), nil, nil},
+		{int64(179000), nil, int64(179000)},
+	}
+	for i, chunk := range chunks {
+		column := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+		if path := column[3].([]interface{}); len(path) != 1 || path[0] != want[i].Name {
+			t.Errorf("column %d path = %v, want [%s]", i, path, want[i].Name)
+		}
+		if column[5] != int64(3) {
+			t.Errorf("column %s num_values = %v, want 3", want[i].Name, column[5])
+		}
+		got, err := readPage(data, int(column[9].(int64)), want[i].Type, want[i].Repetition == parquetOptional)
+		if err != nil {
+			t.Errorf("column %s: %v", want[i].Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, values[i]) {
+			t.Errorf("column %s = %v, want %v", want[i].Name, got, values[i])
+		}
+	}
+}
+
+func writeTestParquet(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	columns, err := SelectColumns(RowsPlies, []string{"game_id", "white", "white_elo", "ply", "san", "eval_cp", "clock_ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp, clock := 31, int64(179000)
+	game := &models.Game{ID: 7, White: "Carlsen, Magnus", WhiteElo: 2830}
+	unrated := &models.Game{ID: 8, White: "Anonymous"}
+	rows := []Row{
+		{Game: game, Ply: &models.PlyFeatures{Ply: 1, SAN: "e4", EvalCP: &cp, ClockMS: &clock}},
+		{Game: game, Ply: &models.PlyFeatures{Ply: 2, SAN: "c5"}},
+		{Game: unrated, Ply: &models.PlyFeatures{Ply: 1, SAN: "d4", ClockMS: &clock}},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatParquet, &buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// readPage decodes the data page at offset, with nil for the values whose
+// definition level is 0.
+func readPage(data []byte, offset int, typ int64, optional bool) ([]interface{}, error) {
+	r := &thriftReader{buf: data[offset:]}
+	header, err := r.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("page header: %v", err)
+	}
+	if header[1] != int64(parquetDataPage) {
+		return nil, fmt.Errorf("page type %v, want a data page", header[1])
+	}
+	dataHeader := header[5].(map[int16]interface{})
+	if dataHeader[2] != int64(parquetPlain) {
+		return nil, fmt.Errorf("encoding %v, want PLAIN", dataHeader[2])
+	}
+	n := int(dataHeader[1].(int64))
+	size := int(header[2].(int64))
+	if r.pos+size > len(r.buf) {
+		return nil, fmt.Errorf("page of %d bytes exceeds the file", size)
+	}
+	page := r.buf[r.pos : r.pos+size]
+
+	levels := make([]bool, n)
+	for i := range levels {
+		levels[i] = true
+	}
+	if optional {
+		if len(page) < 4 {
+			return nil, fmt.Errorf("short definition levels")
+		}
+		length := int(binary.LittleEndian.Uint32(page))
+		if 4+length > len(page) {
+			return nil, fmt.Errorf("definition levels of %d bytes exceed the page", length)
+		}
+		levels, err = readLevels(page[4:4+length], n)
+		if err != nil {
+			return nil, err
+		}
+		page = page[4+length:]
+	}
+
+	var values []interface{}
+	for _, defined := range levels {
+		if !defined {
+			values = append(values, nil)
+			continue
+		}
+		switch typ {
+		case parquetInt64:
+			if len(page) < 8 {
+				return nil, fmt.Errorf("short INT64 value")
+			}
+			values = append(values, int64(binary.LittleEndian.Uint64(page)))
+			page = page[8:]
+		case parquetByteArray:
+			if len(page) < 4 {
+				return nil, fmt.Errorf("short BYTE_ARRAY length")
+			}
+			length := int(binary.LittleEndian.Uint32(page))
+			if 4+length > len(page) {
+				return nil, fmt.Errorf("BYTE_ARRAY of %d bytes exceeds the page", length)
+			}
+			values = append(values, string(page[4:4+length]))
+			page = page[4+length:]
+		}
+	}
+	if len(page) != 0 {
+		return nil, fmt.Errorf("%d bytes left after the values", len(page))
+	}
+	return values, nil
+}
+
+// readLevels decodes n definition levels of bit width 1 from the RLE/bit-packing
+// hybrid.
+func readLevels(b []byte, n int) ([]bool, error) {
+	var levels []bool
+	for len(levels) < n {
+		header, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, fmt.Errorf("bad run header")
+		}
+		b = b[k:]
+		if header&1 == 0 {
+			if len(b) < 1 {
+				return nil, fmt.Errorf("short RLE run")
+			}
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, b[0] == 1)
+			}
+			b = b[1:]
+			continue
+		}
+		groups := int(header >> 1)
+		if len(b) < groups {
+			return nil, fmt.Errorf("short bit-packed run")
+		}
+		for _, packed := range b[:groups] {
+			for bit := 0; bit < 8; bit++ {
+				levels = append(levels, packed>>bit&1 == 1)
+			}
+		}
+		b = b[groups:]
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%d bytes left after the levels", len(b))
+	}
+	return levels[:n], nil
+}
+
+// thriftReader decodes Thrift compact protocol structs into maps from field
+// IDs to int64, string, list and struct values.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("bad varint at %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) readStruct() (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		if r.pos >= len(r.buf) {
+			return nil, fmt.Errorf("unterminated struct")
+		}
+		b := r.buf[r.pos]
+		r.pos++
+		if b == 0 {
+			return fields, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(v))
+		}
+		v, err := r.readValue(b & 0x0f)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %v", id, err)
+		}
+		fields[id] = v
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case thriftI32, thriftI64:
+		v, err := r.uvarint()
+		return unzigzag(v), err
+	case thriftBinary:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if r.pos+int(n) > len(r.buf) {
+			return nil, fmt.Errorf("binary of %d bytes exceeds the buffer", n)
+		}
+		s := string(r.buf[r.pos : r.pos+int(n)])
+		r.pos += int(n)
+		return s, nil
+	case thriftList:
+		if r.pos >= len(r.buf) {
+			return nil, fmt.Errorf("short list header")
+		}
+		b := r.buf[r.pos]
+		r.pos++
+		n := int(b >> 4)
+		if n == 15 {
+			v, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			n = int(v)
+		}
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := r.readValue(b & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case thriftStruct:
+		return r.readStruct()
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,211 @@
+// Package tabular writes games and their plies as tables, in CSV or Parquet,
+// for analysis outside the database.
+package tabular
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// Table formats.
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// Row kinds: one row per game, or one row per ply of every game.
+const (
+	RowsGames = "games"
+	RowsPlies = "plies"
+)
+
+// Column types.
+const (
+	Int64  = "int64"
+	String = "string"
+)
+
+// Row is the data a table row is read from. Ply is nil in a table of games.
+type Row struct {
+	Game *models.Game
+	Ply  *models.PlyFeatures
+}
+
+// Column is a column of an exported table. Nullable columns are empty in CSV
+// and null in Parquet when the value is unknown.
+type Column struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Nullable    bool   `json:"nullable"`
+	Ply         bool   `json:"ply"`
+	Description string `json:"description"`
+	value       func(Row) interface{}
+}
+
+// Value returns the column's value for a row: an int64, a string or nil.
+func (c Column) Value(row Row) interface{} {
+	return c.value(row)
+}
+
+func gameString(name, description string, value func(*models.Game) string) Column {
+	return Column{Name: name, Type: String, Description: description, value: func(r Row) interface{} {
+		return value(r.Game)
+	}}
+}
+
+func gameInt(name, description string, value func(*models.Game) int) Column {
+	return Column{Name: name, Type: Int64, Description: description, value: func(r Row) interface{} {
+		return int64(value(r.Game))
+	}}
+}
+
+// rating is null when the game has none.
+func rating(name, description string, value func(*models.Game) int) Column {
+	return Column{Name: name, Type: Int64, Nullable: true, Description: description, value: func(r Row) interface{} {
+		if elo := value(r.Game); elo > 0 {
+			return int64(elo)
+		}
+		return nil
+	}}
+}
+
+func plyString(name, description string, value func(*models.PlyFeatures) string) Column {
+	return Column{Name: name, Type: String, Ply: true, Description: description, value: func(r Row) interface{} {
+		return value(r.Ply)
+	}}
+}
+
+func plyInt(name, description string, value func(*models.PlyFeatures) int) Column {
+	return Column{Name: name, Type: Int64, Ply: true, Description: description, value: func(r Row) interface{} {
+		return int64(value(r.Ply))
+	}}
+}
+
+func plyNullable(name, description string, value func(*models.PlyFeatures) *int64) Column {
+	return Column{Name: name, Type: Int64, Nullable: true, Ply: true, Description: description, value: func(r Row) interface{} {
+		if v := value(r.Ply); v != nil {
+			return *v
+		}
+		return nil
+	}}
+}
+
+func intPtr64(v *int) *int64 {
+	if v == nil {
+		return nil
+	}
+	n := int64(*v)
+	return &n
+}
+
+// Columns lists every column in table order. Game columns can be selected in
+// both tables, ply columns only in a table of plies.
+var Columns = []Column{
+	gameInt("game_id", "Game ID", func(g *models.Game) int { return int(g.ID) }),
+	gameString("event", "Event tag", func(g *models.Game) string { return g.Event }),
+	gameString("site", "Site tag", func(g *models.Game) string { return g.Site }),
+	gameString("date", "Date tag, YYYY.MM.DD with ?? for unknown parts", func(g *models.Game) string { return g.Date }),
+	gameString("round", "Round tag", func(g *models.Game) string { return g.Round }),
+	gameString("white", "White player", func(g *models.Game) string { return g.White }),
+	gameString("black", "Black player", func(g *models.Game) string { return g.Black }),
+	gameString("result", "1-0, 0-1, 1/2-1/2 or *", func(g *models.Game) string { return g.Result }),
+	rating("white_elo", "White's rating", func(g *models.Game) int { return g.WhiteElo }),
+	rating("black_elo", "Black's rating", func(g *models.Game) int { return g.BlackElo }),
+	gameString("eco", "ECO tag", func(g *models.Game) string { return g.ECO }),
+	gameString("opening", "Opening tag", func(g *models.Game) string { return g.Opening }),
+	gameString("variation", "Variation tag", func(g *models.Game) string { return g.Variation }),
+	gameString("computed_eco", "ECO code classified from the moves", func(g *models.Game) string { return g.ComputedECO }),
+	gameString("computed_opening", "Opening classified from the moves", func(g *models.Game) string { return g.ComputedOpening }),
+	gameString("computed_variation", "Variation classified from the moves", func(g *models.Game) string { return g.ComputedVariation }),
+	gameString("time_control", "TimeControl tag", func(g *models.Game) string { return g.TimeControl }),
+	gameString("time_class", "bullet, blitz, rapid, classical or correspondence", func(g *models.Game) string { return g.TimeClass }),
+	gameInt("base_seconds", "Base time of the time control in seconds", func(g *models.Game) int { return g.BaseSeconds }),
+	gameInt("increment_seconds", "Increment of the time control in seconds", func(g *models.Game) int { return g.IncrementSeconds }),
+	plyInt("ply", "Ply of the move, 1 for White's first move", func(p *models.PlyFeatures) int { return p.Ply }),
+	plyInt("move_number", "Full move number of the move", func(p *models.PlyFeatures) int { return p.MoveNumber }),
+	plyString("color", "Color of the player of the move, w or b", func(p *models.PlyFeatures) string { return p.Color }),
+	plyString("san", "Move in SAN, without check markers", func(p *models.PlyFeatures) string { return p.SAN }),
+	plyString("uci", "Move in UCI notation", func(p *models.PlyFeatures) string { return p.UCI }),
+	plyString("fen", "FEN of the position after the move", func(p *models.PlyFeatures) string { return p.FEN }),
+	plyInt("white_material", "White's material after the move, pawn = 1", func(p *models.PlyFeatures) int { return p.WhiteMaterial }),
+	plyInt("black_material", "Black's material after the move, pawn = 1", func(p *models.PlyFeatures) int { return p.BlackMaterial }),
+	plyNullable("eval_cp", "Evaluation after the move in centipawns for White, imported or from the engine", func(p *models.PlyFeatures) *int64 { return intPtr64(p.EvalCP) }),
+	plyNullable("eval_mate", "Mate in moves after the move, positive when White mates", func(p *models.PlyFeatures) *int64 { return intPtr64(p.EvalMate) }),
+	plyNullable("clock_ms", "Clock of the player of the move after it, in milliseconds", func(p *models.PlyFeatures) *int64 { return p.ClockMS }),
+}
+
+// Schema returns the columns that can be selected for a kind of rows.
+func Schema(rows string) []Column {
+	var columns []Column
+	for _, c := range Columns {
+		if !c.Ply || rows == RowsPlies {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// SelectColumns returns the named columns in the given order, or every
+// column of the kind of rows when names is empty.
+func SelectColumns(rows string, names []string) ([]Column, error) {
+	if rows != RowsGames && rows != RowsPlies {
+		return nil, fmt.Errorf("unknown rows %q", rows)
+	}
+	schema := Schema(rows)
+	if len(names) == 0 {
+		return schema, nil
+	}
+
+	var columns []Column
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range schema {
+			if c.Name == name {
+				columns = append(columns, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q for %s", name, rows)
+		}
+	}
+	return columns, nil
+}
+
+// Writer writes the rows of a table.
+type Writer interface {
+	// Write writes a row, with a value for every column.
+	Write(row Row) error
+	// Close writes any buffered rows and the end of the table. It does not
+	// close the underlying writer.
+	Close() error
+}
+
+// IsFormat reports whether format is a table format.
+func IsFormat(format string) bool {
+	return format == FormatCSV || format == FormatParquet
+}
+
+// ContentType returns the media type of a table format.
+func ContentType(format string) string {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewWriter returns a writer of a table with the given columns.
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	}
+	return nil, fmt.Errorf("unknown table format %q", format)
+}
@@ -0,0 +1,156 @@
+// Package timecontrol parses PGN TimeControl tags and classifies games by
+// speed the way online servers do.
+package timecontrol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	Bullet         = "bullet"
+	Blitz          = "blitz"
+	Rapid          = "rapid"
+	Classical      = "classical"
+	Correspondence = "correspondence"
+)
+
+var Classes = []string{Bullet, Blitz, Rapid, Classical, Correspondence}
+
+// correspondenceSeconds is the time per move from which a moves-per-period
+// control such as chess.com's "1/259200" counts as correspondence.
+const correspondenceSeconds = 24 * 60 * 60
+
+// Period is one field of a TimeControl tag: Moves moves in Seconds seconds
+// ("40/7200"), sudden death with an optional increment ("300+2"), or a
+// sandclock ("*180").
+type Period struct {
+	Moves     int  `json:"moves,omitempty"`
+	Seconds   int  `json:"seconds"`
+	Increment int  `json:"increment,omitempty"`
+	SandClock bool `json:"sandclock,omitempty"`
+}
+
+type TimeControl struct {
+	Raw       string   `json:"raw"`
+	Unlimited bool     `json:"unlimited,omitempty"`
+	Periods   []Period `json:"periods,omitempty"`
+}
+
+func IsClass(s string) bool {
+	for _, class := range Classes {
+		if s == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads a TimeControl tag value as described in the PGN standard, with
+// periods separated by colons. "-" marks a game without a time control.
+func Parse(s string) (*TimeControl, error) {
+	s = strings.TrimSpace(s)
+	tc := &TimeControl{Raw: s}
+
+	switch s {
+	case "", "?":
+		return nil, fmt.Errorf("unknown time control")
+	case "-":
+		tc.Unlimited = true
+		return tc, nil
+	}
+
+	for _, field := range strings.Split(s, ":") {
+		period, err := parsePeriod(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time control %q: %w", s, err)
+		}
+		tc.Periods = append(tc.Periods, period)
+	}
+
+	return tc, nil
+}
+
+func parsePeriod(field string) (Period, error) {
+	var period Period
+	var err error
+
+	if strings.HasPrefix(field, "*") {
+		period.SandClock = true
+		period.Seconds, err = strconv.Atoi(field[1:])
+		return period, err
+	}
+
+	if idx := strings.Index(field, "/"); idx >= 0 {
+		if period.Moves, err = strconv.Atoi(field[:idx]); err != nil {
+			return period, err
+		}
+		field = field[idx+1:]
+	}
+
+	if idx := strings.Index(field, "+"); idx >= 0 {
+		if period.Increment, err = strconv.Atoi(field[idx+1:]); err != nil {
+			return period, err
+		}
+		field = field[:idx]
+	}
+
+	period.Seconds, err = strconv.Atoi(field)
+	return period, err
+}
+
+// Base returns the starting clock time in seconds.
+func (tc *TimeControl) Base() int {
+	if len(tc.Periods) == 0 {
+		return 0
+	}
+	return tc.Periods[0].Seconds
+}
+
+// Increment returns the per-move increment of the first period in seconds.
+func (tc *TimeControl) Increment() int {
+	if len(tc.Periods) == 0 {
+		return 0
+	}
+	return tc.Periods[0].Increment
+}
+
+// Moves returns the number of moves of the first period, or 0 when the whole
+// game is played on one clock.
+func (tc *TimeControl) Moves() int {
+	if len(tc.Periods) == 0 {
+		return 0
+	}
+	return tc.Periods[0].Moves
+}
+
+// Class estimates the duration of a game as base time plus 40 increments and
+// classifies it with the lichess limits. Unlimited games and controls giving a
+// day or more per move are correspondence.
+func (tc *TimeControl) Class() string {
+	if tc.Unlimited {
+		return Correspondence
+	}
+	if len(tc.Periods) == 0 {
+		return ""
+	}
+
+	first := tc.Periods[0]
+	if first.Moves > 0 && first.Seconds/first.Moves >= correspondenceSeconds {
+		return Correspondence
+	}
+
+	estimate := first.Seconds + 40*first.Increment
+	switch {
+	case estimate < 180:
+		return Bullet
+	case estimate < 480:
+		return Blitz
+	case estimate < 1500:
+		return Rapid
+	case first.Seconds >= correspondenceSeconds:
+		return Correspondence
+	}
+	return Classical
+}
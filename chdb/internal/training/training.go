@@ -0,0 +1,170 @@
+// Package training schedules repertoire and puzzle positions for review with
+// the SM-2 spaced-repetition algorithm.
+package training
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/chdb/chessdb/internal/models"
+)
+
+// Card sources.
+const (
+	SourceRepertoire = "repertoire"
+	SourcePuzzle     = "puzzle"
+)
+
+const (
+	InitialEase = 2.5
+	minEase     = 1.3
+
+	// Answers are graded from 0 (no idea) to 5 (perfect recall); a grade of 3
+	// or more counts as remembered. Without an explicit grade a correct
+	// answer scores 4 and a wrong one 1.
+	MaxQuality     = 5
+	passQuality    = 3
+	correctQuality = 4
+	wrongQuality   = 1
+)
+
+// DefaultQuality returns the grade of an answer given without one.
+func DefaultQuality(correct bool) int {
+	if correct {
+		return correctQuality
+	}
+	return wrongQuality
+}
+
+// Schedule applies a graded review to a card following SM-2: remembered cards
+// are shown again after one day, six days and then intervals growing by the
+// card's ease, while forgotten cards start over. A wrong answer is never
+// graded as remembered.
+func Schedule(card *models.TrainingCard, correct bool, quality int, now time.Time) {
+	if !correct && quality >= passQuality {
+		quality = passQuality - 1
+	}
+
+	if quality >= passQuality {
+		switch card.Repetitions {
+		case 0:
+			card.IntervalDays = 1
+		case 1:
+			card.IntervalDays = 6
+		default:
+			card.IntervalDays = int(math.Round(float64(card.IntervalDays) * card.Ease))
+		}
+		card.Repetitions++
+	} else {
+		if card.Repetitions > 0 {
+			card.Lapses++
+		}
+		card.Repetitions = 0
+		card.IntervalDays = 1
+	}
+
+	miss := float64(MaxQuality - quality)
+	card.Ease += 0.1 - miss*(0.08+miss*0.02)
+	if card.Ease < minEase {
+		card.Ease = minEase
+	}
+
+	card.LastReviewed = &now
+	card.DueAt = now.AddDate(0, 0, card.IntervalDays)
+}
+
+// RepertoirePositions returns the positions of a repertoire where its color is
+// to move and the repertoire gives an answer.
+func RepertoirePositions(rep *models.Repertoire) []string {
+	var fens []string
+	if rep.Color == "w" && len(rep.Moves) > 0 {
+		fens = append(fens, chess.StartingPosition().String())
+	}
+
+	var walk func(nodes []*models.RepertoireNode)
+	walk = func(nodes []*models.RepertoireNode) {
+		for _, node := range nodes {
+			if len(node.Children) > 0 && sideToMove(node.FEN) == rep.Color {
+				fens = append(fens, node.FEN)
+			}
+			walk(node.Children)
+		}
+	}
+	walk(rep.Moves)
+
+	return fens
+}
+
+// RepertoireAnswers returns the repertoire moves from a position. Positions
+// are compared without the move counters, so transpositions are found too.
+func RepertoireAnswers(rep *models.Repertoire, fen string) []*models.RepertoireNode {
+	if samePosition(fen, chess.StartingPosition().String()) {
+		return rep.Moves
+	}
+
+	var answers []*models.RepertoireNode
+	var walk func(nodes []*models.RepertoireNode)
+	walk = func(nodes []*models.RepertoireNode) {
+		for _, node := range nodes {
+			if samePosition(node.FEN, fen) {
+				answers = append(answers, node.Children...)
+			}
+			walk(node.Children)
+		}
+	}
+	walk(rep.Moves)
+
+	return answers
+}
+
+// MainLine follows the first continuation of a repertoire move to the end of
+// the tree and returns the moves in SAN, starting with the move itself.
+func MainLine(node *models.RepertoireNode) []string {
+	var line []string
+	for node != nil {
+		line = append(line, node.SAN)
+		if len(node.Children) == 0 {
+			break
+		}
+		node = node.Children[0]
+	}
+	return line
+}
+
+// ResolveMove accepts a move in UCI or SAN and returns it in UCI if it is legal
+// in the position.
+func ResolveMove(fen, move string) (string, error) {
+	pos := &chess.Position{}
+	if err := pos.UnmarshalText([]byte(fen)); err != nil {
+		return "", err
+	}
+
+	move = strings.TrimRight(strings.TrimSpace(move), "!?")
+	for _, m := range pos.ValidMoves() {
+		uci := chess.UCINotation{}.Encode(pos, m)
+		san := chess.AlgebraicNotation{}.Encode(pos, m)
+		if move == uci || move == san || move == strings.TrimRight(san, "+#") {
+			return uci, nil
+		}
+	}
+
+	return "", fmt.Errorf("illegal move %q", move)
+}
+
+func sideToMove(fen string) string {
+	if fields := strings.Fields(fen); len(fields) > 1 {
+		return fields[1]
+	}
+	return ""
+}
+
+func samePosition(a, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	if len(fa) < 4 || len(fb) < 4 {
+		return a == b
+	}
+	return strings.Join(fa[:4], " ") == strings.Join(fb[:4], " ")
+}